@@ -1,8 +1,7 @@
-// +build darwin
-
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -18,102 +17,131 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/zyxar/mediastream/lib/avfoundation"
-	"github.com/zyxar/mediastream/lib/codec/openh264"
-	"github.com/zyxar/mediastream/lib/codec/vpx"
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/codec"
+	_ "github.com/zyxar/mediastream/lib/codec/openh264"
+	_ "github.com/zyxar/mediastream/lib/codec/vpx"
 	"github.com/zyxar/mediastream/lib/format"
+	_ "github.com/zyxar/mediastream/lib/testsrc"
 	"github.com/zyxar/mediastream/lib/video"
+	"github.com/zyxar/mediastream/lib/y4m"
 
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
 )
 
 var (
+	selectedSource    = flag.String("source", defaultSource, "set capture source, as name:device")
 	selectedFormat    = flag.String("format", "NV12", "set pixel format")
 	selectedFrameRate = flag.Float64("framerate", 30, "set frame rate")
 	selectedOut       = flag.String("out", "", "set output file name")
-	selectedCodec     = flag.String("codec", "h264", "set codec for output (h264/vp8/vp9)")
+	selectedCodec     = flag.String("codec", "h264", "set codec for output ("+strings.Join(codec.Encoders(), "/")+")")
+	listDevices       = flag.Bool("list", false, "list devices of the capture source and exit")
+	selectedThreads   = flag.Int("threads", 1, "set goroutines converting each frame, 0 for one per CPU")
+	selectedSize      = flag.String("size", "640x480", "set capture size, as WxH")
+	selectedScale     = flag.String("scale", "", "scale frames to WxH for output")
+	selectedFilter    = flag.String("filter", "bilinear", "set scaling filter (nearest/bilinear/bicubic/area)")
+	selectedCrop      = flag.String("crop", "", "crop frames to WxH+X+Y")
+	selectedMirror    = flag.Bool("mirror", false, "mirror frames left to right")
+	selectedFlip      = flag.Bool("flip", false, "flip frames upside down")
+	selectedRotate    = flag.Int("rotate", 0, "rotate frames clockwise by 90, 180 or 270 degrees")
 )
 
 func main() {
 	flag.Parse()
+	video.SetParallelism(*selectedThreads)
+	codecName := strings.ToLower(*selectedCodec)
+	if name, ok := codecAliases[codecName]; ok {
+		codecName = name
+	}
+
+	if *listDevices {
+		name := strings.SplitN(*selectedSource, ":", 2)[0]
+		devices, err := capture.Devices(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range devices {
+			fmt.Printf("%s:%s\t%s\n", name, d.ID, d.Name)
+			for _, f := range d.Formats {
+				fmt.Printf("\t%-4s %dx%d @ %g-%g fps\n", f.PixelFormat, f.Width, f.Height, f.MinFrameRate, f.MaxFrameRate)
+			}
+		}
+		return
+	}
 
 	var pixelFormat = format.PixelFormat(strings.ToUpper(*selectedFormat))
-	s, err := avfoundation.NewSession(
-		avfoundation.Property{PixelFormat: pixelFormat, Width: 640, Height: 480, FrameRate: *selectedFrameRate})
+	size, err := parseSize(*selectedSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s, err := capture.Open(*selectedSource,
+		capture.Property{PixelFormat: pixelFormat, Width: size.X, Height: size.Y, FrameRate: *selectedFrameRate})
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer s.Close()
 	p := s.Property()
 
+	pp, err := newPostprocessor(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := pp.outputSize(image.Pt(p.Width, p.Height))
+
 	var imageBuffer = make([]byte, s.BufferSize())
-	var process = func(w io.Writer) error {
-		if _, err := s.ReadVideoFrame(imageBuffer); err != nil {
+	var process = func(ctx context.Context, fn frameFn) error {
+		info, err := s.ReadVideoFrame(ctx, imageBuffer)
+		if err != nil {
 			return err
 		}
-		_, err = w.Write(imageBuffer)
-		return err
+		return fn(info.Raw(p, imageBuffer))
 	}
 
 	if *selectedOut != "" {
-		var frameEncoder interface {
-			EncodeFrame(dst []byte, i image.Image) (int, error)
-		}
-		var payloader rtp.Payloader
-		var payloadType uint8
-		switch strings.ToLower(*selectedCodec) {
-		case "h264", "264":
-			codec, err := openh264.NewEncoder(p.Width, p.Height, 500_000, p.FrameRate)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer codec.Close()
-			frameEncoder = codec
-			payloader = &codecs.H264Payloader{}
-			payloadType = 125
-		case "vp8":
-			codec, err := vpx.NewVP8Encoder(p.Width, p.Height, 500_000, 60, p.FrameRate)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer codec.Close()
-			frameEncoder = codec
-			payloader = &codecs.VP8Payloader{}
-			payloadType = 100
-		case "vp9":
-			codec, err := vpx.NewVP9Encoder(p.Width, p.Height, 500_000, 60, p.FrameRate)
-			if err != nil {
-				log.Fatal(err)
-			}
-			defer codec.Close()
-			frameEncoder = codec
-			payloader = &codecs.VP9Payloader{}
-			payloadType = 101
-		default:
-			log.Fatalf("unsupported codec: %v", *selectedCodec)
+		frameEncoder, err := codec.NewEncoder(codecName, codec.Options{
+			Width:            out.X,
+			Height:           out.Y,
+			Bitrate:          500_000,
+			FrameRate:        p.FrameRate,
+			KeyFrameInterval: 60,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer frameEncoder.Close()
+
+		// encode in the colorimetry of the source where the codec can signal it
+		colorimetry := p.Colorimetry
+		if err := frameEncoder.SetColorimetry(colorimetry); err != nil {
+			colorimetry = video.Colorimetry{}
 		}
 
-		var frameBuffer = make([]byte, s.BufferSize())
-		enc := func(w io.Writer) writerFn {
-			return func(buf []byte) (n int, err error) {
-				img, err := video.DecodeToYUV420(p.PixelFormat, buf, p.Width, p.Height)
+		var decoder video.Decoder
+		enc := func(w writerFn) frameFn {
+			return func(f video.RawFrame) error {
+				frame, err := decoder.DecodeToYUV420(f, colorimetry)
 				if err != nil {
-					return n, err
+					return err
+				}
+				if frame, err = pp.apply(frame); err != nil {
+					return err
 				}
-				l, err := frameEncoder.EncodeFrame(frameBuffer, img)
-				if l > 0 {
-					return w.Write(frameBuffer[:l])
+				pkt, err := frameEncoder.EncodeFrame(nil, frame)
+				if err != nil || len(pkt.Data) == 0 {
+					return err
 				}
-				return n, nil
+				_, err = w(pkt)
+				return err
 			}
 		}
 
-		var writer io.Writer
+		var writer frameFn
 		uri, err := url.Parse(*selectedOut)
 		if err != nil {
 			log.Fatal(err)
@@ -125,33 +153,35 @@ func main() {
 				log.Fatal(err)
 			}
 			defer conn.Close()
-			writer = enc(newRTPWriter(conn, payloadType, payloader))
+			pf, ok := rtpPayloads[codecName]
+			if !ok {
+				log.Fatalf("no RTP payload format for codec %q", *selectedCodec)
+			}
+			writer = enc(newRTPWriter(conn, pf.payloadType, pf.payloader()))
 		default:
 			file, err := os.Create(*selectedOut)
 			if err != nil {
 				log.Fatal(err)
 			}
 			defer file.Close()
-			writer = enc(file)
+			if strings.EqualFold(filepath.Ext(*selectedOut), ".y4m") {
+				writer = newY4MWriter(file, p, pp)
+			} else {
+				writer = enc(fileWriter(file))
+			}
 		}
 
-		sig := make(chan os.Signal, 1)
-		signal.Notify(sig, syscall.SIGINT, syscall.SIGABRT, syscall.SIGTERM, syscall.SIGHUP)
-		defer signal.Stop(sig)
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGABRT, syscall.SIGTERM, syscall.SIGHUP)
+		defer stop()
 
 		for {
-			select {
-			case <-sig:
-				return
-			default:
-				if err = process(writer); err != nil {
+			if err = process(ctx, writer); err != nil {
+				if ctx.Err() == nil {
 					log.Println(err)
-					return
 				}
+				return
 			}
 		}
-
-		os.Exit(0)
 	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -161,13 +191,18 @@ func main() {
 		partHeader := make(textproto.MIMEHeader)
 		partHeader.Add("Content-Type", "image/jpeg")
 
-		enc := func(w io.Writer) writerFn {
-			return func(buf []byte) (n int, err error) {
-				img, err := video.Decode(p.PixelFormat, buf, p.Width, p.Height)
+		var decoder video.Decoder
+		enc := func(w io.Writer) frameFn {
+			return func(f video.RawFrame) error {
+				frame, err := decoder.Decode(f)
 				if err != nil {
-					return n, err
+					return err
+				}
+				img := frame.Image
+				if yuv, ok := frame.YCbCr(); ok {
+					img = video.Recolor(yuv, frame.Colorimetry, video.JPEG)
 				}
-				return n, jpeg.Encode(w, img, nil) // FIXME: n?
+				return jpeg.Encode(w, img, nil)
 			}
 		}
 
@@ -177,7 +212,7 @@ func main() {
 				log.Println(err)
 				return
 			}
-			err = process(enc(partWriter))
+			err = process(r.Context(), enc(partWriter))
 			if err != nil {
 				log.Println(err)
 				return
@@ -187,25 +222,195 @@ func main() {
 	http.ListenAndServe("localhost:5000", nil)
 }
 
-type writerFn func(p []byte) (n int, err error)
+func newY4MWriter(w io.Writer, p capture.Property, pp *postprocessor) frameFn {
+	var yw *y4m.Writer
+	var decoder video.Decoder
+	var rgb *image.YCbCr // conversion target of RGB frames
+	frameRate := y4m.Ratio{Num: int(math.Round(p.FrameRate * 1000)), Den: 1000}
+	return func(f video.RawFrame) error {
+		frame, err := decoder.Decode(f)
+		if err != nil {
+			return err
+		}
+		if _, ok := frame.YCbCr(); !ok {
+			if rgb == nil {
+				rgb = image.NewYCbCr(frame.Bounds(), image.YCbCrSubsampleRatio420)
+			}
+			if err = video.ConvertTo(rgb, frame, frame.Colorimetry); err != nil {
+				return err
+			}
+			frame.Image = rgb
+		}
+		if frame, err = pp.apply(frame); err != nil {
+			return err
+		}
+		if yw == nil {
+			h, err := y4m.HeaderFor(frame, frameRate)
+			if err != nil {
+				return err
+			}
+			h.SetColorRange(frame.Colorimetry.Range)
+			if yw, err = y4m.NewWriter(w, h); err != nil {
+				return err
+			}
+		}
+		return yw.WriteFrame(frame)
+	}
+}
+
+// postprocessor crops, mirrors, flips and rotates frames, then scales them,
+// as set by -crop, -mirror, -flip, -rotate and -scale.
+type postprocessor struct {
+	transform   video.Transform
+	transformed *image.YCbCr
+	size        image.Point // to scale to, if not zero
+	filter      video.Filter
+	siting      video.ChromaSiting
+	scaler      *video.Scaler
+	src         image.Point // of the scaler
+	scaled      *image.YCbCr
+}
 
-func (w writerFn) Write(p []byte) (n int, err error) { return w(p) }
+// newPostprocessor returns nil if frames are to be left as they are.
+func newPostprocessor(p capture.Property) (*postprocessor, error) {
+	pp := &postprocessor{
+		transform: video.Transform{Mirror: *selectedMirror, Flip: *selectedFlip, Rotate: video.Rotation(*selectedRotate)},
+		filter:    -1,
+	}
+	if pp.transform.Rotate%90 != 0 {
+		return nil, fmt.Errorf("invalid rotation: %d", *selectedRotate)
+	}
+	if *selectedCrop != "" {
+		var w, h, x, y int
+		if _, err := fmt.Sscanf(*selectedCrop, "%dx%d+%d+%d", &w, &h, &x, &y); err != nil || w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("invalid crop: %q", *selectedCrop)
+		}
+		pp.transform.Crop = image.Rect(x, y, x+w, y+h)
+	}
+	if *selectedScale != "" {
+		size, err := parseSize(*selectedScale)
+		if err != nil {
+			return nil, err
+		}
+		pp.size = size
+	}
+	if pp.transform == (video.Transform{}) && pp.size == (image.Point{}) {
+		return nil, nil
+	}
+	for f := video.Nearest; f <= video.Area; f++ {
+		if strings.EqualFold(*selectedFilter, f.String()) {
+			pp.filter = f
+		}
+	}
+	if pp.filter < 0 {
+		return nil, fmt.Errorf("unsupported filter: %v", *selectedFilter)
+	}
+	// decoded JPEG has its chroma centred
+	if p.PixelFormat.Canonical() == format.MJPG {
+		pp.siting = video.SitingCenter
+	}
+	return pp, nil
+}
+
+// outputSize returns the size of frames of size in after pp.
+func (pp *postprocessor) outputSize(in image.Point) image.Point {
+	if pp == nil {
+		return in
+	}
+	if pp.size != (image.Point{}) {
+		return pp.size
+	}
+	return pp.transform.Size(image.Rectangle{Max: in})
+}
+
+// apply returns the Y'CbCr frame f processed, in a buffer reused by the next
+// call.
+func (pp *postprocessor) apply(f video.Frame) (video.Frame, error) {
+	img, ok := f.YCbCr()
+	if pp == nil || !ok {
+		return f, nil
+	}
+	if pp.transform != (video.Transform{}) {
+		size, sr := pp.transform.Size(img.Rect), pp.transform.SubsampleRatio(img.SubsampleRatio)
+		if pp.transformed == nil || pp.transformed.Rect.Size() != size || pp.transformed.SubsampleRatio != sr {
+			pp.transformed = image.NewYCbCr(image.Rectangle{Max: size}, sr)
+		}
+		if err := pp.transform.ApplyTo(pp.transformed, img); err != nil {
+			return f, err
+		}
+		img = pp.transformed
+	}
+	if pp.size != (image.Point{}) && img.Rect.Size() != pp.size {
+		if err := pp.scale(img); err != nil {
+			return f, err
+		}
+		img = pp.scaled
+	}
+	f.Image = img
+	return f, nil
+}
+
+// scale scales img into pp.scaled.
+func (pp *postprocessor) scale(img *image.YCbCr) error {
+	if pp.scaler == nil || pp.src != img.Rect.Size() || pp.scaled.SubsampleRatio != img.SubsampleRatio {
+		s, err := video.NewScaler(pp.size, img.Rect.Size(), img.SubsampleRatio, pp.filter, pp.siting)
+		if err != nil {
+			return err
+		}
+		pp.scaler, pp.src = s, img.Rect.Size()
+		pp.scaled = image.NewYCbCr(image.Rectangle{Max: pp.size}, img.SubsampleRatio)
+	}
+	return pp.scaler.Scale(pp.scaled, img)
+}
+
+func parseSize(s string) (size image.Point, err error) {
+	if _, err = fmt.Sscanf(s, "%dx%d", &size.X, &size.Y); err != nil || size.X <= 0 || size.Y <= 0 {
+		return size, fmt.Errorf("invalid size: %q", s)
+	}
+	return size, nil
+}
+
+// frameFn consumes a raw frame.
+type frameFn func(f video.RawFrame) error
+
+// writerFn writes an encoded frame.
+type writerFn func(pkt codec.Packet) (n int, err error)
+
+func fileWriter(w io.Writer) writerFn {
+	return func(pkt codec.Packet) (n int, err error) { return w.Write(pkt.Data) }
+}
+
+// codecAliases maps other names accepted by -codec to registered ones.
+var codecAliases = map[string]string{
+	"264": "h264",
+}
+
+// rtpPayloads maps codec names to their RTP payload formats.
+var rtpPayloads = map[string]struct {
+	payloadType uint8
+	payloader   func() rtp.Payloader
+}{
+	"h264": {125, func() rtp.Payloader { return &codecs.H264Payloader{} }},
+	"vp8":  {100, func() rtp.Payloader { return &codecs.VP8Payloader{} }},
+	"vp9":  {101, func() rtp.Payloader { return &codecs.VP9Payloader{} }},
+}
 
 func newRTPWriter(w io.Writer, payloadType uint8, payloader rtp.Payloader) writerFn {
 	const mtu = 1000
-	const clockRate = 9000
-	var timestamp time.Time
-	var samples = func() (n uint32) {
-		now := time.Now()
-		n = uint32(math.Round(clockRate * now.Sub(timestamp).Seconds()))
-		timestamp = now
+	const clockRate = 90000
+	var last *time.Duration
+	var samples = func(pts time.Duration) (n uint32) {
+		if last != nil {
+			n = uint32(math.Round(clockRate * (pts - *last).Seconds()))
+		}
+		last = &pts
 		return
 	}
 	pz := rtp.NewPacketizer(mtu, payloadType, rand.Uint32(),
 		payloader, rtp.NewRandomSequencer(), clockRate)
 	pktBuffer := make([]byte, mtu)
-	return func(p []byte) (n int, err error) {
-		for _, pkt := range pz.Packetize(p, samples()) {
+	return func(p codec.Packet) (n int, err error) {
+		for _, pkt := range pz.Packetize(p.Data, samples(p.PTS)) {
 			l, err := pkt.MarshalTo(pktBuffer)
 			if err != nil {
 				return n, err
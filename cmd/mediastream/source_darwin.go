@@ -0,0 +1,5 @@
+package main
+
+import _ "github.com/zyxar/mediastream/lib/avfoundation"
+
+const defaultSource = "avf:"
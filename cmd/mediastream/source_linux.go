@@ -0,0 +1,5 @@
+package main
+
+import _ "github.com/zyxar/mediastream/lib/v4l2"
+
+const defaultSource = "v4l2:/dev/video0"
@@ -5,62 +5,131 @@ package avfoundation
 // #cgo CFLAGS: -x objective-c
 // #cgo LDFLAGS: -framework AVFoundation -framework Foundation -framework CoreMedia -framework CoreVideo
 /*
+#include <stdlib.h>
 #include "avfoundation.h"
 
 extern void closeSession(CaptureSession *s);
-extern int initSession(CaptureSession *s);
-extern int readVideoFrame(CaptureSession* s, uint8_t *buf, size_t size);
-extern size_t getVideoBufferSize(CaptureSession *s);
+extern int initSession(CaptureSession *s, const char *deviceID);
+extern int readVideoFrame(CaptureSession* s, uint8_t *buf, size_t size, int64_t *pts, int64_t *duration,
+                          FrameLayout *layout, int timeout);
+extern void interruptRead(CaptureSession *s);
+extern size_t getVideoBufferSize(CaptureSession *s, int timeout);
 */
 import "C"
 import (
+	"context"
 	"syscall"
+	"time"
+	"unsafe"
 
-	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/video"
 )
 
-type Property struct {
-	format.PixelFormat
-	Width, Height int
-	FrameRate     float64
-}
+// Property.DeviceID selects a device by unique ID, or by index into Devices();
+// the default device is used if empty.
+type Property = capture.Property
 
 type Session struct {
 	s       C.CaptureSession
 	p       Property
 	bufSize int
+	seq     uint64
 }
 
 func NewSession(p Property) (*Session, error) {
 	var s Session
-	s.s.property.pixelFormat = pixelFormats[p.PixelFormat]
+	id, err := resolveDevice(p.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	if p.PixelFormat != "" { // or the first format of the device
+		c, ok := pixelFormatToFourCharCode(p.PixelFormat)
+		if !ok {
+			return nil, video.ErrUnsupportedPixelFormat
+		}
+		s.s.property.pixelFormat = c
+	}
 	s.s.property.width = C.int(p.Width)
 	s.s.property.height = C.int(p.Height)
 	s.s.property.frameRate = C.double(p.FrameRate)
-	ret := C.initSession(&s.s)
+	deviceID := C.CString(id)
+	defer C.free(unsafe.Pointer(deviceID))
+	ret := C.initSession(&s.s, deviceID)
 	if ret != 0 {
 		return nil, syscall.Errno(ret)
 	}
-	return s.init(), nil
+	s.p.DeviceID = id
+	return s.init()
 }
 
-func (s *Session) init() *Session {
-	size := C.getVideoBufferSize(&s.s)
+func (s *Session) init() (*Session, error) {
+	size := C.getVideoBufferSize(&s.s, C.int(capture.StallTimeout/time.Millisecond))
+	if size == 0 {
+		C.closeSession(&s.s)
+		return nil, capture.ErrTimeout
+	}
 	s.bufSize = int(size)
 	s.p.Width = int(s.s.property.width)
 	s.p.Height = int(s.s.property.height)
 	s.p.FrameRate = float64(s.s.property.frameRate)
 	s.p.PixelFormat, _ = fourCharCodeToPixelFormat(s.s.property.pixelFormat)
-	return s
+	s.p.Colorimetry = colorimetry(s.s.property.pixelFormat, s.s.property.ycbcrMatrix)
+	return s, nil
 }
 
 func (s *Session) BufferSize() int    { return s.bufSize }
 func (s *Session) Property() Property { return s.p }
 func (s *Session) Close()             { C.closeSession(&s.s) }
-func (s *Session) ReadVideoFrame(buf []byte) (i int, err error) {
-	ret := C.readVideoFrame(&s.s, (*C.uchar)(&buf[0]), C.size_t(len(buf)))
+
+// ReadVideoFrame timestamps frames with the presentation time of their sample
+// buffer, on the host time clock.
+func (s *Session) ReadVideoFrame(ctx context.Context, buf []byte) (info capture.FrameInfo, err error) {
+	if len(buf) == 0 {
+		return info, syscall.ENOSPC
+	}
+	if err = ctx.Err(); err != nil {
+		return info, err
+	}
+	stop := capture.AfterFunc(ctx, func() { C.interruptRead(&s.s) })
+	defer stop()
+	deadline := capture.Deadline(ctx)
+	var pts, duration C.int64_t
+	var layout C.FrameLayout
+	var ret C.int
+	for {
+		timeout := time.Until(deadline) / time.Millisecond
+		if timeout < 0 {
+			timeout = 0
+		}
+		ret = C.readVideoFrame(&s.s, (*C.uchar)(&buf[0]), C.size_t(len(buf)), &pts, &duration, &layout, C.int(timeout))
+		if ret != -C.int(syscall.EINTR) {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			return info, err
+		}
+	}
+	if ret == -C.int(syscall.ETIMEDOUT) {
+		return info, capture.ErrTimeout
+	}
 	if ret < 0 {
-		return 0, syscall.EAGAIN
+		return info, syscall.Errno(-ret)
+	}
+	info.Size = int(ret)
+	// CoreVideo pads rows; planes are copied out back to back
+	if n := int(layout.count); n > 0 {
+		info.Planes = make([]video.Plane, n)
+		for i := range info.Planes {
+			info.Planes[i] = video.Plane{Offset: int(layout.offset[i]), Stride: int(layout.stride[i])}
+		}
+	}
+	info.PTS = time.Duration(pts)
+	info.Duration = time.Duration(duration)
+	if duration < 0 && s.p.FrameRate > 0 {
+		info.Duration = time.Duration(float64(time.Second) / s.p.FrameRate)
 	}
-	return int(ret), nil
+	info.Sequence = s.seq
+	s.seq++
+	return info, nil
 }
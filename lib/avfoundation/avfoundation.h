@@ -2,21 +2,50 @@
 
 #import <AVFoundation/AVFoundation.h>
 #include <pthread.h>
+#include <time.h>
 
 typedef struct
 {
     FourCharCode pixelFormat;
     int width, height;
     double frameRate;
+    int ycbcrMatrix; // 601, 709 or 2020, from the buffer attachment; 0 if unknown
 } VideoProperty;
 
 
 typedef struct
 {
     pthread_mutex_t          lock;
+    pthread_cond_t           cond; // signalled on a new buffer or an interrupt
+    int                      interrupted;
     VideoProperty            property;
     AVCaptureSession         *session;
     AVCaptureVideoDataOutput *output;
     CMSampleBufferRef        buffer;
     void                     *delegate;
 } CaptureSession;
+
+#define MAX_PLANE_COUNT 4
+
+// FrameLayout locates the planes of a frame copied out by readVideoFrame.
+typedef struct
+{
+    int count;
+    int offset[MAX_PLANE_COUNT];
+    int stride[MAX_PLANE_COUNT];
+} FrameLayout;
+
+typedef struct
+{
+    FourCharCode pixelFormat;
+    int width, height;
+    double minFrameRate, maxFrameRate;
+} FormatInfo;
+
+typedef struct
+{
+    char       uniqueID[256];
+    char       name[256];
+    FormatInfo *formats;
+    int        formatCount;
+} DeviceInfo;
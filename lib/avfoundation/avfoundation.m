@@ -29,6 +29,7 @@
         CFRelease(self->cs->buffer);
     }
     self->cs->buffer = (CMSampleBufferRef)CFRetain(frameBuffer);
+    pthread_cond_signal(&self->cs->cond);
     pthread_mutex_unlock(&self->cs->lock);
 }
 
@@ -104,6 +105,7 @@ void closeSession(CaptureSession *s)
     s->delegate = nil;
 
     pthread_mutex_destroy(&(s->lock));
+    pthread_cond_destroy(&(s->cond));
 
     if (s->buffer) {
         CFRelease(s->buffer);
@@ -112,7 +114,7 @@ void closeSession(CaptureSession *s)
 }
 
 
-int initSession(CaptureSession *s)
+int initSession(CaptureSession *s, const char *deviceID)
 {
     NSError *error = nil;
     AVCaptureInput* input = nil;
@@ -123,10 +125,21 @@ int initSession(CaptureSession *s)
 
     NSAutoreleasePool *pool = [[NSAutoreleasePool alloc] init];
     pthread_mutex_init(&(s->lock), nil);
+    pthread_cond_init(&(s->cond), nil);
+    s->interrupted = 0;
 
-    AVCaptureDevice *device = [AVCaptureDevice defaultDeviceWithMediaType:AVMediaTypeVideo];
-    if (device == nil)
-        goto fail;
+    AVCaptureDevice *device = nil;
+    if (deviceID && *deviceID) {
+        device = [AVCaptureDevice deviceWithUniqueID:[NSString stringWithUTF8String:deviceID]];
+    } else {
+        device = [AVCaptureDevice defaultDeviceWithMediaType:AVMediaTypeVideo];
+    }
+    if (device == nil) {
+        [pool release];
+        pthread_mutex_destroy(&(s->lock));
+        pthread_cond_destroy(&(s->cond));
+        return ENODEV;
+    }
 
     s->session = [[AVCaptureSession alloc] init];
     input = (AVCaptureInput*) [[[AVCaptureDeviceInput alloc] initWithDevice:device error:&error] autorelease];
@@ -188,71 +201,202 @@ fail:
 }
 
 
-int readVideoFrame(CaptureSession* s, uint8_t *buf, size_t size)
+static int64_t toNanoseconds(CMTime t)
+{
+    if (!CMTIME_IS_NUMERIC(t)) {
+        return -1;
+    }
+    return CMTimeConvertScale(t, 1000000000, kCMTimeRoundingMethod_Default).value;
+}
+
+void interruptRead(CaptureSession *s)
+{
+    pthread_mutex_lock(&s->lock);
+    s->interrupted = 1;
+    pthread_cond_broadcast(&s->cond);
+    pthread_mutex_unlock(&s->lock);
+}
+
+// takeBuffer waits up to timeout milliseconds for a sample buffer and takes
+// ownership of it; on failure it returns nil with *err set to EINTR or
+// ETIMEDOUT.
+static CMSampleBufferRef takeBuffer(CaptureSession *s, int timeout, int *err)
+{
+    CMSampleBufferRef buffer = nil;
+    struct timespec deadline;
+    clock_gettime(CLOCK_REALTIME, &deadline);
+    deadline.tv_sec += timeout / 1000;
+    deadline.tv_nsec += (long)(timeout % 1000) * 1000000;
+    if (deadline.tv_nsec >= 1000000000) {
+        deadline.tv_sec++;
+        deadline.tv_nsec -= 1000000000;
+    }
+
+    pthread_mutex_lock(&s->lock);
+    while (s->buffer == nil && !s->interrupted) {
+        if (pthread_cond_timedwait(&s->cond, &s->lock, &deadline) == ETIMEDOUT) {
+            break;
+        }
+    }
+    if (s->interrupted) {
+        s->interrupted = 0;
+        *err = EINTR;
+    } else if (s->buffer == nil) {
+        *err = ETIMEDOUT;
+    } else {
+        buffer = s->buffer;
+        s->buffer = nil;
+    }
+    pthread_mutex_unlock(&s->lock);
+    return buffer;
+}
+
+int readVideoFrame(CaptureSession* s, uint8_t *buf, size_t size, int64_t *pts, int64_t *duration,
+                   FrameLayout *layout, int timeout)
 {
-    void *data = nil;
+    int err = 0;
     int len = 0;
-    int status = 0;
-    do {
-        CVImageBufferRef imageBuffer;
-        pthread_mutex_lock(&s->lock);
-        if (s->buffer != nil) {
-            imageBuffer = CMSampleBufferGetImageBuffer(s->buffer);
-            if (imageBuffer) {
-                status = CVPixelBufferLockBaseAddress(imageBuffer, 0);
-                if (status == kCVReturnSuccess) {
-                    if (CVPixelBufferIsPlanar(imageBuffer)) {
-                        size_t count = CVPixelBufferGetPlaneCount(imageBuffer);
-                        int planeSize = 0;
-                        for (int i = 0; i < count; i++) {
-                            data = CVPixelBufferGetBaseAddressOfPlane(imageBuffer, i);
-                            planeSize = CVPixelBufferGetBytesPerRowOfPlane(imageBuffer, i) *
-                                        CVPixelBufferGetHeightOfPlane(imageBuffer, i);
-                            memcpy(buf, data, planeSize);
-                            buf += planeSize;
-                            len += planeSize;
-                        }
-                    } else {
-                        data = CVPixelBufferGetBaseAddress(imageBuffer);
-                        len = CVPixelBufferGetBytesPerRow(imageBuffer) * CVPixelBufferGetHeight(imageBuffer);
-                        // FIXME: ensure len < CVPixelBufferGetDataSize(imageBuffer)
-                        memcpy(buf, data, len);
-                    }
-                    CVPixelBufferUnlockBaseAddress(imageBuffer, 0);
-                }
+    layout->count = 0;
+    CMSampleBufferRef buffer = takeBuffer(s, timeout, &err);
+    if (buffer == nil) {
+        return -err;
+    }
+
+    *pts = toNanoseconds(CMSampleBufferGetPresentationTimeStamp(buffer));
+    *duration = toNanoseconds(CMSampleBufferGetDuration(buffer));
+    CVImageBufferRef imageBuffer = CMSampleBufferGetImageBuffer(buffer);
+    if (!imageBuffer || CVPixelBufferLockBaseAddress(imageBuffer, 0) != kCVReturnSuccess) {
+        CFRelease(buffer);
+        return -EAGAIN;
+    }
+    if (CVPixelBufferIsPlanar(imageBuffer)) {
+        size_t count = CVPixelBufferGetPlaneCount(imageBuffer);
+        if (count > MAX_PLANE_COUNT) {
+            count = MAX_PLANE_COUNT;
+        }
+        for (int i = 0; i < count; i++) {
+            layout->offset[i] = len;
+            layout->stride[i] = CVPixelBufferGetBytesPerRowOfPlane(imageBuffer, i);
+            len += CVPixelBufferGetBytesPerRowOfPlane(imageBuffer, i) *
+                   CVPixelBufferGetHeightOfPlane(imageBuffer, i);
+        }
+        layout->count = count;
+        if (len <= size) {
+            for (int i = 0; i < count; i++) {
+                void *data = CVPixelBufferGetBaseAddressOfPlane(imageBuffer, i);
+                int planeSize = CVPixelBufferGetBytesPerRowOfPlane(imageBuffer, i) *
+                                CVPixelBufferGetHeightOfPlane(imageBuffer, i);
+                memcpy(buf, data, planeSize);
+                buf += planeSize;
             }
-            CFRelease(s->buffer);
-            s->buffer = nil;
         }
-        pthread_mutex_unlock(&s->lock);
-    } while (!data);
+    } else {
+        len = CVPixelBufferGetBytesPerRow(imageBuffer) * CVPixelBufferGetHeight(imageBuffer);
+        layout->offset[0] = 0;
+        layout->stride[0] = CVPixelBufferGetBytesPerRow(imageBuffer);
+        layout->count = 1;
+        if (len <= size) {
+            memcpy(buf, CVPixelBufferGetBaseAddress(imageBuffer), len);
+        }
+    }
+    CVPixelBufferUnlockBaseAddress(imageBuffer, 0);
+    CFRelease(buffer);
 
-    if (status != kCVReturnSuccess) {
-        return -1;
+    if (len > size) {
+        return -ENOSPC;
     }
     return len;
 }
 
-size_t getVideoBufferSize(CaptureSession *s)
+size_t getVideoBufferSize(CaptureSession *s, int timeout)
 {
+    int err = 0;
     size_t size = 0;
-    CMSampleBufferRef buffer = nil;
-    do {
-        CFRunLoopRunInMode(kCFRunLoopDefaultMode, 0.1, YES);
-        pthread_mutex_lock(&s->lock);
-        if (s->buffer != nil) {
-            buffer = s->buffer;
-            s->buffer = nil;
-        }
-        pthread_mutex_unlock(&s->lock);
-    } while (!buffer);
+    CMSampleBufferRef buffer = takeBuffer(s, timeout, &err);
+    if (buffer == nil) {
+        return 0;
+    }
 
     CVImageBufferRef imageBuffer = CMSampleBufferGetImageBuffer(buffer);
     if (imageBuffer) {
         size = CVPixelBufferGetDataSize(imageBuffer);
+        s->property.ycbcrMatrix = 0;
+        CFTypeRef matrix = CVBufferGetAttachment(imageBuffer, kCVImageBufferYCbCrMatrixKey, NULL);
+        if (matrix) {
+            if (CFEqual(matrix, kCVImageBufferYCbCrMatrix_ITU_R_709_2)) {
+                s->property.ycbcrMatrix = 709;
+            } else if (CFEqual(matrix, kCVImageBufferYCbCrMatrix_ITU_R_601_4)) {
+                s->property.ycbcrMatrix = 601;
+            } else if (CFEqual(matrix, kCVImageBufferYCbCrMatrix_ITU_R_2020)) {
+                s->property.ycbcrMatrix = 2020;
+            }
+        }
     }
     CFRelease(buffer);
     return size;
 }
 
+static int listFormats(AVCaptureDevice *device, DeviceInfo *info)
+{
+    int capacity = 0;
+    info->formats = NULL;
+    info->formatCount = 0;
+    for (NSObject *format in [device valueForKey:@"formats"]) {
+        CMFormatDescriptionRef desc = (CMFormatDescriptionRef) [format performSelector:@selector(formatDescription)];
+        CMVideoDimensions dim = CMVideoFormatDescriptionGetDimensions(desc);
+        for (NSObject *range in [format valueForKey:@"videoSupportedFrameRateRanges"]) {
+            if (info->formatCount == capacity) {
+                int n = capacity ? capacity * 2 : 16;
+                FormatInfo *p = realloc(info->formats, n * sizeof(FormatInfo));
+                if (!p) {
+                    return ENOMEM;
+                }
+                info->formats = p;
+                capacity = n;
+            }
+            FormatInfo *f = &info->formats[info->formatCount++];
+            f->pixelFormat = CMFormatDescriptionGetMediaSubType(desc);
+            f->width = dim.width;
+            f->height = dim.height;
+            [[range valueForKey:@"minFrameRate"] getValue:&f->minFrameRate];
+            [[range valueForKey:@"maxFrameRate"] getValue:&f->maxFrameRate];
+        }
+    }
+    return 0;
+}
+
+void freeDevices(DeviceInfo *devices, int count)
+{
+    for (int i = 0; i < count; i++) {
+        free(devices[i].formats);
+    }
+    free(devices);
+}
 
+int listDevices(DeviceInfo **devices, int *count)
+{
+    int ret = 0;
+    NSAutoreleasePool *pool = [[NSAutoreleasePool alloc] init];
+    NSArray *list = [AVCaptureDevice devicesWithMediaType:AVMediaTypeVideo];
+
+    *count = 0;
+    *devices = calloc([list count] ? [list count] : 1, sizeof(DeviceInfo));
+    if (!*devices) {
+        [pool release];
+        return ENOMEM;
+    }
+    for (AVCaptureDevice *device in list) {
+        DeviceInfo *info = &(*devices)[*count];
+        snprintf(info->uniqueID, sizeof(info->uniqueID), "%s", [[device uniqueID] UTF8String]);
+        snprintf(info->name, sizeof(info->name), "%s", [[device localizedName] UTF8String]);
+        (*count)++;
+        if ((ret = listFormats(device, info)) != 0) {
+            freeDevices(*devices, *count);
+            *devices = NULL;
+            *count = 0;
+            break;
+        }
+    }
+    [pool release];
+    return ret;
+}
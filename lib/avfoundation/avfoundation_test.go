@@ -1,6 +1,7 @@
 package avfoundation
 
 import (
+	"context"
 	"testing"
 
 	"github.com/zyxar/mediastream/lib/format"
@@ -17,14 +18,39 @@ func TestSession(t *testing.T) {
 	t.Logf("%#v\n", s.Property())
 	buf := make([]byte, s.BufferSize())
 	for i := 0; i < 10; i++ {
-		if i, err := s.ReadVideoFrame(buf); err != nil {
+		info, err := s.ReadVideoFrame(context.Background(), buf)
+		if err != nil {
 			t.Error(err)
-		} else if i != s.BufferSize() {
+			continue
+		}
+		if info.Size != s.BufferSize() {
 			t.Error("size mismatch")
+		} else if info.Sequence != uint64(i) {
+			t.Errorf("unexpected sequence %d", info.Sequence)
 		}
-		_, err := video.Decode(p.PixelFormat, buf, p.Width, p.Height)
-		if err != nil {
+		if _, err = video.Decode(info.Raw(p, buf)); err != nil {
 			t.Error(err)
 		}
 	}
 }
+
+func TestDevices(t *testing.T) {
+	devices, err := Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices {
+		t.Logf("%s %q: %d formats", d.ID, d.Name, len(d.Formats))
+	}
+	if len(devices) == 0 {
+		t.Skip("no capture device")
+	}
+	s, err := NewSession(Property{DeviceID: devices[0].ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Property().DeviceID != devices[0].ID {
+		t.Errorf("unexpected device %q", s.Property().DeviceID)
+	}
+}
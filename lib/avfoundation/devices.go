@@ -0,0 +1,84 @@
+// +build darwin
+
+package avfoundation
+
+/*
+#include "avfoundation.h"
+
+extern int listDevices(DeviceInfo **devices, int *count);
+extern void freeDevices(DeviceInfo *devices, int count);
+*/
+import "C"
+import (
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+// Devices lists the video capture devices, with one format entry per
+// supported dimension and frame rate range.
+func Devices() ([]capture.Device, error) {
+	var devices *C.DeviceInfo
+	var count C.int
+	if ret := C.listDevices(&devices, &count); ret != 0 {
+		return nil, syscall.Errno(ret)
+	}
+	defer C.freeDevices(devices, count)
+	var list []capture.Device
+	for _, d := range (*[1 << 10]C.DeviceInfo)(unsafe.Pointer(devices))[:count:count] {
+		device := capture.Device{
+			ID:   C.GoString(&d.uniqueID[0]),
+			Name: C.GoString(&d.name[0]),
+		}
+		if d.formatCount > 0 {
+			formats := (*[1 << 20]C.FormatInfo)(unsafe.Pointer(d.formats))[:d.formatCount:d.formatCount]
+			for _, f := range formats {
+				device.Formats = append(device.Formats, capture.DeviceFormat{
+					PixelFormat:  subTypePixelFormat(f.pixelFormat),
+					Width:        int(f.width),
+					Height:       int(f.height),
+					MinFrameRate: float64(f.minFrameRate),
+					MaxFrameRate: float64(f.maxFrameRate),
+				})
+			}
+		}
+		list = append(list, device)
+	}
+	return list, nil
+}
+
+// resolveDevice maps a unique ID or an index into Devices() to a unique ID.
+func resolveDevice(id string) (string, error) {
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return id, nil
+	}
+	devices, err := Devices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		if d.ID == id {
+			return id, nil
+		}
+	}
+	if i < 0 || i >= len(devices) {
+		return "", syscall.ENODEV
+	}
+	return devices[i].ID, nil
+}
+
+// subTypePixelFormat maps the native media subtype of a device format to a
+// pixel format, or to its FourCC text if unknown.
+func subTypePixelFormat(c C.FourCharCode) format.PixelFormat {
+	if pf, ok := fourCharCodeToPixelFormat(c); ok {
+		return pf
+	}
+	if c == C.kCVPixelFormatType_420YpCbCr8BiPlanarFullRange {
+		return format.NV12
+	}
+	return format.FourCC(fourCC(c))
+}
@@ -4,21 +4,50 @@ package avfoundation
 
 // #import <AVFoundation/AVFoundation.h>
 import "C"
-import "github.com/zyxar/mediastream/lib/format"
+import (
+	"math/bits"
 
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+// codes are the Core Video pixel formats named like a format of package
+// format, or one of its aliases. kCVPixelFormatType_32BGRA is not: its pixels
+// are those of ARGB.
+var codes = []C.FourCharCode{
+	C.kCVPixelFormatType_422YpCbCr8_yuvs, // yuvs
+}
+
+// pixelFormats maps formats to Core Video pixel formats; those with other
+// names than in package format are listed here, the others are added from
+// codes.
 var pixelFormats = map[format.PixelFormat]C.FourCharCode{
 	format.I420: C.kCVPixelFormatType_420YpCbCr8Planar,
 	format.NV12: C.kCVPixelFormatType_420YpCbCr8BiPlanarVideoRange, // or C.kCVPixelFormatType_420YpCbCr8BiPlanarFullRange,
 	format.UYVY: C.kCVPixelFormatType_422YpCbCr8,
-	format.YUY2: C.kCVPixelFormatType_422YpCbCr8_yuvs,
 	format.I444: C.kCVPixelFormatType_444YpCbCr8,
 	format.RAW:  C.kCVPixelFormatType_24RGB,
 	format.BGRA: C.kCVPixelFormatType_32ARGB,
 	format.ARGB: C.kCVPixelFormatType_32BGRA,
+	format.RGBA: C.kCVPixelFormatType_32ABGR,
+}
+
+func init() {
+	for _, c := range codes {
+		if f, ok := format.ParseFourCC(fourCC(c)); ok {
+			pixelFormats[f] = c
+		}
+	}
+}
+
+// fourCC returns c with its first character in the lowest byte, as package
+// format expects.
+func fourCC(c C.FourCharCode) uint32 {
+	return bits.ReverseBytes32(uint32(c))
 }
 
 func pixelFormatToFourCharCode(pf format.PixelFormat) (c C.FourCharCode, ok bool) {
-	c, ok = pixelFormats[pf]
+	c, ok = pixelFormats[pf.Canonical()]
 	return
 }
 
@@ -30,3 +59,22 @@ func fourCharCodeToPixelFormat(c C.FourCharCode) (pf format.PixelFormat, ok bool
 	}
 	return
 }
+
+// colorimetry derives the Y'CbCr colorimetry of frames in pixel format c from
+// the matrix attached to them; RGB formats keep the default.
+func colorimetry(c C.FourCharCode, matrix C.int) (cm video.Colorimetry) {
+	switch c {
+	case C.kCVPixelFormatType_24RGB, C.kCVPixelFormatType_32ARGB,
+		C.kCVPixelFormatType_32BGRA, C.kCVPixelFormatType_32ABGR:
+		return
+	case C.kCVPixelFormatType_420YpCbCr8BiPlanarFullRange:
+		cm.Range = video.FullRange
+	}
+	switch matrix {
+	case 709:
+		cm.Space = video.BT709
+	case 2020:
+		cm.Space = video.BT2020
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+type Property struct {
+	format.PixelFormat
+	Width, Height int
+	FrameRate     float64
+	DeviceID      string            // backend specific device selector, default device if empty
+	Colorimetry   video.Colorimetry // of Y'CbCr formats, as reported by the device
+}
+
+// FrameInfo describes a frame read by Source.ReadVideoFrame: Size bytes of
+// buf were filled, and Timing tells when the frame was captured. Planes
+// locates the planes within buf when rows are padded; nil means the frame
+// is tightly packed.
+type FrameInfo struct {
+	Size   int
+	Planes []video.Plane
+	video.Timing
+}
+
+// Raw describes buf, as filled by ReadVideoFrame from a source with
+// property p, as a raw frame with the timing of i.
+func (i FrameInfo) Raw(p Property, buf []byte) video.RawFrame {
+	f := video.NewRawFrame(p.PixelFormat, buf[:i.Size], p.Width, p.Height)
+	f.Colorimetry = p.Colorimetry
+	f.Timing = i.Timing
+	if i.Planes != nil {
+		f.Planes = i.Planes
+	}
+	return f
+}
+
+// Source is an opened capture device delivering raw video frames in the
+// negotiated Property. ReadVideoFrame blocks until a frame is available; it
+// returns ctx.Err() once ctx is done, and ErrTimeout if no frame arrives in
+// time.
+type Source interface {
+	Property() Property
+	BufferSize() int
+	ReadVideoFrame(ctx context.Context, buf []byte) (FrameInfo, error)
+	Close()
+}
+
+type Driver interface {
+	Open(p Property) (Source, error)
+}
+
+// Enumerator is implemented by drivers that can list their devices.
+type Enumerator interface {
+	Devices() ([]Device, error)
+}
+
+// Device describes a capture device; ID is suitable for Property.DeviceID.
+type Device struct {
+	ID, Name string
+	Formats  []DeviceFormat
+}
+
+type DeviceFormat struct {
+	format.PixelFormat
+	Width, Height              int
+	MinFrameRate, MaxFrameRate float64
+}
+
+type DriverFunc func(p Property) (Source, error)
+
+func (fn DriverFunc) Open(p Property) (Source, error) { return fn(p) }
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a capture backend available by name. It panics if called
+// twice with the same name or if driver is nil.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("capture: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("capture: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered backends.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	list := make([]string, 0, len(drivers))
+	for name := range drivers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func driver(name string) (Driver, error) {
+	driversMu.RLock()
+	driver, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("capture: unknown source %q (forgotten import?)", name)
+	}
+	return driver, nil
+}
+
+// Devices lists the devices of the named backend.
+func Devices(name string) ([]Device, error) {
+	d, err := driver(name)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := d.(Enumerator)
+	if !ok {
+		return nil, fmt.Errorf("capture: source %q cannot enumerate devices", name)
+	}
+	return e.Devices()
+}
+
+// Open opens a source selected by a `name:device' string, such as `avf:0',
+// `v4l2:/dev/video0' or `testsrc:'. A non-empty device part overrides
+// p.DeviceID; an alias in p.PixelFormat is replaced by its primary name.
+func Open(source string, p Property) (Source, error) {
+	name, device := source, ""
+	if i := strings.IndexByte(source, ':'); i >= 0 {
+		name, device = source[:i], source[i+1:]
+	}
+	d, err := driver(name)
+	if err != nil {
+		return nil, err
+	}
+	if device != "" {
+		p.DeviceID = device
+	}
+	p.PixelFormat = p.PixelFormat.Canonical()
+	return d.Open(p)
+}
@@ -0,0 +1,96 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+type fakeSource struct{ p Property }
+
+func (f *fakeSource) Property() Property { return f.p }
+func (f *fakeSource) BufferSize() int    { return 0 }
+func (f *fakeSource) ReadVideoFrame(ctx context.Context, buf []byte) (FrameInfo, error) {
+	return FrameInfo{}, errors.New("fake")
+}
+func (f *fakeSource) Close() {}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(p Property) (Source, error) { return &fakeSource{p: p}, nil }
+func (fakeDriver) Devices() ([]Device, error) {
+	return []Device{{ID: "0", Name: "fake"}}, nil
+}
+
+func init() {
+	Register("fake", fakeDriver{})
+	Register("fakefn", DriverFunc(func(p Property) (Source, error) {
+		return &fakeSource{p: p}, nil
+	}))
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		source   string
+		deviceID string
+	}{
+		{"fake", "default"},
+		{"fake:", "default"},
+		{"fake:1", "1"},
+		{"fake:/dev/video1", "/dev/video1"},
+	}
+	for _, tt := range tests {
+		s, err := Open(tt.source, Property{DeviceID: "default"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Property().DeviceID; got != tt.deviceID {
+			t.Errorf("Open(%q): device %q, expected %q", tt.source, got, tt.deviceID)
+		}
+	}
+	if s, err := Open("fake", Property{PixelFormat: "YUYV"}); err != nil {
+		t.Fatal(err)
+	} else if got := s.Property().PixelFormat; got != format.YUY2 {
+		t.Errorf("Open: pixel format %q, expected %q", got, format.YUY2)
+	}
+	if _, err := Open("nonexistent:0", Property{}); err == nil {
+		t.Error("expected error for unknown source")
+	}
+	if !reflect.DeepEqual(Drivers(), []string{"fake", "fakefn"}) {
+		t.Errorf("unexpected drivers: %v", Drivers())
+	}
+}
+
+func TestDevices(t *testing.T) {
+	devices, err := Devices("fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, []Device{{ID: "0", Name: "fake"}}) {
+		t.Errorf("unexpected devices: %+v", devices)
+	}
+	if _, err = Devices("fakefn"); err == nil {
+		t.Error("expected error for driver without enumeration")
+	}
+	if _, err = Devices("nonexistent"); err == nil {
+		t.Error("expected error for unknown source")
+	}
+}
+
+func TestFrameInfoRaw(t *testing.T) {
+	p := Property{PixelFormat: format.NV12, Width: 4, Height: 2}
+	buf := make([]byte, 64)
+	f := FrameInfo{Size: 12}.Raw(p, buf)
+	if len(f.Data) != 12 || !reflect.DeepEqual(f.Planes, video.Planes(format.NV12, 4, 2, 0)) {
+		t.Errorf("packed frame: %+v", f)
+	}
+	planes := []video.Plane{{Offset: 0, Stride: 16}, {Offset: 32, Stride: 16}}
+	f = FrameInfo{Size: 48, Planes: planes}.Raw(p, buf)
+	if len(f.Data) != 48 || !reflect.DeepEqual(f.Planes, planes) {
+		t.Errorf("padded frame: %+v", f)
+	}
+}
@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StallTimeout bounds how long ReadVideoFrame waits for a frame when the
+// context has no earlier deadline, so a device that stops delivering does
+// not block forever.
+var StallTimeout = 5 * time.Second
+
+// ErrTimeout is returned by ReadVideoFrame when no frame arrives before the
+// context deadline or within StallTimeout.
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "capture: timed out waiting for frame" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Deadline returns when a read under ctx has to give up waiting.
+func Deadline(ctx context.Context) time.Time {
+	deadline := time.Now().Add(StallTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// AfterFunc arranges for interrupt to be called once ctx is done, unless stop
+// has returned before; backends use it to wake up a blocking read.
+func AfterFunc(ctx context.Context, interrupt func()) (stop func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	var mu sync.Mutex
+	var stopped bool
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			if !stopped {
+				interrupt()
+			}
+			mu.Unlock()
+		case <-done:
+		}
+	}()
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			stopped = true
+			close(done)
+		}
+	}
+}
+
+// Sleep pauses for d, or until ctx is done.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	if d := time.Until(Deadline(context.Background())); d <= 0 || d > StallTimeout {
+		t.Errorf("unexpected deadline in %v", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if d := time.Until(Deadline(ctx)); d > time.Millisecond {
+		t.Errorf("unexpected deadline in %v", d)
+	}
+	if err, ok := ErrTimeout.(net.Error); !ok || !err.Timeout() {
+		t.Error("ErrTimeout is not a timeout error")
+	}
+}
+
+func TestAfterFunc(t *testing.T) {
+	called := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := AfterFunc(ctx, func() { close(called) })
+	defer stop()
+	cancel()
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("interrupt not called")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	stop = AfterFunc(ctx, func() { t.Error("interrupt called after stop") })
+	stop()
+	stop()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestSleep(t *testing.T) {
+	if err := Sleep(context.Background(), time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Sleep(ctx, time.Hour); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
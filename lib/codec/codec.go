@@ -0,0 +1,128 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+// Options configures a new Encoder. Zero values select the defaults of the
+// codec, except for the size, which is required.
+type Options struct {
+	Width, Height    int
+	Bitrate          int     // target, in bits per second
+	FrameRate        float64 // nominal, for rate control and the timing of plain images
+	KeyFrameInterval int     // at most this many frames from one key frame to the next
+}
+
+// ErrFrameSize is returned by encoders for frames of another size than the
+// one they are configured for.
+var ErrFrameSize = errors.New("codec: frame size differs from the encoder's")
+
+// ErrNeedMoreData is returned by decoders for input that did not complete a
+// picture, such as parameter sets or the first slices of a frame.
+var ErrNeedMoreData = errors.New("codec: need more data")
+
+// ErrShortBuffer is matched by the errors of encoders whose output did not
+// fit in the buffer given, see ShortBufferError.
+var ErrShortBuffer = errors.New("codec: short output buffer")
+
+// ShortBufferError reports the Size in bytes an encoded frame needed. The
+// frame is dropped, and the next one coded as a key frame so that the
+// stream stays decodable.
+type ShortBufferError struct {
+	Size int
+}
+
+func (e *ShortBufferError) Error() string {
+	return fmt.Sprintf("codec: short output buffer, %d bytes needed", e.Size)
+}
+
+func (e *ShortBufferError) Is(err error) bool { return err == ErrShortBuffer }
+
+// Encoder compresses frames of the size it was created for; images other
+// than 4:2:0 Y'CbCr are converted first like video.Convert does, RGB ones
+// with the matrix and range set by SetColorimetry. EncodeFrame and
+// EncodeFrameAt write the bitstream of one frame to dst and return it as a
+// packet; its data is empty for frames the encoder skips. A
+// *ShortBufferError is returned if dst is too small; with a nil dst, the
+// encoder writes to a buffer of its own instead, which grows as needed and
+// is reused by the next call. Only ForceIntraFrame may be called
+// concurrently with the other methods.
+type Encoder interface {
+	// EncodeFrame encodes i at its timing if it is a video.Frame or points
+	// to one, other images with timestamps derived from the frame rate.
+	EncodeFrame(dst []byte, i image.Image) (Packet, error)
+	// EncodeFrameAt encodes i, or the image of a video.Frame, captured at t.
+	EncodeFrameAt(dst []byte, i image.Image, t video.Timing) (Packet, error)
+	// ForceIntraFrame makes the next frame a key frame.
+	ForceIntraFrame() error
+	// SetColorimetry signals c in the bitstream from the next frame on;
+	// frames must be encoded in c. It fails if the codec cannot signal c.
+	SetColorimetry(c video.Colorimetry) error
+	Close() error
+}
+
+// Reconfigurer is implemented by encoders whose settings can change while
+// they are running. The changes apply from the next frame on; after
+// SetResolution, frames must have the new size, and the next one is coded
+// as a key frame.
+type Reconfigurer interface {
+	SetBitrate(bitrate int) error
+	SetFrameRate(frameRate float64) error
+	SetResolution(width, height int) error
+}
+
+// EncoderFunc creates an Encoder of a registered codec.
+type EncoderFunc func(o Options) (Encoder, error)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]EncoderFunc)
+)
+
+// Register makes a codec available by name, which is case insensitive. It
+// panics if called twice with the same name or if fn is nil.
+func Register(name string, fn EncoderFunc) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if fn == nil {
+		panic("codec: Register encoder is nil")
+	}
+	name = strings.ToLower(name)
+	if _, dup := encoders[name]; dup {
+		panic("codec: Register called twice for encoder " + name)
+	}
+	encoders[name] = fn
+}
+
+// Encoders returns a sorted list of the names of the registered codecs.
+func Encoders() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	list := make([]string, 0, len(encoders))
+	for name := range encoders {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NewEncoder creates an Encoder of the named codec, such as "h264" or "vp8".
+func NewEncoder(name string, o Options) (Encoder, error) {
+	encodersMu.RLock()
+	fn, ok := encoders[strings.ToLower(name)]
+	encodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown encoder %q (forgotten import?)", name)
+	}
+	if o.Width <= 0 || o.Height <= 0 {
+		return nil, fmt.Errorf("codec: invalid size %dx%d", o.Width, o.Height)
+	}
+	return fn(o)
+}
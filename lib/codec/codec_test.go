@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+type fakeEncoder struct{ o Options }
+
+func (e *fakeEncoder) EncodeFrame(dst []byte, i image.Image) (Packet, error) { return Packet{}, nil }
+func (e *fakeEncoder) EncodeFrameAt(dst []byte, i image.Image, t video.Timing) (Packet, error) {
+	return Packet{}, nil
+}
+func (e *fakeEncoder) ForceIntraFrame() error                   { return nil }
+func (e *fakeEncoder) SetColorimetry(c video.Colorimetry) error { return nil }
+func (e *fakeEncoder) Close() error                             { return nil }
+
+func init() {
+	Register("Fake", func(o Options) (Encoder, error) { return &fakeEncoder{o}, nil })
+}
+
+func TestNewEncoder(t *testing.T) {
+	o := Options{Width: 320, Height: 240, Bitrate: 100_000}
+	for _, name := range []string{"fake", "FAKE"} {
+		e, err := NewEncoder(name, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.(*fakeEncoder).o; got != o {
+			t.Errorf("%s: options %+v, expected %+v", name, got, o)
+		}
+	}
+	if _, err := NewEncoder("nonexistent", o); err == nil {
+		t.Error("expected an error for an unknown encoder")
+	}
+	if _, err := NewEncoder("fake", Options{}); err == nil {
+		t.Error("expected an error for an empty size")
+	}
+	if got := Encoders(); !reflect.DeepEqual(got, []string{"fake"}) {
+		t.Errorf("Encoders: %v", got)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	Register("fake", func(o Options) (Encoder, error) { return nil, nil })
+}
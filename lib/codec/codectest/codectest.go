@@ -0,0 +1,132 @@
+// Package codectest checks that encoders keep to the contract of
+// codec.Encoder, for the tests of the packages implementing it.
+package codectest
+
+import (
+	"errors"
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/codec"
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+// Noise fills the planes of img with random samples.
+func Noise(img *image.YCbCr, r *rand.Rand) {
+	for _, p := range [][]byte{img.Y, img.Cb, img.Cr} {
+		r.Read(p)
+	}
+}
+
+// Run runs the checks below as subtests on encoders of the codec
+// registered as name.
+func Run(t *testing.T, name string) {
+	t.Run("ShortBuffer", func(t *testing.T) { CheckShortBuffer(t, name) })
+	t.Run("Noise", func(t *testing.T) { CheckNoise(t, name) })
+	t.Run("Close", func(t *testing.T) { CheckClose(t, name) })
+}
+
+func newEncoder(t *testing.T, name string, o codec.Options) codec.Encoder {
+	t.Helper()
+	e, err := codec.NewEncoder(name, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// CheckShortBuffer checks that a frame too large for the buffer given is
+// dropped with a *codec.ShortBufferError, and the next one is a key frame.
+func CheckShortBuffer(t *testing.T, name string) {
+	e := newEncoder(t, name, codec.Options{Width: 320, Height: 240, Bitrate: 20_000_000, FrameRate: 30})
+	defer e.Close()
+	r := rand.New(rand.NewSource(1))
+	img := image.NewYCbCr(image.Rect(0, 0, 320, 240), image.YCbCrSubsampleRatio420)
+	for i := 0; i < 3; i++ {
+		Noise(img, r)
+		pkt, err := e.EncodeFrame(nil, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkt.Data) == 0 {
+			t.Fatalf("frame %d: empty %v frame", i, pkt.Type)
+		}
+	}
+	Noise(img, r)
+	_, err := e.EncodeFrame(make([]byte, 64), img)
+	var short *codec.ShortBufferError
+	if !errors.As(err, &short) || !errors.Is(err, codec.ErrShortBuffer) || short.Size <= 64 {
+		t.Fatalf("expected a short buffer error, got %v", err)
+	}
+	Noise(img, r)
+	pkt, err := e.EncodeFrame(nil, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.Keyframe || pkt.Type != codec.FrameKey {
+		t.Errorf("expected a key frame after a dropped frame, got %v", pkt.Type)
+	}
+}
+
+// CheckNoise encodes noise at a high bitrate, which makes frames about as
+// large as the raw image, into buffers of up to twice its size, and checks
+// that the encoder writes within them or reports that they are short.
+func CheckNoise(t *testing.T, name string) {
+	e := newEncoder(t, name, codec.Options{Width: 320, Height: 240, Bitrate: 20_000_000, FrameRate: 30, KeyFrameInterval: 5})
+	defer e.Close()
+	r := rand.New(rand.NewSource(2))
+	img := image.NewYCbCr(image.Rect(0, 0, 320, 240), image.YCbCrSubsampleRatio420)
+	raw := video.FrameSize(format.I420, 320, 240)
+	const guard = 256
+	backing := make([]byte, 2*raw+guard)
+	shorts := 0
+	for n := 0; n < 40; n++ {
+		Noise(img, r)
+		var dst []byte
+		if n%4 != 0 {
+			dst = backing[: r.Intn(2*raw) : 2*raw]
+			for i := range backing[len(dst):] {
+				backing[len(dst)+i] = 0xa5
+			}
+		}
+		pkt, err := e.EncodeFrame(dst, img)
+		if dst != nil {
+			for i, b := range backing[len(dst):] {
+				if b != 0xa5 {
+					t.Fatalf("frame %d: wrote past a buffer of %d bytes at %d", n, len(dst), len(dst)+i)
+				}
+			}
+		}
+		var short *codec.ShortBufferError
+		switch {
+		case errors.As(err, &short):
+			shorts++
+			if dst == nil || short.Size <= len(dst) {
+				t.Fatalf("frame %d: unexpected %v for a buffer of %d bytes", n, err, len(dst))
+			}
+		case err != nil:
+			t.Fatalf("frame %d (%d bytes): %v", n, len(dst), err)
+		case pkt.Type == codec.FrameSkipped:
+		case len(pkt.Data) == 0:
+			t.Fatalf("frame %d: empty %v frame", n, pkt.Type)
+		case dst != nil && &pkt.Data[0] != &dst[0]:
+			t.Fatalf("frame %d: output outside the buffer given", n)
+		}
+	}
+	if shorts == 0 {
+		t.Error("expected frames not to fit")
+	}
+}
+
+// CheckClose checks that closing an encoder twice does no harm.
+func CheckClose(t *testing.T, name string) {
+	e := newEncoder(t, name, codec.Options{Width: 160, Height: 120, FrameRate: 30})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+#include <stdint.h>
+#include <string.h>
+#include <wels/codec_api.h>
+
+extern "C" {
+int newDecoder(ISVCDecoder **dec);
+void closeDecoder(ISVCDecoder *dec);
+int decode(ISVCDecoder *dec, const uint8_t *src, int size, int noDelay, SBufferInfo *info);
+void copyPicture(SBufferInfo *info, uint8_t *dstY, uint8_t *dstCb, uint8_t *dstCr, int yStride, int cStride);
+}
+
+/* ref:
+   https://github.com/cisco/openh264/wiki/UsageExampleForDecoder
+*/
+
+int newDecoder(ISVCDecoder **dec)
+{
+    SDecodingParam param;
+    ISVCDecoder *pDec;
+
+    int ret = WelsCreateDecoder(&pDec);
+    if (ret != 0) {
+        return ret;
+    }
+    memset(&param, 0, sizeof(param));
+    param.sVideoProperty.eVideoBsType = VIDEO_BITSTREAM_AVC;
+    param.eEcActiveIdc = ERROR_CON_DISABLE; // no pictures from broken data
+    ret = pDec->Initialize(&param);
+    if (ret != cmResultSuccess) {
+        WelsDestroyDecoder(pDec);
+        return ret;
+    }
+    *dec = pDec;
+    return 0;
+}
+
+void closeDecoder(ISVCDecoder *dec)
+{
+    if (dec) {
+        dec->Uninitialize();
+        WelsDestroyDecoder(dec);
+    }
+}
+
+// decode feeds size bytes of Annex B data to the decoder; with noDelay, they
+// complete an access unit. A NULL src marks the end of the access unit fed
+// so far. The picture in info, if any, is owned by the decoder and valid
+// until the next call.
+int decode(ISVCDecoder *dec, const uint8_t *src, int size, int noDelay, SBufferInfo *info)
+{
+    uint8_t *planes[3] = { NULL, NULL, NULL };
+    memset(info, 0, sizeof(*info));
+    if (noDelay) {
+        return dec->DecodeFrameNoDelay(src, size, planes, info);
+    }
+    return dec->DecodeFrame2(src, size, planes, info);
+}
+
+static void copyPlane(uint8_t *dst, int dstStride, const uint8_t *src, int srcStride, int width, int height)
+{
+    for (int y = 0; y < height; y++) {
+        memcpy(dst, src, width);
+        dst += dstStride;
+        src += srcStride;
+    }
+}
+
+// copyPicture copies the I420 picture in info to the planes given.
+void copyPicture(SBufferInfo *info, uint8_t *dstY, uint8_t *dstCb, uint8_t *dstCr, int yStride, int cStride)
+{
+    SSysMEMBuffer *b = &info->UsrData.sSystemBuffer;
+    int cw = (b->iWidth + 1) / 2, ch = (b->iHeight + 1) / 2;
+    copyPlane(dstY, yStride, info->pDst[0], b->iStride[0], b->iWidth, b->iHeight);
+    copyPlane(dstCb, cStride, info->pDst[1], b->iStride[1], cw, ch);
+    copyPlane(dstCr, cStride, info->pDst[2], b->iStride[1], cw, ch);
+}
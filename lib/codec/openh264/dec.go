@@ -0,0 +1,140 @@
+package openh264
+
+/*
+#include <stdint.h>
+#include <wels/codec_api.h>
+
+int newDecoder(ISVCDecoder **dec);
+void closeDecoder(ISVCDecoder *dec);
+int decode(ISVCDecoder *dec, const uint8_t *src, int size, int noDelay, SBufferInfo *info);
+void copyPicture(SBufferInfo *info, uint8_t *dstY, uint8_t *dstCb, uint8_t *dstCr, int yStride, int cStride);
+
+static SSysMEMBuffer *systemBuffer(SBufferInfo *info) { return &info->UsrData.sSystemBuffer; }
+*/
+import "C"
+import (
+	"fmt"
+	"image"
+	"strings"
+	"syscall"
+
+	"github.com/zyxar/mediastream/lib/codec"
+)
+
+// DecodeError is the DECODING_STATE of a failed decode, a set of flags.
+type DecodeError int
+
+var decodeStates = []struct {
+	flag int
+	name string
+}{
+	{C.dsFramePending, "frame pending"},
+	{C.dsRefLost, "reference lost"},
+	{C.dsBitstreamError, "bitstream error"},
+	{C.dsDepLayerLost, "dependent layer lost"},
+	{C.dsNoParamSets, "no parameter sets"},
+	{C.dsDataErrorConcealed, "error concealed"},
+	{C.dsRefListNullPtrs, "null reference"},
+	{C.dsInvalidArgument, "invalid argument"},
+	{C.dsInitialOptExpected, "not initialized"},
+	{C.dsOutOfMemory, "out of memory"},
+	{C.dsDstBufNeedExpan, "buffer too small"},
+}
+
+func (e DecodeError) Error() string {
+	var names []string
+	for _, s := range decodeStates {
+		if int(e)&s.flag != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("openh264: decoding state %#x", int(e))
+	}
+	return "openh264: " + strings.Join(names, ", ")
+}
+
+// Decoder is an H.264 decoder of Annex B access units, or of NAL units one
+// by one as they come from an RTP depacketizer. Broken pictures are not
+// output: after a DecodeError, decoding resumes at the next IDR frame.
+type Decoder struct {
+	dec  *C.ISVCDecoder
+	info C.SBufferInfo
+	nal  []byte // with start code, for DecodeNAL
+}
+
+func NewDecoder() (*Decoder, error) {
+	var dec *C.ISVCDecoder
+	if C.newDecoder(&dec) != 0 {
+		return nil, syscall.EINVAL
+	}
+	return &Decoder{dec: dec}, nil
+}
+
+func (d *Decoder) Close() error {
+	if d.dec == nil {
+		return nil
+	}
+	C.closeDecoder(d.dec)
+	d.dec = nil
+	return nil
+}
+
+// Decode decodes a complete access unit in Annex B format, and returns its
+// picture. It returns codec.ErrNeedMoreData if au holds no picture, e.g.
+// only parameter sets.
+func (d *Decoder) Decode(au []byte) (*image.YCbCr, error) {
+	if len(au) == 0 {
+		return nil, codec.ErrNeedMoreData
+	}
+	return d.decode(au, true)
+}
+
+// DecodeNAL decodes a NAL unit without start code. The decoder completes a
+// picture when the next access unit starts, or at Flush, and returns
+// codec.ErrNeedMoreData until then.
+func (d *Decoder) DecodeNAL(nal []byte) (*image.YCbCr, error) {
+	if len(nal) == 0 {
+		return nil, codec.ErrNeedMoreData
+	}
+	d.nal = append(append(d.nal[:0], 0, 0, 0, 1), nal...)
+	return d.decode(d.nal, false)
+}
+
+// Flush marks the end of the access unit given to DecodeNAL, as signalled by
+// the RTP marker bit, and returns its picture.
+func (d *Decoder) Flush() (*image.YCbCr, error) {
+	return d.decode(nil, false)
+}
+
+func (d *Decoder) decode(data []byte, noDelay bool) (*image.YCbCr, error) {
+	var src *C.uint8_t
+	if len(data) > 0 {
+		src = (*C.uint8_t)(&data[0])
+	}
+	var nd C.int
+	if noDelay {
+		nd = 1
+	}
+	if state := C.decode(d.dec, src, C.int(len(data)), nd, &d.info); state != C.dsErrorFree {
+		return nil, DecodeError(state)
+	}
+	if d.info.iBufferStatus != 1 {
+		return nil, codec.ErrNeedMoreData
+	}
+	return d.picture(), nil
+}
+
+// picture copies the decoded picture, which the decoder reuses.
+func (d *Decoder) picture() *image.YCbCr {
+	b := C.systemBuffer(&d.info)
+	img := image.NewYCbCr(image.Rect(0, 0, int(b.iWidth), int(b.iHeight)), image.YCbCrSubsampleRatio420)
+	C.copyPicture(&d.info,
+		(*C.uint8_t)(&img.Y[0]),
+		(*C.uint8_t)(&img.Cb[0]),
+		(*C.uint8_t)(&img.Cr[0]),
+		C.int(img.YStride),
+		C.int(img.CStride),
+	)
+	return img
+}
@@ -0,0 +1,139 @@
+package openh264
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/codec"
+)
+
+// encodePackets encodes n frames of a moving gradient, and returns them
+// with the packets.
+func encodePackets(t *testing.T, w, h, n int) ([]*image.YCbCr, []codec.Packet) {
+	e, err := NewEncoder(codec.Options{Width: w, Height: h, Bitrate: 2_000_000, FrameRate: 30, KeyFrameInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	var imgs []*image.YCbCr
+	var pkts []codec.Packet
+	for i := 0; i < n; i++ {
+		img := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				img.Y[y*img.YStride+x] = uint8(x + y + 2*i)
+			}
+		}
+		for j := range img.Cb {
+			img.Cb[j], img.Cr[j] = uint8(96+i), uint8(160-i)
+		}
+		pkt, err := e.EncodeFrame(make([]byte, 1<<20), img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt.NALUnits = append([][]byte(nil), pkt.NALUnits...)
+		imgs = append(imgs, img)
+		pkts = append(pkts, pkt)
+	}
+	return imgs, pkts
+}
+
+// diff returns the mean absolute difference of the pixels of a and b.
+func diff(a, b *image.YCbCr) float64 {
+	var sum, n int
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			d := int(a.Y[a.YOffset(x, y)]) - int(b.Y[b.YOffset(x, y)])
+			e := int(a.Cb[a.COffset(x, y)]) - int(b.Cb[b.COffset(x, y)])
+			if d < 0 {
+				d = -d
+			}
+			if e < 0 {
+				e = -e
+			}
+			sum += d + e
+			n += 2
+		}
+	}
+	return float64(sum) / float64(n)
+}
+
+func TestDecode(t *testing.T) {
+	for _, size := range []image.Point{{160, 120}, {90, 70}} {
+		imgs, pkts := encodePackets(t, size.X, size.Y, 8)
+		d, err := NewDecoder()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, pkt := range pkts {
+			if pkt.Type == codec.FrameSkipped {
+				continue
+			}
+			img, err := d.Decode(pkt.Data)
+			if err != nil {
+				t.Fatalf("%v frame %d: %v", size, i, err)
+			}
+			if img.Rect != imgs[i].Rect || img.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+				t.Fatalf("%v frame %d: decoded %v %v", size, i, img.Rect, img.SubsampleRatio)
+			}
+			if m := diff(imgs[i], img); m > 4 {
+				t.Errorf("%v frame %d: mean difference %.2f", size, i, m)
+			}
+		}
+		d.Close()
+	}
+}
+
+func TestDecodeNAL(t *testing.T) {
+	imgs, pkts := encodePackets(t, 160, 120, 6)
+	d, err := NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	for i, pkt := range pkts {
+		for _, nal := range pkt.NALUnits {
+			if _, err := d.DecodeNAL(nal); err != codec.ErrNeedMoreData {
+				t.Fatalf("frame %d: expected to need more data, got %v", i, err)
+			}
+		}
+		img, err := d.Flush()
+		if pkt.Type == codec.FrameSkipped {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if m := diff(imgs[i], img); m > 4 {
+			t.Errorf("frame %d: mean difference %.2f", i, m)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, pkts := encodePackets(t, 160, 120, 2)
+	d, err := NewDecoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Decode(nil); err != codec.ErrNeedMoreData {
+		t.Errorf("no data: expected to need more data, got %v", err)
+	}
+	var de DecodeError
+	if _, err := d.Decode(pkts[1].Data); !errors.As(err, &de) {
+		t.Errorf("inter frame first: expected a decode error, got %v", err)
+	}
+	sps := pkts[0].NALUnits[0]
+	if _, err := d.Decode(append([]byte{0, 0, 0, 1}, sps...)); err != codec.ErrNeedMoreData {
+		t.Errorf("SPS only: expected to need more data, got %v", err)
+	}
+	if _, err := d.Decode(pkts[0].Data); err != nil {
+		t.Errorf("key frame: %v", err)
+	}
+	// the deferred Close closes it again
+	if err := d.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
@@ -4,10 +4,15 @@
 #include <wels/codec_api.h>
 
 extern "C" {
-int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate);
+int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate, int intraPeriod);
 void closeEncoder(ISVCEncoder* enc);
-int encode(ISVCEncoder *enc, uint8_t *dst, size_t *size, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int width, int height);
+int encode(ISVCEncoder *enc, SFrameBSInfo *fbi, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int yStride, int cStride, int width, int height, long long timestamp);
+size_t copyBitstream(SFrameBSInfo *fbi, uint8_t *dst, size_t cap, int *nalLengths, int maxNals, int *nalCount);
 int forceIntraFrame(ISVCEncoder *enc);
+int setColorimetry(ISVCEncoder *enc, int fullRange, int primaries, int transfer, int matrix);
+int setBitrate(ISVCEncoder *enc, int bitrate);
+int setFrameRate(ISVCEncoder *enc, float frameRate);
+int setResolution(ISVCEncoder *enc, int width, int height);
 }
 
 /* ref:
@@ -15,7 +20,7 @@ int forceIntraFrame(ISVCEncoder *enc);
    https://github.com/cisco/openh264/wiki/UsageExampleForEncoder#encoder-usage-example-1
 */
 
-int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate)
+int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate, int intraPeriod)
 {
     int ret;
     SEncParamExt param;
@@ -49,6 +54,9 @@ int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float fram
     param.bPrefixNalAddingCtrl       = 0;
     param.iEntropyCodingModeFlag     = 0;
     param.iMultipleThreadIdc         = 0;
+    if (intraPeriod > 0) {
+        param.uiIntraPeriod = intraPeriod;
+    }
     param.sSpatialLayers[0].iVideoWidth         = param.iPicWidth;
     param.sSpatialLayers[0].iVideoHeight        = param.iPicHeight;
     param.sSpatialLayers[0].fFrameRate          = param.fMaxFrameRate;
@@ -78,40 +86,137 @@ void closeEncoder(ISVCEncoder* enc)
     }
 }
 
-int encode(ISVCEncoder *enc, uint8_t *dst, size_t *size, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int width, int height)
+// encode encodes a frame into fbi, whose buffers are owned by the encoder
+// and valid until the next call.
+int encode(ISVCEncoder *enc, SFrameBSInfo *fbi, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int yStride, int cStride, int width, int height, long long timestamp)
 {
-    int layer_size[MAX_LAYER_NUM_OF_FRAME] = { 0 };
-    SFrameBSInfo fbi = { 0 };
     SSourcePicture sp = { 0 };
     sp.iColorFormat = videoFormatI420;
     sp.iPicWidth  = width;
     sp.iPicHeight = height;
-    sp.iStride[0] = sp.iPicWidth;
-    sp.iStride[1] = sp.iPicWidth / 2;
-    sp.iStride[2] = sp.iPicWidth / 2;
+    sp.iStride[0] = yStride;
+    sp.iStride[1] = cStride;
+    sp.iStride[2] = cStride;
     sp.pData[0] = srcY;
     sp.pData[1] = srcCb;
     sp.pData[2] = srcCr;
-    int ret = enc->EncodeFrame(&sp, &fbi);
+    sp.uiTimeStamp = timestamp; // in milliseconds
+    memset(fbi, 0, sizeof(*fbi));
+    return enc->EncodeFrame(&sp, fbi);
+}
+
+// copyBitstream returns the size of the bitstream in fbi, and copies it to
+// dst if it fits in cap bytes. The lengths of the first maxNals NAL units go
+// to nalLengths, their total number to nalCount.
+size_t copyBitstream(SFrameBSInfo *fbi, uint8_t *dst, size_t cap, int *nalLengths, int maxNals, int *nalCount)
+{
+    size_t size = 0;
+    *nalCount = 0;
+    if (fbi->eFrameType == videoFrameTypeSkip) {
+        return 0;
+    }
+    for (int layer = 0; layer < fbi->iLayerNum; layer++) {
+        for (int i = 0; i < fbi->sLayerInfo[layer].iNalCount; i++) {
+            int n = fbi->sLayerInfo[layer].pNalLengthInByte[i];
+            if (*nalCount < maxNals) {
+                nalLengths[*nalCount] = n;
+            }
+            (*nalCount)++;
+            size += n;
+        }
+    }
+    if (size > cap) {
+        return size;
+    }
+    for (int layer = 0; layer < fbi->iLayerNum; layer++) {
+        size_t n = 0;
+        for (int i = 0; i < fbi->sLayerInfo[layer].iNalCount; i++) {
+            n += fbi->sLayerInfo[layer].pNalLengthInByte[i];
+        }
+        memcpy(dst, fbi->sLayerInfo[layer].pBsBuf, n);
+        dst += n;
+    }
+    return size;
+}
+
+int forceIntraFrame(ISVCEncoder *enc)
+{
+    return enc->ForceIntraFrame(true);
+}
+
+// setColorimetry signals the colour description in the VUI of the SPS, which
+// takes effect from the next IDR frame.
+int setColorimetry(ISVCEncoder *enc, int fullRange, int primaries, int transfer, int matrix)
+{
+    SEncParamExt param;
+    int ret = enc->GetOption(ENCODER_OPTION_SVC_ENCODE_PARAM_EXT, &param);
     if (ret != cmResultSuccess) {
         return ret;
     }
-    *size = 0;
-    if (fbi.eFrameType == videoFrameTypeSkip) {
-        return 0;
+    SSpatialLayerConfig *layer = &param.sSpatialLayers[0];
+    layer->bVideoSignalTypePresent   = true;
+    layer->uiVideoFormat             = VF_UNDEF;
+    layer->bFullRange                = fullRange != 0;
+    layer->bColorDescriptionPresent  = true;
+    layer->uiColorPrimaries          = primaries;
+    layer->uiTransferCharacteristics = transfer;
+    layer->uiColorMatrix             = matrix;
+    ret = enc->SetOption(ENCODER_OPTION_SVC_ENCODE_PARAM_EXT, &param);
+    if (ret != cmResultSuccess) {
+        return ret;
     }
-    for (int layer = 0; layer < fbi.iLayerNum; layer++) {
-        for (int i = 0; i < fbi.sLayerInfo[layer].iNalCount; i++) {
-            layer_size[layer] += fbi.sLayerInfo[layer].pNalLengthInByte[i];
+    return enc->ForceIntraFrame(true);
+}
+
+int setBitrate(ISVCEncoder *enc, int bitrate)
+{
+    SEncParamExt param;
+    int ret = enc->GetOption(ENCODER_OPTION_SVC_ENCODE_PARAM_EXT, &param);
+    if (ret != cmResultSuccess) {
+        return ret;
+    }
+    // the target may not exceed the maximum at any time: raise the maximum
+    // first, or lower the target first; both for the stream and for its one
+    // spatial layer
+    ENCODER_OPTION options[] = { ENCODER_OPTION_MAX_BITRATE, ENCODER_OPTION_BITRATE };
+    if (bitrate < param.sSpatialLayers[0].iSpatialBitrate) {
+        options[0] = ENCODER_OPTION_BITRATE;
+        options[1] = ENCODER_OPTION_MAX_BITRATE;
+    }
+    LAYER_NUM layers[] = { SPATIAL_LAYER_ALL, SPATIAL_LAYER_0 };
+    for (int o = 0; o < 2; o++) {
+        for (int l = 0; l < 2; l++) {
+            SBitrateInfo info = { layers[l], bitrate };
+            ret = enc->SetOption(options[o], &info);
+            if (ret != cmResultSuccess) {
+                return ret;
+            }
         }
-        memcpy(dst, fbi.sLayerInfo[layer].pBsBuf, layer_size[layer]);
-        *size += layer_size[layer];
-        dst += layer_size[layer];
     }
     return 0;
 }
 
-int forceIntraFrame(ISVCEncoder *enc)
+int setFrameRate(ISVCEncoder *enc, float frameRate)
+{
+    return enc->SetOption(ENCODER_OPTION_FRAME_RATE, &frameRate);
+}
+
+// setResolution resets the encoder to frames of width×height, which starts
+// over with new parameter sets and an IDR frame.
+int setResolution(ISVCEncoder *enc, int width, int height)
 {
+    SEncParamExt param;
+    int ret = enc->GetOption(ENCODER_OPTION_SVC_ENCODE_PARAM_EXT, &param);
+    if (ret != cmResultSuccess) {
+        return ret;
+    }
+    param.iPicWidth  = width;
+    param.iPicHeight = height;
+    param.sSpatialLayers[0].iVideoWidth  = width;
+    param.sSpatialLayers[0].iVideoHeight = height;
+    ret = enc->SetOption(ENCODER_OPTION_SVC_ENCODE_PARAM_EXT, &param);
+    if (ret != cmResultSuccess) {
+        return ret;
+    }
     return enc->ForceIntraFrame(true);
-}
\ No newline at end of file
+}
@@ -4,59 +4,286 @@ package openh264
 /*
 #include <stdint.h>
 #include <stddef.h>
+#include <stdlib.h>
 #include <wels/codec_api.h>
 
-int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate);
+int newEncoder(ISVCEncoder **enc, int width, int height, int bitrate, float frameRate, int intraPeriod);
 void closeEncoder(ISVCEncoder* enc);
-int encode(ISVCEncoder *enc, uint8_t *dst, size_t *size, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int width, int height);
+int encode(ISVCEncoder *enc, SFrameBSInfo *fbi, uint8_t *srcY, uint8_t *srcCb, uint8_t *srcCr, int yStride, int cStride, int width, int height, long long timestamp);
+size_t copyBitstream(SFrameBSInfo *fbi, uint8_t *dst, size_t cap, int *nalLengths, int maxNals, int *nalCount);
 int forceIntraFrame(ISVCEncoder *enc);
+int setColorimetry(ISVCEncoder *enc, int fullRange, int primaries, int transfer, int matrix);
+int setBitrate(ISVCEncoder *enc, int bitrate);
+int setFrameRate(ISVCEncoder *enc, float frameRate);
+int setResolution(ISVCEncoder *enc, int width, int height);
 */
 import "C"
 import (
 	"image"
 	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/zyxar/mediastream/lib/codec"
+	"github.com/zyxar/mediastream/lib/video"
 )
 
-type encoder struct{ enc *C.ISVCEncoder }
+// defaultBitrate is the target of encoders created without one.
+const defaultBitrate = 500_000
+
+func init() {
+	codec.Register("h264", func(o codec.Options) (codec.Encoder, error) {
+		e, err := NewEncoder(o)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+}
+
+// Encoder is an H.264 encoder; see codec.Encoder.
+type Encoder struct {
+	enc         *C.ISVCEncoder
+	fbi         *C.SFrameBSInfo // output of the last frame, in C memory
+	size        image.Point
+	frameRate   float64
+	colorimetry video.Colorimetry
+	yuv         *image.YCbCr // images converted to 4:2:0
+	frameCount  int64
+	nextPTS     time.Duration // of images without timing
+	buf         []byte        // output when no buffer is given
+	nalLengths  []C.int
+	nalUnits    [][]byte
+}
+
+var (
+	_ codec.Encoder      = (*Encoder)(nil)
+	_ codec.Reconfigurer = (*Encoder)(nil)
+)
 
-func NewEncoder(width int, height int, bitrate int, frameRate float64) (*encoder, error) {
+func NewEncoder(o codec.Options) (*Encoder, error) {
+	if o.Bitrate <= 0 {
+		o.Bitrate = defaultBitrate
+	}
 	var enc *C.ISVCEncoder
-	r := C.newEncoder(&enc, C.int(width), C.int(height), C.int(bitrate), C.float(frameRate))
+	r := C.newEncoder(&enc, C.int(o.Width), C.int(o.Height), C.int(o.Bitrate), C.float(o.FrameRate), C.int(o.KeyFrameInterval))
 	if r != 0 {
 		return nil, syscall.EINVAL
 	}
-	return &encoder{enc: enc}, nil
+	return &Encoder{
+		enc:        enc,
+		fbi:        (*C.SFrameBSInfo)(C.calloc(1, C.sizeof_SFrameBSInfo)),
+		size:       image.Pt(o.Width, o.Height),
+		frameRate:  o.FrameRate,
+		nalLengths: make([]C.int, C.MAX_NAL_UNITS_IN_LAYER),
+	}, nil
 }
 
-func (e *encoder) Close() { C.closeEncoder(e.enc) }
+func (e *Encoder) Close() error {
+	if e.enc == nil {
+		return nil
+	}
+	C.closeEncoder(e.enc)
+	C.free(unsafe.Pointer(e.fbi))
+	e.enc, e.fbi = nil, nil
+	return nil
+}
 
-func (e *encoder) encodeYUVFrame(dst []byte, i *image.YCbCr) (int, error) {
-	var size C.size_t
+func (e *Encoder) encodeYUVFrame(dst []byte, i *image.YCbCr, t video.Timing) (codec.Packet, error) {
 	bounds := i.Bounds()
-	r := C.encode(e.enc, (*C.uchar)(&dst[0]), &size,
-		(*C.uchar)(&i.Y[0]),
-		(*C.uchar)(&i.Cb[0]),
-		(*C.uchar)(&i.Cr[0]),
+	if bounds.Size() != e.size {
+		return codec.Packet{}, codec.ErrFrameSize
+	}
+	ci := i.COffset(bounds.Min.X, bounds.Min.Y)
+	r := C.encode(e.enc, e.fbi,
+		(*C.uchar)(&i.Y[i.YOffset(bounds.Min.X, bounds.Min.Y)]),
+		(*C.uchar)(&i.Cb[ci]),
+		(*C.uchar)(&i.Cr[ci]),
+		C.int(i.YStride),
+		C.int(i.CStride),
 		C.int(bounds.Max.X-bounds.Min.X),
 		C.int(bounds.Max.Y-bounds.Min.Y),
+		C.longlong(t.PTS/time.Millisecond),
 	)
 	if r != 0 {
-		return 0, syscall.EINVAL
+		return codec.Packet{}, syscall.EINVAL
+	}
+	e.frameCount++
+	p := codec.Packet{
+		PTS:      t.PTS,
+		DTS:      t.PTS,
+		Duration: t.Duration,
+		Type:     frameType(C.int(e.fbi.eFrameType)),
+	}
+	if p.Type == codec.FrameSkipped {
+		return p, nil
+	}
+	out := dst
+	if dst == nil {
+		out = e.buf[:cap(e.buf)]
 	}
-	return int(size), nil
+	size, nalCount := e.copyBitstream(out)
+	if size > len(out) || nalCount > len(e.nalLengths) {
+		if dst != nil && size > len(dst) {
+			C.forceIntraFrame(e.enc)
+			return codec.Packet{}, &codec.ShortBufferError{Size: size}
+		}
+		if size > len(out) {
+			e.buf = make([]byte, size+size/4)
+			out = e.buf
+		}
+		if nalCount > len(e.nalLengths) {
+			e.nalLengths = make([]C.int, nalCount)
+		}
+		size, nalCount = e.copyBitstream(out)
+	}
+	p.Data = out[:size]
+	p.Keyframe = p.Type == codec.FrameKey
+	e.nalUnits = e.nalUnits[:0]
+	data, droppable := p.Data, true
+	for _, n := range e.nalLengths[:nalCount] {
+		nal := trimStartCode(data[:n])
+		data = data[n:]
+		if len(nal) == 0 {
+			continue
+		}
+		// slices with a nal_ref_idc of 0 are not referred to
+		if typ := nal[0] & 0x1f; typ >= 1 && typ <= 5 && nal[0]&0x60 != 0 {
+			droppable = false
+		}
+		e.nalUnits = append(e.nalUnits, nal)
+	}
+	p.Droppable = droppable
+	p.NALUnits = e.nalUnits
+	return p, nil
+}
+
+// copyBitstream copies the output of the last frame to dst if it fits, and
+// the lengths of its NAL units to e.nalLengths if they fit; it returns the
+// size of the output and the number of NAL units either way.
+func (e *Encoder) copyBitstream(dst []byte) (int, int) {
+	var nalCount C.int
+	var p *C.uchar
+	if len(dst) > 0 {
+		p = (*C.uchar)(&dst[0])
+	}
+	size := C.copyBitstream(e.fbi, p, C.size_t(len(dst)), &e.nalLengths[0], C.int(len(e.nalLengths)), &nalCount)
+	return int(size), int(nalCount)
+}
+
+// frameType maps an EVideoFrameType.
+func frameType(t C.int) codec.FrameType {
+	switch t {
+	case C.videoFrameTypeIDR:
+		return codec.FrameKey
+	case C.videoFrameTypeI:
+		return codec.FrameIntra
+	case C.videoFrameTypeP, C.videoFrameTypeIPMixed:
+		return codec.FrameInter
+	case C.videoFrameTypeSkip:
+		return codec.FrameSkipped
+	}
+	return codec.FrameUnknown
 }
 
-func (e *encoder) EncodeFrame(dst []byte, i image.Image) (int, error) {
-	switch j := i.(type) {
-	case *image.YCbCr:
-		return e.encodeYUVFrame(dst, j)
+// trimStartCode returns nal without its Annex B start code.
+func trimStartCode(nal []byte) []byte {
+	for i := 0; i+2 < len(nal) && nal[i] == 0; i++ {
+		if nal[i+1] == 0 && nal[i+2] == 1 {
+			return nal[i+3:]
+		}
 	}
-	panic("not implemented")
+	return nal
 }
 
-func (e *encoder) ForceIntraFrame() error {
+// EncodeFrame encodes i at its timing if it is a video.Frame or points to
+// one, other images with timestamps derived from the frame rate.
+func (e *Encoder) EncodeFrame(dst []byte, i image.Image) (codec.Packet, error) {
+	if t, ok := video.TimingOf(i); ok {
+		return e.EncodeFrameAt(dst, i, t)
+	}
+	t := video.Timing{PTS: e.nextPTS, Sequence: uint64(e.frameCount)}
+	if e.frameRate > 0 {
+		t.Duration = time.Duration(float64(time.Second) / e.frameRate)
+	}
+	e.nextPTS += t.Duration
+	return e.EncodeFrameAt(dst, i, t)
+}
+
+// EncodeFrameAt encodes i, or the image of a video.Frame, captured at t.PTS.
+func (e *Encoder) EncodeFrameAt(dst []byte, i image.Image, t video.Timing) (codec.Packet, error) {
+	img, err := e.toYUV420(video.ImageOf(i))
+	if err != nil {
+		return codec.Packet{}, err
+	}
+	return e.encodeYUVFrame(dst, img, t)
+}
+
+// toYUV420 returns img if it is 4:2:0 Y'CbCr, or converts it in the
+// colorimetry of the encoder.
+func (e *Encoder) toYUV420(img image.Image) (*image.YCbCr, error) {
+	if yuv, ok := img.(*image.YCbCr); ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		return yuv, nil
+	}
+	if size := img.Bounds().Size(); e.yuv == nil || e.yuv.Rect.Size() != size {
+		e.yuv = image.NewYCbCr(image.Rectangle{Max: size}, image.YCbCrSubsampleRatio420)
+	}
+	if err := video.ConvertTo(e.yuv, img, e.colorimetry); err != nil {
+		return nil, err
+	}
+	return e.yuv, nil
+}
+
+func (e *Encoder) ForceIntraFrame() error {
 	if C.forceIntraFrame(e.enc) != 0 {
 		return syscall.EINVAL
 	}
 	return nil
 }
+
+// SetColorimetry signals c in the bitstream from the next frame on, which is
+// forced to be an IDR frame. Frames must be encoded in c.
+func (e *Encoder) SetColorimetry(c video.Colorimetry) error {
+	var fullRange C.int
+	if c.Range == video.FullRange {
+		fullRange = 1
+	}
+	primaries, transfer, matrix := C.CP_SMPTE170M, C.TRC_SMPTE170M, C.CM_SMPTE170M
+	switch c.Space {
+	case video.BT709:
+		primaries, transfer, matrix = C.CP_BT709, C.TRC_BT709, C.CM_BT709
+	case video.BT2020:
+		primaries, transfer, matrix = C.CP_BT2020, C.TRC_BT2020_10, C.CM_BT2020NC
+	}
+	if C.setColorimetry(e.enc, fullRange, C.int(primaries), C.int(transfer), C.int(matrix)) != 0 {
+		return syscall.EINVAL
+	}
+	e.colorimetry = c
+	return nil
+}
+
+// SetBitrate sets the target and the maximum bitrate, in bits per second.
+func (e *Encoder) SetBitrate(bitrate int) error {
+	if bitrate <= 0 || C.setBitrate(e.enc, C.int(bitrate)) != 0 {
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+func (e *Encoder) SetFrameRate(frameRate float64) error {
+	if frameRate <= 0 || C.setFrameRate(e.enc, C.float(frameRate)) != 0 {
+		return syscall.EINVAL
+	}
+	e.frameRate = frameRate
+	return nil
+}
+
+// SetResolution restarts the stream with new parameter sets and an IDR frame
+// for frames of width×height.
+func (e *Encoder) SetResolution(width, height int) error {
+	if width <= 0 || height <= 0 || C.setResolution(e.enc, C.int(width), C.int(height)) != 0 {
+		return syscall.EINVAL
+	}
+	e.size = image.Pt(width, height)
+	return nil
+}
@@ -0,0 +1,190 @@
+package openh264
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/codec"
+	"github.com/zyxar/mediastream/lib/codec/codectest"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+func TestEncodePackets(t *testing.T) {
+	e, err := NewEncoder(codec.Options{Width: 160, Height: 120, FrameRate: 30, KeyFrameInterval: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	img := image.NewYCbCr(image.Rect(0, 0, 160, 120), image.YCbCrSubsampleRatio420)
+	dst := make([]byte, 1<<20)
+	for n := 0; n < 6; n++ {
+		for i := range img.Y {
+			img.Y[i] = uint8(i*n + i/160)
+		}
+		timing := video.Timing{PTS: time.Duration(n) * 40 * time.Millisecond, Duration: 40 * time.Millisecond}
+		// pointers to frames carry their timing as well
+		var frame image.Image = video.Frame{Image: img, Timing: timing}
+		if n%2 == 1 {
+			frame = &video.Frame{Image: img, Timing: timing}
+		}
+		pkt, err := e.EncodeFrame(dst, frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Type == codec.FrameSkipped {
+			continue
+		}
+		if pkt.PTS != timing.PTS || pkt.DTS != timing.PTS || pkt.Duration != timing.Duration {
+			t.Errorf("frame %d: unexpected timestamps %v %v %v", n, pkt.PTS, pkt.DTS, pkt.Duration)
+		}
+		if key := n%4 == 0; key != pkt.Keyframe || key != (pkt.Type == codec.FrameKey) {
+			t.Errorf("frame %d: key frame %v, got %v", n, key, pkt.Type)
+		}
+		var types []byte
+		size := 0
+		for _, nal := range pkt.NALUnits {
+			types = append(types, nal[0]&0x1f)
+			size += len(nal)
+		}
+		if size >= len(pkt.Data) || size < len(pkt.Data)-4*len(pkt.NALUnits) {
+			t.Errorf("frame %d: NAL units of %d bytes in %d bytes of data", n, size, len(pkt.Data))
+		}
+		if pkt.Keyframe && (len(types) < 3 || types[0] != 7 || types[1] != 8 || types[len(types)-1] != 5) {
+			t.Errorf("frame %d: key frame with NAL unit types %v", n, types)
+		}
+		if !pkt.Keyframe && (len(types) == 0 || types[0] != 1 || pkt.Droppable) {
+			t.Errorf("frame %d: inter frame with NAL unit types %v, droppable %v", n, types, pkt.Droppable)
+		}
+	}
+}
+
+func TestTrimStartCode(t *testing.T) {
+	for _, c := range []struct{ in, out []byte }{
+		{[]byte{0, 0, 0, 1, 0x67, 1}, []byte{0x67, 1}},
+		{[]byte{0, 0, 1, 0x68}, []byte{0x68}},
+		{[]byte{0x65, 0, 0, 1}, []byte{0x65, 0, 0, 1}},
+	} {
+		if got := trimStartCode(c.in); string(got) != string(c.out) {
+			t.Errorf("%x: expected %x, got %x", c.in, c.out, got)
+		}
+	}
+}
+
+func TestEncoderContract(t *testing.T) {
+	codectest.Run(t, "h264")
+}
+
+func TestReconfigure(t *testing.T) {
+	e, err := NewEncoder(codec.Options{Width: 320, Height: 240, FrameRate: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	r := rand.New(rand.NewSource(3))
+	large := image.NewYCbCr(image.Rect(0, 0, 320, 240), image.YCbCrSubsampleRatio420)
+	small := image.NewYCbCr(image.Rect(0, 0, 176, 144), image.YCbCrSubsampleRatio420)
+	encode := func(img *image.YCbCr) codec.Packet {
+		t.Helper()
+		codectest.Noise(img, r)
+		pkt, err := e.EncodeFrame(nil, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+	for i := 0; i < 3; i++ {
+		encode(large)
+	}
+	if err = e.SetBitrate(2_000_000); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.SetFrameRate(15); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := encode(large); pkt.Keyframe || pkt.Duration != time.Second/15 {
+		t.Errorf("unexpected %v frame of %v after a new bitrate and frame rate", pkt.Type, pkt.Duration)
+	}
+	for _, size := range []*image.YCbCr{small, large} {
+		if err = e.SetResolution(size.Rect.Dx(), size.Rect.Dy()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = e.EncodeFrame(nil, image.NewYCbCr(image.Rect(0, 0, 64, 64), image.YCbCrSubsampleRatio420)); err != codec.ErrFrameSize {
+			t.Errorf("expected ErrFrameSize, got %v", err)
+		}
+		pkt := encode(size)
+		if !pkt.Keyframe || len(pkt.NALUnits) == 0 || pkt.NALUnits[0][0]&0x1f != 7 {
+			t.Errorf("%v: expected a key frame with a new SPS, got %v", size.Rect.Size(), pkt.Type)
+		}
+		if pkt = encode(size); pkt.Keyframe {
+			t.Errorf("%v: unexpected key frame", size.Rect.Size())
+		}
+	}
+	if err = e.SetBitrate(300_000); err != nil {
+		t.Fatal(err)
+	}
+	encode(large)
+	if err = e.SetBitrate(0); err == nil {
+		t.Error("expected an error for a bitrate of 0")
+	}
+}
+
+func TestEncodeImages(t *testing.T) {
+	const w, h = 64, 48
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		copy(rgba.Pix[i:], []byte{200, 40, 60, 0xff})
+	}
+	ycbcr422 := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio422)
+	for i := range ycbcr422.Y {
+		ycbcr422.Y[i] = 100
+	}
+	for i := range ycbcr422.Cb {
+		ycbcr422.Cb[i], ycbcr422.Cr[i] = 90, 170
+	}
+	for _, c := range []struct {
+		name      string
+		img       image.Image
+		y, cb, cr uint8
+	}{
+		{"RGBA", rgba, 0, 0, 0},
+		{"frame of RGBA", video.Frame{Image: rgba}, 0, 0, 0},
+		{"4:2:2", ycbcr422, 100, 90, 170},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if c.y == 0 {
+				c.y, c.cb, c.cr = video.Colorimetry{}.RGBToYCbCr(200, 40, 60)
+			}
+			e, err := NewEncoder(codec.Options{Width: w, Height: h, Bitrate: 2_000_000, FrameRate: 30})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			pkt, err := e.EncodeFrame(nil, c.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewDecoder()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			img, err := d.Decode(pkt.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := img.YCbCrAt(w/2, h/2)
+			if absDiff(got.Y, c.y) > 2 || absDiff(got.Cb, c.cb) > 2 || absDiff(got.Cr, c.cr) > 2 {
+				t.Errorf("expected %d,%d,%d, got %v", c.y, c.cb, c.cr, got)
+			}
+		})
+	}
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
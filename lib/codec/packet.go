@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+)
+
+// FrameType is the coding type of an encoded frame.
+type FrameType int
+
+const (
+	FrameUnknown FrameType = iota
+	FrameKey               // decoding can start here: an H.264 IDR or a VPX key frame
+	FrameIntra             // intra coded, but later frames may refer to frames before it
+	FrameInter             // predicted from earlier frames
+	FrameSkipped           // not coded, e.g. dropped by rate control
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameUnknown:
+		return "unknown"
+	case FrameKey:
+		return "key"
+	case FrameIntra:
+		return "intra"
+	case FrameInter:
+		return "inter"
+	case FrameSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("FrameType(%d)", int(t))
+}
+
+// Packet is the bitstream of one encoded frame. Data is a prefix of the dst
+// buffer passed to the encoder, or of its own buffer, and empty for skipped
+// frames; NALUnits are valid until the next call to the encoder.
+type Packet struct {
+	Data      []byte
+	PTS, DTS  time.Duration // on the clock of the frame's timing
+	Duration  time.Duration
+	Type      FrameType
+	Keyframe  bool
+	Droppable bool // no other frame refers to it
+	// NALUnits are the H.264 NAL units within Data, without start codes.
+	NALUnits [][]byte
+}
@@ -8,35 +8,39 @@ package vpx
 #include <vpx/vpx_image.h>
 #include <vpx/vp8cx.h>
 
-int copyFrame(vpx_codec_ctx_t *ctx, uint8_t *dst)
+// copyFrame returns the size of the compressed data of the frame just
+// encoded, and copies it to dst if it fits in cap bytes; it reports the
+// flags and timestamp of the frame. The packets stay available until the
+// next frame is encoded, so it may be called again with a larger buffer.
+size_t copyFrame(vpx_codec_ctx_t *ctx, uint8_t *dst, size_t cap, vpx_codec_frame_flags_t *flags, vpx_codec_pts_t *pts)
 {
-    const vpx_codec_cx_pkt_t *pkt = NULL;
+	const vpx_codec_cx_pkt_t *pkt = NULL;
 	vpx_codec_iter_t iter = NULL;
-    int size = 0;
+	size_t size = 0;
 
+	*flags = 0;
 	while ((pkt = vpx_codec_get_cx_data(ctx, &iter))) {
-		switch (pkt->kind) {
-		case VPX_CODEC_CX_FRAME_PKT:
+		if (pkt->kind == VPX_CODEC_CX_FRAME_PKT) {
+			*flags |= pkt->data.frame.flags;
+			*pts = pkt->data.frame.pts;
+			size += pkt->data.frame.sz;
+		}
+	}
+	if (size > cap) {
+		return size;
+	}
+	iter = NULL;
+	while ((pkt = vpx_codec_get_cx_data(ctx, &iter))) {
+		if (pkt->kind == VPX_CODEC_CX_FRAME_PKT) {
 			memcpy(dst, pkt->data.frame.buf, pkt->data.frame.sz);
 			dst += pkt->data.frame.sz;
-            size += pkt->data.frame.sz;
-			break;
-        case VPX_CODEC_STATS_PKT:
-			break;
-        case VPX_CODEC_PSNR_PKT:
-			break;
-        case VPX_CODEC_CUSTOM_PKT:
-			break;
-		case VPX_CODEC_FPMB_STATS_PKT:
-			break;
 		}
 	}
-
-    return size;
+	return size;
 }
 
 vpx_codec_err_t initEncoder(vpx_codec_ctx_t **ctx, vpx_image_t **img, vpx_codec_enc_cfg_t *cfg, vpx_codec_iface_t *codec,
-	unsigned int width, unsigned int height, unsigned int bitrate, unsigned int keyFrameInterval, int frameRate)
+	unsigned int width, unsigned int height, unsigned int bitrate, unsigned int keyFrameInterval, int clockRate)
 {
 	vpx_codec_err_t e = vpx_codec_enc_config_default(codec, cfg, 0);
 	if (e != VPX_CODEC_OK) {
@@ -45,12 +49,15 @@ vpx_codec_err_t initEncoder(vpx_codec_ctx_t **ctx, vpx_image_t **img, vpx_codec_
 	cfg->g_w = width;
 	cfg->g_h = height;
 	cfg->g_timebase.num = 1;
-	cfg->g_timebase.den = frameRate;
+	cfg->g_timebase.den = clockRate;
 	cfg->g_error_resilient = 1;
 	cfg->g_pass = VPX_RC_ONE_PASS;
+	cfg->g_lag_in_frames = 0; // a packet for every frame, as it is encoded
 	cfg->rc_target_bitrate = bitrate;
 	cfg->rc_resize_allowed = 0;
-	cfg->kf_max_dist = keyFrameInterval;
+	if (keyFrameInterval > 0) {
+		cfg->kf_max_dist = keyFrameInterval;
+	}
 
 	vpx_image_t i = {0};
 	if (!vpx_img_alloc(&i, VPX_IMG_FMT_I420, width, height, 1)) {
@@ -69,14 +76,80 @@ vpx_codec_err_t initEncoder(vpx_codec_ctx_t **ctx, vpx_image_t **img, vpx_codec_
 	}
 	return vpx_codec_enc_init_ver(*ctx, codec, cfg, 0, VPX_ENCODER_ABI_VERSION);
 }
+
+vpx_codec_err_t setColorSpace(vpx_codec_ctx_t *ctx, vpx_image_t *img, int cs, int range)
+{
+	vpx_codec_err_t e = vpx_codec_control(ctx, VP9E_SET_COLOR_SPACE, cs);
+	if (e != VPX_CODEC_OK) {
+		return e;
+	}
+	e = vpx_codec_control(ctx, VP9E_SET_COLOR_RANGE, range);
+	if (e != VPX_CODEC_OK) {
+		return e;
+	}
+	img->cs = cs;
+	img->range = range;
+	return VPX_CODEC_OK;
+}
+
+// setSize changes the encoder to frames of width×height, reinitialising it
+// if libvpx cannot change the configuration in place, e.g. to grow beyond
+// its initial size. The colour space of img is signalled again.
+vpx_codec_err_t setSize(vpx_codec_ctx_t *ctx, vpx_image_t *img, vpx_codec_enc_cfg_t *cfg, unsigned int width, unsigned int height, int vp9)
+{
+	vpx_codec_iface_t *iface = ctx->iface;
+	vpx_codec_enc_cfg_t old = *cfg;
+	cfg->g_w = width;
+	cfg->g_h = height;
+	vpx_codec_err_t e = vpx_codec_enc_config_set(ctx, cfg);
+	if (e != VPX_CODEC_OK) {
+		vpx_codec_destroy(ctx);
+		e = vpx_codec_enc_init_ver(ctx, iface, cfg, 0, VPX_ENCODER_ABI_VERSION);
+		if (e == VPX_CODEC_OK && vp9) {
+			e = setColorSpace(ctx, img, img->cs, img->range);
+		}
+	}
+	if (e != VPX_CODEC_OK) {
+		*cfg = old;
+		return e;
+	}
+	img->w = (width + 1) & ~1;
+	img->h = (height + 1) & ~1;
+	img->d_w = width;
+	img->d_h = height;
+	return VPX_CODEC_OK;
+}
 */
 import "C"
 import (
 	"image"
+	"math"
 	"sync/atomic"
+	"time"
 	"unsafe"
+
+	"github.com/zyxar/mediastream/lib/codec"
+	"github.com/zyxar/mediastream/lib/video"
 )
 
+// clockRate is the encoder timebase, same as the RTP video clock.
+const clockRate = 90000
+
+func toTicks(d time.Duration) int64 {
+	return int64(math.Round(d.Seconds() * clockRate))
+}
+
+func fromTicks(n int64) time.Duration {
+	return time.Duration(float64(n) * float64(time.Second) / clockRate)
+}
+
+func frameDuration(frameRate float64) int64 {
+	if frameRate <= 0 {
+		frameRate = 30
+	}
+	return int64(math.Round(clockRate / frameRate))
+}
+
 type vpxError C.vpx_codec_err_t
 
 func (v vpxError) Error() string {
@@ -112,45 +185,89 @@ func codecError(c C.vpx_codec_err_t) error {
 	}
 }
 
-type encoder struct {
+func init() {
+	codec.Register("vp8", func(o codec.Options) (codec.Encoder, error) {
+		e, err := NewVP8Encoder(o)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+	codec.Register("vp9", func(o codec.Options) (codec.Encoder, error) {
+		e, err := NewVP9Encoder(o)
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	})
+}
+
+// defaultBitrate is the target of encoders created without one.
+const defaultBitrate = 500_000
+
+// Encoder is a VP8 or VP9 encoder; see codec.Encoder.
+type Encoder struct {
 	ctx              *C.vpx_codec_ctx_t
 	img              *C.vpx_image_t
 	cfg              C.vpx_codec_enc_cfg_t
 	frameFlags       uint32 // vpx_enc_frame_flags_t
 	frameCount       int64
+	frameDuration    int64 // in clockRate units
+	lastPTS          int64
+	nextPTS          int64 // of images without timing
 	keyFrameInterval int
+	vp9              bool
+	colorimetry      video.Colorimetry
+	yuv              *image.YCbCr // images converted to 4:2:0
+	buf              []byte       // output when no buffer is given
 }
 
-func NewVP8Encoder(width int, height int, bitrate int, keyFrameInterval int, frameRate float64) (*encoder, error) {
-	var enc encoder
-	err := C.initEncoder(&enc.ctx, &enc.img, &enc.cfg, C.vpx_codec_vp8_cx(),
-		C.uint(width), C.uint(height), C.uint(bitrate/1000), C.uint(keyFrameInterval), C.int(frameRate))
-	if err != C.VPX_CODEC_OK {
-		return nil, codecError(err)
+var (
+	_ codec.Encoder      = (*Encoder)(nil)
+	_ codec.Reconfigurer = (*Encoder)(nil)
+)
+
+func NewVP8Encoder(o codec.Options) (*Encoder, error) {
+	return newEncoder(C.vpx_codec_vp8_cx(), o)
+}
+
+func NewVP9Encoder(o codec.Options) (*Encoder, error) {
+	enc, err := newEncoder(C.vpx_codec_vp9_cx(), o)
+	if err != nil {
+		return nil, err
 	}
-	enc.keyFrameInterval = keyFrameInterval
-	return &enc, nil
+	enc.vp9 = true
+	return enc, nil
 }
 
-func NewVP9Encoder(width int, height int, bitrate int, keyFrameInterval int, frameRate float64) (*encoder, error) {
-	var enc encoder
-	err := C.initEncoder(&enc.ctx, &enc.img, &enc.cfg, C.vpx_codec_vp9_cx(),
-		C.uint(width), C.uint(height), C.uint(bitrate/1000), C.uint(keyFrameInterval), C.int(frameRate))
+func newEncoder(iface *C.vpx_codec_iface_t, o codec.Options) (*Encoder, error) {
+	if o.Bitrate <= 0 {
+		o.Bitrate = defaultBitrate
+	}
+	var enc Encoder
+	err := C.initEncoder(&enc.ctx, &enc.img, &enc.cfg, iface,
+		C.uint(o.Width), C.uint(o.Height), kbps(o.Bitrate), C.uint(o.KeyFrameInterval), C.int(clockRate))
 	if err != C.VPX_CODEC_OK {
 		return nil, codecError(err)
 	}
-	enc.keyFrameInterval = keyFrameInterval
+	enc.keyFrameInterval = o.KeyFrameInterval
+	enc.frameDuration = frameDuration(o.FrameRate)
+	enc.lastPTS = -1
 	return &enc, nil
 }
 
-func (e *encoder) Close() error {
+func (e *Encoder) Close() error {
+	if e.ctx == nil {
+		return nil
+	}
 	C.free(unsafe.Pointer(e.img))
 	err := codecError(C.vpx_codec_destroy(e.ctx))
 	C.free(unsafe.Pointer(e.ctx))
+	e.img, e.ctx = nil, nil
 	return err
 }
 
-func (e *encoder) ForceIntraFrame() error {
+func (e *Encoder) ForceIntraFrame() error {
 	for {
 		oldVal := atomic.LoadUint32(&e.frameFlags)
 		newVal := oldVal | uint32(C.VPX_EFLAG_FORCE_KF)
@@ -158,19 +275,125 @@ func (e *encoder) ForceIntraFrame() error {
 			return nil
 		}
 	}
+}
+
+// SetColorimetry signals c in the bitstream; frames must be encoded in c. VP8
+// has no means to signal colorimetry and supports the default only.
+func (e *Encoder) SetColorimetry(c video.Colorimetry) error {
+	if !e.vp9 {
+		if c != (video.Colorimetry{}) {
+			return codecError(C.VPX_CODEC_UNSUP_FEATURE)
+		}
+		return nil
+	}
+	cs, r := C.VPX_CS_BT_601, C.VPX_CR_STUDIO_RANGE
+	switch c.Space {
+	case video.BT709:
+		cs = C.VPX_CS_BT_709
+	case video.BT2020:
+		cs = C.VPX_CS_BT_2020
+	}
+	if c.Range == video.FullRange {
+		r = C.VPX_CR_FULL_RANGE
+	}
+	if err := codecError(C.setColorSpace(e.ctx, e.img, C.int(cs), C.int(r))); err != nil {
+		return err
+	}
+	e.colorimetry = c
+	return nil
+}
+
+// kbps converts bitrate to the kbit/s of libvpx, rounding up: a target of 0
+// would select the default instead.
+func kbps(bitrate int) C.uint {
+	return C.uint((bitrate + 999) / 1000)
+}
+
+// SetBitrate sets the target bitrate, in bits per second.
+func (e *Encoder) SetBitrate(bitrate int) error {
+	if bitrate <= 0 {
+		return codecError(C.VPX_CODEC_INVALID_PARAM)
+	}
+	cfg := e.cfg
+	cfg.rc_target_bitrate = kbps(bitrate)
+	if err := codecError(C.vpx_codec_enc_config_set(e.ctx, &cfg)); err != nil {
+		return err
+	}
+	e.cfg = cfg
 	return nil
 }
 
-func (e *encoder) EncodeFrame(dst []byte, i image.Image) (int, error) {
-	switch j := i.(type) {
-	case *image.YCbCr:
-		flag := atomic.SwapUint32(&e.frameFlags, 0)
-		return e.encodeYUVFrame(dst, flag, j)
+// SetFrameRate sets the duration of frames without one, which rate control
+// budgets for; the timebase stays the RTP clock.
+func (e *Encoder) SetFrameRate(frameRate float64) error {
+	if frameRate <= 0 {
+		return codecError(C.VPX_CODEC_INVALID_PARAM)
+	}
+	e.frameDuration = frameDuration(frameRate)
+	return nil
+}
+
+// SetResolution changes the size of frames, and forces the next one to be a
+// key frame. If the encoder has to be reinitialised and that fails, it
+// cannot be used any more.
+func (e *Encoder) SetResolution(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return codecError(C.VPX_CODEC_INVALID_PARAM)
+	}
+	var vp9 C.int
+	if e.vp9 {
+		vp9 = 1
+	}
+	if err := codecError(C.setSize(e.ctx, e.img, &e.cfg, C.uint(width), C.uint(height), vp9)); err != nil {
+		return err
 	}
-	panic("not implemented")
+	return e.ForceIntraFrame()
 }
 
-func (e *encoder) encodeYUVFrame(dst []byte, flag uint32, i *image.YCbCr) (int, error) {
+// EncodeFrame encodes i at its timing if it is a video.Frame or points to
+// one, other images with timestamps derived from the frame rate.
+func (e *Encoder) EncodeFrame(dst []byte, i image.Image) (codec.Packet, error) {
+	if t, ok := video.TimingOf(i); ok {
+		return e.EncodeFrameAt(dst, i, t)
+	}
+	t := video.Timing{
+		PTS:      fromTicks(e.nextPTS),
+		Duration: fromTicks(e.frameDuration),
+		Sequence: uint64(e.frameCount),
+	}
+	e.nextPTS += e.frameDuration
+	return e.EncodeFrameAt(dst, i, t)
+}
+
+// EncodeFrameAt encodes i, or the image of a video.Frame, captured at t.PTS.
+func (e *Encoder) EncodeFrameAt(dst []byte, i image.Image, t video.Timing) (codec.Packet, error) {
+	img, err := e.toYUV420(video.ImageOf(i))
+	if err != nil {
+		return codec.Packet{}, err
+	}
+	flag := atomic.SwapUint32(&e.frameFlags, 0)
+	return e.encodeYUVFrame(dst, flag, img, t)
+}
+
+// toYUV420 returns img if it is 4:2:0 Y'CbCr, or converts it in the
+// colorimetry of the encoder.
+func (e *Encoder) toYUV420(img image.Image) (*image.YCbCr, error) {
+	if yuv, ok := img.(*image.YCbCr); ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		return yuv, nil
+	}
+	if size := img.Bounds().Size(); e.yuv == nil || e.yuv.Rect.Size() != size {
+		e.yuv = image.NewYCbCr(image.Rectangle{Max: size}, image.YCbCrSubsampleRatio420)
+	}
+	if err := video.ConvertTo(e.yuv, img, e.colorimetry); err != nil {
+		return nil, err
+	}
+	return e.yuv, nil
+}
+
+func (e *Encoder) encodeYUVFrame(dst []byte, flag uint32, i *image.YCbCr, t video.Timing) (codec.Packet, error) {
+	if i.Rect.Dx() != int(e.cfg.g_w) || i.Rect.Dy() != int(e.cfg.g_h) {
+		return codec.Packet{}, codec.ErrFrameSize
+	}
 	e.img.stride[0] = C.int(i.YStride)
 	e.img.stride[1] = C.int(i.CStride)
 	e.img.stride[2] = C.int(i.CStride)
@@ -180,12 +403,56 @@ func (e *encoder) encodeYUVFrame(dst []byte, flag uint32, i *image.YCbCr) (int,
 	if e.keyFrameInterval > 0 && e.frameCount%int64(e.keyFrameInterval) == 0 {
 		flag |= C.VPX_EFLAG_FORCE_KF
 	}
-	// FIXME: on resolution change?
-	err := C.vpx_codec_encode(e.ctx, e.img, C.vpx_codec_pts_t(e.frameCount), 1, C.vpx_enc_frame_flags_t(flag), C.VPX_DL_REALTIME)
+	pts := toTicks(t.PTS)
+	if pts <= e.lastPTS {
+		pts = e.lastPTS + 1 // libvpx requires strictly increasing timestamps
+	}
+	duration := toTicks(t.Duration)
+	if duration <= 0 {
+		duration = e.frameDuration
+	}
+	err := C.vpx_codec_encode(e.ctx, e.img, C.vpx_codec_pts_t(pts), C.ulong(duration), C.vpx_enc_frame_flags_t(flag), C.VPX_DL_REALTIME)
 	if err != C.VPX_CODEC_OK {
-		return 0, codecError(err)
+		return codec.Packet{}, codecError(err)
 	}
+	e.lastPTS = pts
 	e.frameCount++
-	size := C.copyFrame(e.ctx, (*C.uchar)(&dst[0]))
-	return int(size), nil
+	var flags C.vpx_codec_frame_flags_t
+	var outPTS C.vpx_codec_pts_t
+	out := dst
+	if dst == nil {
+		out = e.buf[:cap(e.buf)]
+	}
+	size := e.copyFrame(out, &flags, &outPTS)
+	if size > len(out) {
+		if dst != nil {
+			e.ForceIntraFrame()
+			return codec.Packet{}, &codec.ShortBufferError{Size: size}
+		}
+		e.buf = make([]byte, size+size/4)
+		out = e.buf
+		size = e.copyFrame(out, &flags, &outPTS)
+	}
+	p := codec.Packet{Data: out[:size], Type: codec.FrameSkipped}
+	if size == 0 {
+		// dropped by rate control
+		return p, nil
+	}
+	p.PTS = t.PTS + fromTicks(int64(outPTS)-toTicks(t.PTS))
+	p.DTS, p.Duration = p.PTS, fromTicks(duration)
+	p.Keyframe = flags&C.VPX_FRAME_IS_KEY != 0
+	p.Droppable = flags&C.VPX_FRAME_IS_DROPPABLE != 0
+	p.Type = codec.FrameInter
+	if p.Keyframe {
+		p.Type = codec.FrameKey
+	}
+	return p, nil
+}
+
+func (e *Encoder) copyFrame(dst []byte, flags *C.vpx_codec_frame_flags_t, pts *C.vpx_codec_pts_t) int {
+	var p *C.uchar
+	if len(dst) > 0 {
+		p = (*C.uchar)(&dst[0])
+	}
+	return int(C.copyFrame(e.ctx, p, C.size_t(len(dst)), flags, pts))
 }
@@ -0,0 +1,120 @@
+package vpx
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/codec"
+	"github.com/zyxar/mediastream/lib/codec/codectest"
+)
+
+var encoders = []struct {
+	name string
+	new  func(o codec.Options) (*Encoder, error)
+}{
+	{"vp8", NewVP8Encoder},
+	{"vp9", NewVP9Encoder},
+}
+
+func TestEncoderContract(t *testing.T) {
+	for _, c := range encoders {
+		t.Run(c.name, func(t *testing.T) { codectest.Run(t, c.name) })
+	}
+}
+
+// keyFrameSize reads the frame size from the header of a VP8 or VP9 key
+// frame, or returns the zero point.
+func keyFrameSize(vp9 bool, data []byte) image.Point {
+	if !vp9 {
+		// frame tag, start code, then 14 bits of width and of height
+		if len(data) < 10 || data[0]&1 != 0 || data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+			return image.Point{}
+		}
+		return image.Pt(int(binary.LittleEndian.Uint16(data[6:])&0x3fff), int(binary.LittleEndian.Uint16(data[8:])&0x3fff))
+	}
+	// profile 0: frame marker, profile, show_existing_frame, frame_type,
+	// show_frame and error_resilient_mode in a byte, the sync code, then
+	// color_space and color_range in 4 bits before 16 bits of width - 1 and
+	// of height - 1
+	if len(data) < 12 || data[0]&0xfc != 0x80 || data[1] != 0x49 || data[2] != 0x83 || data[3] != 0x42 {
+		return image.Point{}
+	}
+	v := binary.BigEndian.Uint64(data[4:])
+	return image.Pt(int(v>>44&0xffff)+1, int(v>>28&0xffff)+1)
+}
+
+func TestReconfigure(t *testing.T) {
+	for _, c := range encoders {
+		t.Run(c.name, func(t *testing.T) { testReconfigure(t, c.new) })
+	}
+}
+
+func testReconfigure(t *testing.T, newEncoder func(o codec.Options) (*Encoder, error)) {
+	e, err := newEncoder(codec.Options{Width: 320, Height: 240, FrameRate: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	// a moving gradient, which noise would make key frames of
+	n := 0
+	encode := func(img *image.YCbCr) codec.Packet {
+		t.Helper()
+		n++
+		for y := 0; y < img.Rect.Dy(); y++ {
+			for x := 0; x < img.Rect.Dx(); x++ {
+				img.Y[y*img.YStride+x] = uint8(x + y + 2*n)
+			}
+		}
+		for i := range img.Cb {
+			img.Cb[i], img.Cr[i] = 128, 128
+		}
+		pkt, err := e.EncodeFrame(nil, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pkt
+	}
+	large := image.NewYCbCr(image.Rect(0, 0, 320, 240), image.YCbCrSubsampleRatio420)
+	for i := 0; i < 3; i++ {
+		encode(large)
+	}
+	if err = e.SetBitrate(2_000_000); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.SetFrameRate(15); err != nil {
+		t.Fatal(err)
+	}
+	if pkt := encode(large); pkt.Keyframe || pkt.Duration != time.Second/15 {
+		t.Errorf("unexpected %v frame of %v after a new bitrate and frame rate", pkt.Type, pkt.Duration)
+	}
+	// growing beyond the initial size makes VP8 start over
+	for _, size := range []image.Point{{176, 144}, {320, 240}, {640, 480}, {175, 99}} {
+		if err = e.SetResolution(size.X, size.Y); err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if _, err = e.EncodeFrame(nil, image.NewYCbCr(image.Rect(0, 0, 64, 64), image.YCbCrSubsampleRatio420)); err != codec.ErrFrameSize {
+			t.Errorf("%v: expected ErrFrameSize, got %v", size, err)
+		}
+		img := image.NewYCbCr(image.Rectangle{Max: size}, image.YCbCrSubsampleRatio420)
+		pkt := encode(img)
+		if !pkt.Keyframe {
+			t.Errorf("%v: expected a key frame, got %v", size, pkt.Type)
+		} else if got := keyFrameSize(e.vp9, pkt.Data); got != size {
+			t.Errorf("%v: key frame of size %v", size, got)
+		}
+		if pkt = encode(img); pkt.Keyframe {
+			t.Errorf("%v: unexpected key frame", size)
+		}
+	}
+	if err = e.SetBitrate(500); err != nil || e.cfg.rc_target_bitrate != 1 {
+		t.Errorf("expected a target of 1 kbit/s for 500 bit/s, got %d: %v", e.cfg.rc_target_bitrate, err)
+	}
+	if err = e.SetBitrate(0); err == nil {
+		t.Error("expected an error for a bitrate of 0")
+	}
+	if err = e.SetResolution(0, 240); err == nil {
+		t.Error("expected an error for a width of 0")
+	}
+}
@@ -0,0 +1,137 @@
+package format
+
+import "strings"
+
+// Layout is how the samples of a pixel format are arranged in memory.
+type Layout int
+
+const (
+	Planar     Layout = iota // one plane per component
+	SemiPlanar               // a luma plane, then a plane of interleaved chroma pairs
+	Packed                   // all components interleaved in one plane
+	Compressed               // a bitstream without planes of samples
+)
+
+func (l Layout) String() string {
+	switch l {
+	case Planar:
+		return "planar"
+	case SemiPlanar:
+		return "semi-planar"
+	case Packed:
+		return "packed"
+	case Compressed:
+		return "compressed"
+	}
+	return "unknown"
+}
+
+// Descriptor describes the memory layout of a pixel format.
+type Descriptor struct {
+	Format       PixelFormat // the primary name
+	Layout       Layout
+	Planes       int  // 0 for compressed formats
+	BitsPerPixel int  // averaged over the chroma block; 0 for compressed formats
+	SubX, SubY   int  // luma samples per chroma sample across and down; 0 if they vary
+	RGB          bool // R'G'B' rather than Y'CbCr
+}
+
+var descriptors = map[PixelFormat]Descriptor{
+	I420: {I420, Planar, 3, 12, 2, 2, false},
+	I422: {I422, Planar, 3, 16, 2, 1, false},
+	I444: {I444, Planar, 3, 24, 1, 1, false},
+	NV21: {NV21, SemiPlanar, 2, 12, 2, 2, false},
+	NV12: {NV12, SemiPlanar, 2, 12, 2, 2, false},
+	YUY2: {YUY2, Packed, 1, 16, 2, 1, false},
+	UYVY: {UYVY, Packed, 1, 16, 2, 1, false},
+	YV12: {YV12, Planar, 3, 12, 2, 2, false},
+	YV24: {YV24, Planar, 3, 24, 1, 1, false},
+	ARGB: {ARGB, Packed, 1, 32, 1, 1, true},
+	BGRA: {BGRA, Packed, 1, 32, 1, 1, true},
+	RAW:  {RAW, Packed, 1, 24, 1, 1, true},
+	RGBA: {RGBA, Packed, 1, 32, 1, 1, true},
+	MJPG: {MJPG, Compressed, 0, 0, 0, 0, false},
+}
+
+// Descriptor returns the descriptor of f, or of the format it is an alias of.
+func (f PixelFormat) Descriptor() (Descriptor, bool) {
+	d, ok := descriptors[f.Canonical()]
+	return d, ok
+}
+
+// PlaneSize returns the bytes per row and the rows of plane i of a tightly
+// packed frame of width×height pixels. Partial chroma blocks at the right and
+// bottom edges are rounded up.
+func (d Descriptor) PlaneSize(i, width, height int) (rowBytes, rows int) {
+	if i < 0 || i >= d.Planes {
+		return 0, 0
+	}
+	cw, ch := (width+d.SubX-1)/d.SubX, (height+d.SubY-1)/d.SubY
+	switch {
+	case d.Layout == Packed:
+		return cw * d.SubX * d.BitsPerPixel / 8, height
+	case i == 0:
+		return width, height
+	case d.Layout == SemiPlanar:
+		return 2 * cw, ch
+	}
+	return cw, ch
+}
+
+// FrameSize returns the size of a frame of width×height pixels whose plane i
+// has rows strides[i] bytes apart; missing or zero strides mean tightly
+// packed rows. It returns 0 for compressed formats.
+func (d Descriptor) FrameSize(width, height int, strides []int) int {
+	size := 0
+	for i := 0; i < d.Planes; i++ {
+		stride, rows := d.PlaneSize(i, width, height)
+		if i < len(strides) && strides[i] > 0 {
+			stride = strides[i]
+		}
+		size += stride * rows
+	}
+	return size
+}
+
+// FrameSize is like Descriptor.FrameSize; it returns 0 for unknown formats.
+func (f PixelFormat) FrameSize(width, height int, strides []int) int {
+	d, _ := f.Descriptor()
+	return d.FrameSize(width, height, strides)
+}
+
+// FourCC returns the pixel format named by the four character code c, stored
+// first character in the lowest byte as V4L2 and RIFF do, with trailing
+// spaces removed. The format may be unknown.
+func FourCC(c uint32) PixelFormat {
+	b := []byte{byte(c), byte(c >> 8), byte(c >> 16), byte(c >> 24)}
+	return PixelFormat(strings.TrimRight(string(b), " \x00"))
+}
+
+// FourCC returns the four character code of f, padded with spaces, the
+// inverse of the package function FourCC.
+func (f PixelFormat) FourCC() (uint32, bool) {
+	if len(f) == 0 || len(f) > 4 {
+		return 0, false
+	}
+	var c uint32
+	for i := 3; i >= 0; i-- {
+		b := byte(' ')
+		if i < len(f) {
+			b = f[i]
+		}
+		c = c<<8 | uint32(b)
+	}
+	return c, true
+}
+
+// Parse returns the primary name of the known pixel format named s, in any
+// case, or by an alias.
+func Parse(s string) (PixelFormat, bool) {
+	d, ok := PixelFormat(strings.ToUpper(strings.TrimSpace(s))).Descriptor()
+	return d.Format, ok
+}
+
+// ParseFourCC is like Parse for the four character code c; see FourCC.
+func ParseFourCC(c uint32) (PixelFormat, bool) {
+	return Parse(string(FourCC(c)))
+}
@@ -12,6 +12,10 @@ const ( // ref: https://chromium.googlesource.com/libyuv/libyuv/+show/master/doc
 	YUY2 PixelFormat = "YUY2"
 	UYVY PixelFormat = "UYVY"
 
+	// Auxiliary YUV formats:
+	YV12 PixelFormat = "YV12"
+	YV24 PixelFormat = "YV24"
+
 	// Primary RGB formats:
 	ARGB PixelFormat = "ARGB"
 	BGRA PixelFormat = "BGRA"
@@ -22,13 +26,38 @@ const ( // ref: https://chromium.googlesource.com/libyuv/libyuv/+show/master/doc
 	MJPG PixelFormat = "MJPG"
 
 	// Auxiliary aliases.
-	IYUV = I420 // Alias for I420.
-	YU16 = I422 // Alias for I422.
-	YU24 = I444 // Alias for I444.
-	YUYV = YUY2 // Alias for YUY2.
-	YUVS = YUY2 // Alias for YUY2 on Mac.
-	JPEG = MJPG // Alias for MJPG.
-	RGB3 = RAW  // Alias for RAW.
-	CM32 = BGRA // Alias for BGRA kCVPixelFormatType_32ARGB
-	CM24 = RAW  // Alias for RAW kCVPixelFormatType_24RGB
+	IYUV PixelFormat = "IYUV" // Alias for I420.
+	YU12 PixelFormat = "YU12" // Alias for I420.
+	YU16 PixelFormat = "YU16" // Alias for I422.
+	YU24 PixelFormat = "YU24" // Alias for I444.
+	YUYV PixelFormat = "YUYV" // Alias for YUY2.
+	YUVS PixelFormat = "YUVS" // Alias for YUY2 on Mac.
+	JPEG PixelFormat = "JPEG" // Alias for MJPG.
+	DMB1 PixelFormat = "DMB1" // Alias for MJPG on Mac.
+	RGB3 PixelFormat = "RGB3" // Alias for RAW.
+	CM32 PixelFormat = "CM32" // Alias for BGRA kCVPixelFormatType_32ARGB
+	CM24 PixelFormat = "CM24" // Alias for RAW kCVPixelFormatType_24RGB
 )
+
+var aliases = map[PixelFormat]PixelFormat{
+	IYUV: I420,
+	YU12: I420,
+	YU16: I422,
+	YU24: I444,
+	YUYV: YUY2,
+	YUVS: YUY2,
+	JPEG: MJPG,
+	DMB1: MJPG,
+	RGB3: RAW,
+	CM32: BGRA,
+	CM24: RAW,
+}
+
+// Canonical returns the primary name of pixel format f, which is f itself
+// unless f names an alias.
+func (f PixelFormat) Canonical() PixelFormat {
+	if c, ok := aliases[f]; ok {
+		return c
+	}
+	return f
+}
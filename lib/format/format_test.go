@@ -0,0 +1,101 @@
+package format
+
+import "testing"
+
+func TestFrameSize(t *testing.T) {
+	for _, c := range []struct {
+		f             PixelFormat
+		width, height int
+		strides       []int
+		size          int
+	}{
+		{I420, 4, 2, nil, 12},
+		{IYUV, 5, 3, nil, 15 + 2*3*2},
+		{YV12, 4, 2, []int{8, 4, 4}, 16 + 4 + 4},
+		{I422, 5, 2, nil, 10 + 2*3*2},
+		{I444, 3, 3, nil, 27},
+		{NV12, 5, 3, nil, 15 + 6*2},
+		{NV21, 4, 2, []int{6}, 12 + 4},
+		{YUYV, 5, 2, nil, 12 * 2},
+		{UYVY, 4, 2, []int{16}, 32},
+		{RAW, 3, 2, nil, 18},
+		{CM32, 3, 2, nil, 24},
+		{MJPG, 640, 480, nil, 0},
+		{"XXXX", 640, 480, nil, 0},
+	} {
+		if size := c.f.FrameSize(c.width, c.height, c.strides); size != c.size {
+			t.Errorf("%s %dx%d %v: expected %d bytes, got %d", c.f, c.width, c.height, c.strides, c.size, size)
+		}
+	}
+}
+
+func TestDescriptor(t *testing.T) {
+	for f := range descriptors {
+		d, ok := f.Descriptor()
+		if !ok || d.Format != f {
+			t.Errorf("%s: unexpected descriptor %+v", f, d)
+		}
+		if d.Layout == Compressed {
+			continue
+		}
+		bits := 0
+		for i := 0; i < d.Planes; i++ {
+			// one chroma block
+			rowBytes, rows := d.PlaneSize(i, d.SubX, d.SubY)
+			bits += 8 * rowBytes * rows
+		}
+		if bits != d.BitsPerPixel*d.SubX*d.SubY {
+			t.Errorf("%s: planes hold %d bits per block of %d pixels, expected %d per pixel", f, bits, d.SubX*d.SubY, d.BitsPerPixel)
+		}
+	}
+	for alias, f := range aliases {
+		if d, ok := alias.Descriptor(); !ok || d.Format != f {
+			t.Errorf("%s: expected the descriptor of %s, got %+v", alias, f, d)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		s  string
+		f  PixelFormat
+		ok bool
+	}{
+		{"I420", I420, true},
+		{"yuyv", YUY2, true},
+		{" nv12", NV12, true},
+		{"Raw", RAW, true},
+		{"H264", "", false},
+	} {
+		if f, ok := Parse(c.s); f != c.f || ok != c.ok {
+			t.Errorf("%q: expected %q %v, got %q %v", c.s, c.f, c.ok, f, ok)
+		}
+	}
+}
+
+func TestFourCC(t *testing.T) {
+	for _, c := range []struct {
+		code uint32
+		f    PixelFormat
+		ok   bool
+	}{
+		{'Y' | 'U'<<8 | 'Y'<<16 | 'V'<<24, YUY2, true},
+		{'Y' | 'U'<<8 | '1'<<16 | '2'<<24, I420, true},
+		{'M' | 'J'<<8 | 'P'<<16 | 'G'<<24, MJPG, true},
+		{'R' | 'A'<<8 | 'W'<<16 | ' '<<24, RAW, true},
+		{'H' | '2'<<8 | '6'<<16 | '4'<<24, "", false},
+	} {
+		if f, ok := ParseFourCC(c.code); f != c.f || ok != c.ok {
+			t.Errorf("%q: expected %q %v, got %q %v", FourCC(c.code), c.f, c.ok, f, ok)
+		}
+	}
+	for f := range descriptors {
+		c, ok := f.FourCC()
+		if !ok || FourCC(c) != f {
+			t.Errorf("%s: round trip through %#x gave %q", f, c, FourCC(c))
+		}
+	}
+	if _, ok := PixelFormat("TOOLONG").FourCC(); ok {
+		t.Error("expected no code for a name of more than 4 characters")
+	}
+}
@@ -0,0 +1,148 @@
+package testsrc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+)
+
+// SMPTE ECR 1-1978 colour bars, in 8-bit RGB.
+var (
+	barsTop = [...]color.RGBA{
+		{191, 191, 191, 255}, // grey
+		{191, 191, 0, 255},   // yellow
+		{0, 191, 191, 255},   // cyan
+		{0, 191, 0, 255},     // green
+		{191, 0, 191, 255},   // magenta
+		{191, 0, 0, 255},     // red
+		{0, 0, 191, 255},     // blue
+	}
+	barsMiddle = [...]color.RGBA{
+		{0, 0, 191, 255},
+		{19, 19, 19, 255},
+		{191, 0, 191, 255},
+		{19, 19, 19, 255},
+		{0, 191, 191, 255},
+		{19, 19, 19, 255},
+		{191, 191, 191, 255},
+	}
+	barsBottom = [...]struct {
+		c     color.RGBA
+		width int // in 1/12 of a top bar
+	}{
+		{color.RGBA{0, 33, 76, 255}, 15},     // -I
+		{color.RGBA{255, 255, 255, 255}, 15}, // white
+		{color.RGBA{50, 0, 106, 255}, 15},    // +Q
+		{color.RGBA{19, 19, 19, 255}, 15},    // black
+		{color.RGBA{9, 9, 9, 255}, 4},        // pluge: super black
+		{color.RGBA{19, 19, 19, 255}, 4},     // pluge: black
+		{color.RGBA{29, 29, 29, 255}, 4},     // pluge: grey
+		{color.RGBA{19, 19, 19, 255}, 12},    // black
+	}
+)
+
+func (s *Source) render(frame int64, pts time.Duration) {
+	w, h := s.p.Width, s.p.Height
+	s.drawBars(w, h)
+
+	// moving box, bouncing left and right across the bars
+	size := h / 8
+	if size < 2 {
+		size = 2
+	}
+	if span := w - size; span > 0 {
+		x := int(frame*4) % (2 * span)
+		if x > span {
+			x = 2*span - x
+		}
+		s.fill(x, (h*2/3-size)/2, size, size, color.RGBA{235, 235, 235, 255})
+	}
+
+	// frame counter and timestamp
+	scale := h / 120
+	if scale < 1 {
+		scale = 1
+	}
+	s.drawText(2*scale, h*3/4+2*scale, scale, fmt.Sprintf("%08d", frame))
+	s.drawText(2*scale, h*3/4+(glyphHeight+4)*scale, scale, formatTimestamp(pts))
+}
+
+func (s *Source) drawBars(w, h int) {
+	top, middle := h*2/3, h*3/4
+	for i, c := range barsTop {
+		x0, x1 := i*w/7, (i+1)*w/7
+		s.fill(x0, 0, x1-x0, top, c)
+	}
+	for i, c := range barsMiddle {
+		x0, x1 := i*w/7, (i+1)*w/7
+		s.fill(x0, top, x1-x0, middle-top, c)
+	}
+	x := 0
+	for i, b := range barsBottom {
+		x1 := x + b.width*w/(7*12)
+		if i == len(barsBottom)-1 {
+			x1 = w
+		}
+		s.fill(x, middle, x1-x, h-middle, b.c)
+		x = x1
+	}
+}
+
+func (s *Source) fill(x, y, w, h int, c color.RGBA) {
+	yy, cb, cr := s.p.Colorimetry.RGBToYCbCr(c.R, c.G, c.B)
+	r := image.Rect(x, y, x+w, y+h).Intersect(s.img.Rect)
+	for row := r.Min.Y; row < r.Max.Y; row++ {
+		for col := r.Min.X; col < r.Max.X; col++ {
+			s.img.Y[s.img.YOffset(col, row)] = yy
+			i := s.img.COffset(col, row)
+			s.img.Cb[i] = cb
+			s.img.Cr[i] = cr
+		}
+	}
+}
+
+func formatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+)
+
+// 3x5 bitmap font, one row per byte with the most significant of the three
+// low bits on the left.
+var glyphs = map[rune][glyphHeight]byte{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 2, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	':': {0, 2, 0, 2, 0},
+	'.': {0, 0, 0, 0, 2},
+}
+
+func (s *Source) drawText(x, y, scale int, text string) {
+	black := color.RGBA{0, 0, 0, 255}
+	white := color.RGBA{255, 255, 255, 255}
+	advance := (glyphWidth + 1) * scale
+	s.fill(x-scale, y-scale, len(text)*advance+scale, (glyphHeight+2)*scale, black)
+	for _, ch := range text {
+		g := glyphs[ch]
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if g[row]&(1<<(glyphWidth-1-col)) != 0 {
+					s.fill(x+col*scale, y+row*scale, scale, scale, white)
+				}
+			}
+		}
+		x += advance
+	}
+}
@@ -0,0 +1,124 @@
+package testsrc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+const (
+	DefaultWidth     = 640
+	DefaultHeight    = 480
+	DefaultFrameRate = 30
+)
+
+type Property = capture.Property
+
+// Source is a synthetic capture source rendering SMPTE colour bars, a moving
+// box, a frame counter and a burned-in timestamp.
+type Source struct {
+	p       Property
+	bufSize int
+	img     *image.YCbCr // 4:4:4 canvas, packed into p.PixelFormat on read
+	jpeg    bytes.Buffer // MJPG frame
+	frame   int64
+	start   time.Time
+}
+
+// NewSource creates a source of p, in any raw format the encoders of package
+// video support, or in MJPG with the colorimetry of JFIF.
+func NewSource(p Property) (*Source, error) {
+	if p.PixelFormat == "" {
+		p.PixelFormat = format.I420
+	}
+	if p.Width == 0 && p.Height == 0 {
+		p.Width, p.Height = DefaultWidth, DefaultHeight
+	}
+	if p.FrameRate <= 0 {
+		p.FrameRate = DefaultFrameRate
+	}
+	if p.Width <= 0 || p.Height <= 0 {
+		return nil, fmt.Errorf("testsrc: invalid frame size %dx%d", p.Width, p.Height)
+	}
+	size := video.FrameSize(p.PixelFormat, p.Width, p.Height)
+	if p.PixelFormat.Canonical() == format.MJPG {
+		// the canvas is drawn in the colorimetry of the JPEG samples; its
+		// raw 4:4:4 size bounds the compressed frame
+		p.Colorimetry = video.JPEG
+		size = 3*p.Width*p.Height + jpegHeaderSize
+	}
+	if size == 0 {
+		return nil, video.ErrUnsupportedPixelFormat
+	}
+	return &Source{
+		p:       p,
+		bufSize: size,
+		img:     image.NewYCbCr(image.Rect(0, 0, p.Width, p.Height), image.YCbCrSubsampleRatio444),
+	}, nil
+}
+
+func (s *Source) BufferSize() int    { return s.bufSize }
+func (s *Source) Property() Property { return s.p }
+func (s *Source) Close()             {}
+
+// jpegHeaderSize is more than the markers and tables jpeg.Encode writes.
+const jpegHeaderSize = 1024
+
+// ReadVideoFrame renders the next frame into buf, blocking until it is due
+// according to the frame rate. It returns capture.ErrTimeout instead if that
+// is more than capture.StallTimeout away.
+func (s *Source) ReadVideoFrame(ctx context.Context, buf []byte) (info capture.FrameInfo, err error) {
+	if len(buf) < s.bufSize {
+		return info, video.ErrInsufficientFrameBuffer
+	}
+	info.PTS = time.Duration(float64(s.frame) * float64(time.Second) / s.p.FrameRate)
+	info.Duration = time.Duration(float64(time.Second) / s.p.FrameRate)
+	info.Sequence = uint64(s.frame)
+	if s.frame == 0 {
+		s.start = time.Now()
+	} else if wait := time.Until(s.start.Add(info.PTS)); wait > capture.StallTimeout {
+		if err = capture.Sleep(ctx, capture.StallTimeout); err != nil {
+			return capture.FrameInfo{}, err
+		}
+		return capture.FrameInfo{}, capture.ErrTimeout
+	} else if err = capture.Sleep(ctx, wait); err != nil {
+		return capture.FrameInfo{}, err
+	}
+	s.render(s.frame, info.PTS)
+	if info.Size, err = s.encode(buf); err != nil {
+		return capture.FrameInfo{}, err
+	}
+	s.frame++
+	return info, nil
+}
+
+func (s *Source) encode(buf []byte) (int, error) {
+	if s.p.PixelFormat.Canonical() != format.MJPG {
+		return video.EncodeTo(buf, s.p.PixelFormat, s.img, s.p.Colorimetry)
+	}
+	s.jpeg.Reset()
+	if err := jpeg.Encode(&s.jpeg, s.img, nil); err != nil {
+		return 0, err
+	}
+	if s.jpeg.Len() > len(buf) {
+		return 0, video.ErrInsufficientFrameBuffer
+	}
+	return copy(buf, s.jpeg.Bytes()), nil
+}
+
+func init() {
+	capture.Register("testsrc", capture.DriverFunc(func(p capture.Property) (capture.Source, error) {
+		s, err := NewSource(p)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}))
+}
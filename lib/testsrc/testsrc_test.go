@@ -0,0 +1,138 @@
+package testsrc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+func TestSource(t *testing.T) {
+	formats := []format.PixelFormat{
+		format.I420, format.I422, format.I444, format.NV12, format.NV21,
+		format.YV12, format.YV24, format.YUY2, format.UYVY,
+		format.ARGB, format.BGRA, format.RGBA, format.RAW, format.MJPG,
+	}
+	for _, f := range formats {
+		f := f
+		t.Run(string(f), func(t *testing.T) {
+			s, err := NewSource(Property{PixelFormat: f, Width: 160, Height: 120, FrameRate: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			p := s.Property()
+			prev := make([]byte, s.BufferSize())
+			buf := make([]byte, s.BufferSize())
+			for i := 0; i < 3; i++ {
+				info, err := s.ReadVideoFrame(context.Background(), buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size != s.BufferSize() && (f != format.MJPG || info.Size == 0 || info.Size > s.BufferSize()) {
+					t.Fatalf("size mismatch: %d != %d", info.Size, s.BufferSize())
+				}
+				if info.Sequence != uint64(i) || info.PTS != time.Duration(i)*time.Millisecond || info.Duration != time.Millisecond {
+					t.Errorf("unexpected timing: %+v", info.Timing)
+				}
+				if bytes.Equal(prev, buf) {
+					t.Errorf("frame %d is identical to previous frame", i)
+				}
+				copy(prev, buf)
+				frame, err := video.Decode(info.Raw(p, buf))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if frame.Timing != info.Timing || frame.Format != p.PixelFormat || frame.Colorimetry != p.Colorimetry {
+					t.Errorf("metadata not carried over: %+v", frame)
+				}
+				// top right corner is inside the blue bar
+				var r, g, b uint8
+				switch img := frame.Image.(type) {
+				case *image.YCbCr:
+					c := img.YCbCrAt(p.Width-1, 0)
+					r, g, b = p.Colorimetry.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+				case *image.RGBA:
+					c := img.RGBAAt(p.Width-1, 0)
+					r, g, b = c.R, c.G, c.B
+				}
+				if r > 8 || g > 8 || b < 180 || b > 200 {
+					t.Errorf("unexpected colour (%d, %d, %d) in blue bar", r, g, b)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceDefaults(t *testing.T) {
+	s, err := NewSource(Property{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := s.Property()
+	if p.PixelFormat != format.I420 || p.Width != DefaultWidth || p.Height != DefaultHeight || p.FrameRate != DefaultFrameRate {
+		t.Errorf("unexpected default property: %#v", p)
+	}
+	if _, err = NewSource(Property{PixelFormat: format.I420, Width: -160, Height: 120}); err == nil {
+		t.Error("expected error for negative width")
+	}
+	if _, err = NewSource(Property{PixelFormat: format.I420, Width: 161, Height: 121}); err != nil {
+		t.Errorf("odd frame size: %v", err)
+	}
+	if s, err = NewSource(Property{PixelFormat: format.MJPG}); err != nil || s.Property().Colorimetry != video.JPEG {
+		t.Errorf("MJPG: %v, colorimetry %v", err, s.Property().Colorimetry)
+	}
+	if _, err = NewSource(Property{PixelFormat: "XXXX"}); err != video.ErrUnsupportedPixelFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func BenchmarkSource(b *testing.B) {
+	s, err := NewSource(Property{PixelFormat: format.I420, Width: 1280, Height: 720, FrameRate: 1e9})
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, s.BufferSize())
+	for i := 0; i < b.N; i++ {
+		if _, err := s.ReadVideoFrame(context.Background(), buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSourceCancel(t *testing.T) {
+	s, err := NewSource(Property{Width: 16, Height: 16, FrameRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, s.BufferSize())
+	if _, err = s.ReadVideoFrame(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = s.ReadVideoFrame(ctx, buf); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestSourceStall(t *testing.T) {
+	defer func(d time.Duration) { capture.StallTimeout = d }(capture.StallTimeout)
+	capture.StallTimeout = 10 * time.Millisecond
+	s, err := NewSource(Property{Width: 16, Height: 16, FrameRate: 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, s.BufferSize())
+	if _, err = s.ReadVideoFrame(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.ReadVideoFrame(context.Background(), buf); err != capture.ErrTimeout {
+		t.Errorf("expected capture.ErrTimeout, got %v", err)
+	}
+}
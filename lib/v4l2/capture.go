@@ -0,0 +1,20 @@
+// +build linux,cgo
+
+package v4l2
+
+import "github.com/zyxar/mediastream/lib/capture"
+
+func init() {
+	capture.Register("v4l2", driver{})
+}
+
+type driver struct{}
+
+func (driver) Devices() ([]capture.Device, error) { return Devices() }
+func (driver) Open(p capture.Property) (capture.Source, error) {
+	s, err := NewSession(p)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,76 @@
+// +build linux
+
+package v4l2
+
+/*
+#include <stdlib.h>
+#include "v4l2.h"
+
+extern int listFormats(const char *device, char *name, size_t nameSize, FormatInfo **formats, int *count);
+*/
+import "C"
+import (
+	"path/filepath"
+	"sort"
+	"syscall"
+	"unsafe"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+// Devices lists the video capture devices under /dev with the formats,
+// frame sizes and frame rate ranges reported by their drivers. Stepwise frame
+// sizes are reported by their smallest and largest sizes; a zero size means
+// that the driver cannot enumerate sizes.
+func Devices() ([]capture.Device, error) {
+	nodes, err := filepath.Glob("/dev/video*")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(nodes)
+	var devices []capture.Device
+	for _, node := range nodes {
+		d, err := queryDevice(node)
+		if err != nil {
+			if err == syscall.ENODEV || err == syscall.EACCES || err == syscall.EBUSY {
+				continue
+			}
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+func queryDevice(node string) (d capture.Device, err error) {
+	var name [256]C.char
+	var formats *C.FormatInfo
+	var count C.int
+	path := C.CString(node)
+	defer C.free(unsafe.Pointer(path))
+	if ret := C.listFormats(path, &name[0], C.size_t(len(name)), &formats, &count); ret != 0 {
+		return d, syscall.Errno(ret)
+	}
+	defer C.free(unsafe.Pointer(formats))
+	d.ID = node
+	d.Name = C.GoString(&name[0])
+	for _, f := range (*[1 << 20]C.FormatInfo)(unsafe.Pointer(formats))[:count:count] {
+		d.Formats = append(d.Formats, capture.DeviceFormat{
+			PixelFormat:  fourCCPixelFormat(f.pixelFormat),
+			Width:        int(f.width),
+			Height:       int(f.height),
+			MinFrameRate: float64(f.minFrameRate),
+			MaxFrameRate: float64(f.maxFrameRate),
+		})
+	}
+	return d, nil
+}
+
+// fourCCPixelFormat maps c to a known pixel format, or to its FourCC text.
+func fourCCPixelFormat(c C.uint32_t) format.PixelFormat {
+	if pf, ok := fourCCToPixelFormat(c); ok {
+		return pf
+	}
+	return format.FourCC(uint32(c))
+}
@@ -0,0 +1,75 @@
+// +build linux
+
+package v4l2
+
+/*
+#include <stdint.h>
+#include <linux/videodev2.h>
+*/
+import "C"
+import (
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+// codes are the V4L2 pixel formats named like a format of package format, or
+// one of its aliases.
+var codes = []C.uint32_t{
+	C.V4L2_PIX_FMT_YUV420, // YU12
+	C.V4L2_PIX_FMT_NV12,
+	C.V4L2_PIX_FMT_NV21,
+	C.V4L2_PIX_FMT_YUYV,
+	C.V4L2_PIX_FMT_UYVY,
+	C.V4L2_PIX_FMT_YVU420, // YV12
+	C.V4L2_PIX_FMT_RGB24,  // RGB3
+	C.V4L2_PIX_FMT_MJPEG,
+}
+
+// pixelFormats maps formats to V4L2 codes; those with other names than in
+// package format are listed here, the others are added from codes.
+var pixelFormats = map[format.PixelFormat]C.uint32_t{
+	format.I422: C.V4L2_PIX_FMT_YUV422P,
+	format.ARGB: C.V4L2_PIX_FMT_ABGR32, // B, G, R, A in memory
+	format.BGRA: C.V4L2_PIX_FMT_ARGB32, // A, R, G, B in memory
+	format.RGBA: C.V4L2_PIX_FMT_BGRA32, // A, B, G, R in memory
+}
+
+func init() {
+	for _, c := range codes {
+		if f, ok := format.ParseFourCC(uint32(c)); ok {
+			pixelFormats[f] = c
+		}
+	}
+}
+
+func pixelFormatToFourCC(pf format.PixelFormat) (c C.uint32_t, ok bool) {
+	c, ok = pixelFormats[pf.Canonical()]
+	return
+}
+
+func fourCCToPixelFormat(c C.uint32_t) (pf format.PixelFormat, ok bool) {
+	for f, cc := range pixelFormats {
+		if cc == c {
+			return f, true
+		}
+	}
+	return
+}
+
+// colorimetry maps the Y'CbCr encoding and quantization of a format; RGB
+// formats keep the default.
+func colorimetry(pf format.PixelFormat, enc, quantization C.uint32_t) (c video.Colorimetry) {
+	if d, _ := pf.Descriptor(); d.RGB {
+		return
+	}
+	switch enc {
+	case C.V4L2_YCBCR_ENC_709, C.V4L2_YCBCR_ENC_XV709:
+		c.Space = video.BT709
+	case C.V4L2_YCBCR_ENC_BT2020, C.V4L2_YCBCR_ENC_BT2020_CONST_LUM:
+		c.Space = video.BT2020
+	}
+	if quantization == C.V4L2_QUANTIZATION_FULL_RANGE {
+		c.Range = video.FullRange
+	}
+	return
+}
@@ -0,0 +1,391 @@
+#include "v4l2.h"
+
+#include <errno.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <fcntl.h>
+#include <string.h>
+#include <unistd.h>
+#include <poll.h>
+#include <sys/eventfd.h>
+#include <sys/ioctl.h>
+#include <sys/mman.h>
+
+static int xioctl(int fd, unsigned long request, void *arg)
+{
+    int r;
+    do {
+        r = ioctl(fd, request, arg);
+    } while (r == -1 && errno == EINTR);
+    return r;
+}
+
+static int initFormat(CaptureSession *s)
+{
+    struct v4l2_format fmt;
+    memset(&fmt, 0, sizeof(fmt));
+    fmt.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    if (xioctl(s->fd, VIDIOC_G_FMT, &fmt) == -1) {
+        return errno;
+    }
+    if (s->property.pixelFormat != 0) {
+        fmt.fmt.pix.pixelformat = s->property.pixelFormat;
+    }
+    if (s->property.width != 0 && s->property.height != 0) {
+        fmt.fmt.pix.width = s->property.width;
+        fmt.fmt.pix.height = s->property.height;
+    }
+    fmt.fmt.pix.field = V4L2_FIELD_NONE;
+    fmt.fmt.pix.bytesperline = 0;
+    if (xioctl(s->fd, VIDIOC_S_FMT, &fmt) == -1) {
+        return errno;
+    }
+    // the driver may adjust any of the requested values
+    s->property.pixelFormat = fmt.fmt.pix.pixelformat;
+    s->property.width = fmt.fmt.pix.width;
+    s->property.height = fmt.fmt.pix.height;
+    s->property.bytesPerLine = fmt.fmt.pix.bytesperline;
+    s->property.ycbcrEnc = fmt.fmt.pix.ycbcr_enc;
+    if (s->property.ycbcrEnc == V4L2_YCBCR_ENC_DEFAULT) {
+        s->property.ycbcrEnc = V4L2_MAP_YCBCR_ENC_DEFAULT(fmt.fmt.pix.colorspace);
+    }
+    s->property.quantization = fmt.fmt.pix.quantization;
+    if (s->property.quantization == V4L2_QUANTIZATION_DEFAULT) {
+        s->property.quantization = V4L2_MAP_QUANTIZATION_DEFAULT(0, fmt.fmt.pix.colorspace, s->property.ycbcrEnc);
+    }
+    s->bufferSize = fmt.fmt.pix.sizeimage;
+    return 0;
+}
+
+static int initFrameRate(CaptureSession *s)
+{
+    struct v4l2_streamparm parm;
+    memset(&parm, 0, sizeof(parm));
+    parm.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    if (xioctl(s->fd, VIDIOC_G_PARM, &parm) == -1) {
+        return errno;
+    }
+    if (s->property.frameRate > 0 && (parm.parm.capture.capability & V4L2_CAP_TIMEPERFRAME)) {
+        parm.parm.capture.timeperframe.numerator = 1000;
+        parm.parm.capture.timeperframe.denominator = (uint32_t)(s->property.frameRate * 1000);
+        if (xioctl(s->fd, VIDIOC_S_PARM, &parm) == -1) {
+            return errno;
+        }
+    }
+    if (parm.parm.capture.timeperframe.numerator != 0) {
+        s->property.frameRate = (double)parm.parm.capture.timeperframe.denominator /
+                                (double)parm.parm.capture.timeperframe.numerator;
+    }
+    return 0;
+}
+
+static int initBuffers(CaptureSession *s)
+{
+    struct v4l2_requestbuffers req;
+    memset(&req, 0, sizeof(req));
+    req.count = MAX_BUFFER_COUNT;
+    req.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    req.memory = V4L2_MEMORY_MMAP;
+    if (xioctl(s->fd, VIDIOC_REQBUFS, &req) == -1) {
+        return errno;
+    }
+    if (req.count < 2) {
+        return ENOMEM;
+    }
+    if (req.count > MAX_BUFFER_COUNT) {
+        req.count = MAX_BUFFER_COUNT;
+    }
+
+    for (s->bufferCount = 0; s->bufferCount < req.count; s->bufferCount++) {
+        struct v4l2_buffer buf;
+        memset(&buf, 0, sizeof(buf));
+        buf.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+        buf.memory = V4L2_MEMORY_MMAP;
+        buf.index = s->bufferCount;
+        if (xioctl(s->fd, VIDIOC_QUERYBUF, &buf) == -1) {
+            return errno;
+        }
+        void *start = mmap(NULL, buf.length, PROT_READ | PROT_WRITE, MAP_SHARED, s->fd, buf.m.offset);
+        if (start == MAP_FAILED) {
+            return errno;
+        }
+        s->buffers[s->bufferCount].start = start;
+        s->buffers[s->bufferCount].length = buf.length;
+        if (xioctl(s->fd, VIDIOC_QBUF, &buf) == -1) {
+            s->bufferCount++;
+            return errno;
+        }
+    }
+    return 0;
+}
+
+void closeSession(CaptureSession *s)
+{
+    if (s->streaming) {
+        enum v4l2_buf_type type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+        xioctl(s->fd, VIDIOC_STREAMOFF, &type);
+        s->streaming = 0;
+    }
+    for (unsigned int i = 0; i < s->bufferCount; i++) {
+        munmap(s->buffers[i].start, s->buffers[i].length);
+        s->buffers[i].start = NULL;
+        s->buffers[i].length = 0;
+    }
+    s->bufferCount = 0;
+    if (s->fd >= 0) {
+        close(s->fd);
+        s->fd = -1;
+    }
+    if (s->wakefd >= 0) {
+        close(s->wakefd);
+        s->wakefd = -1;
+    }
+}
+
+int initSession(CaptureSession *s, const char *device)
+{
+    struct v4l2_capability cap;
+    enum v4l2_buf_type type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    uint32_t caps;
+    int ret;
+
+    s->bufferCount = 0;
+    s->streaming = 0;
+    s->wakefd = -1;
+    s->fd = open(device, O_RDWR | O_NONBLOCK);
+    if (s->fd == -1) {
+        return errno;
+    }
+    s->wakefd = eventfd(0, EFD_NONBLOCK | EFD_CLOEXEC);
+    if (s->wakefd == -1) {
+        ret = errno;
+        goto fail;
+    }
+
+    memset(&cap, 0, sizeof(cap));
+    if (xioctl(s->fd, VIDIOC_QUERYCAP, &cap) == -1) {
+        ret = errno;
+        goto fail;
+    }
+    caps = (cap.capabilities & V4L2_CAP_DEVICE_CAPS) ? cap.device_caps : cap.capabilities;
+    if (!(caps & V4L2_CAP_VIDEO_CAPTURE) || !(caps & V4L2_CAP_STREAMING)) {
+        ret = ENODEV;
+        goto fail;
+    }
+
+    if ((ret = initFormat(s)) != 0) {
+        goto fail;
+    }
+    if ((ret = initFrameRate(s)) != 0) {
+        goto fail;
+    }
+    if ((ret = initBuffers(s)) != 0) {
+        goto fail;
+    }
+    if (xioctl(s->fd, VIDIOC_STREAMON, &type) == -1) {
+        ret = errno;
+        goto fail;
+    }
+    s->streaming = 1;
+    return 0;
+
+fail:
+    closeSession(s);
+    return ret;
+}
+
+void interruptRead(CaptureSession *s)
+{
+    uint64_t one = 1;
+    if (write(s->wakefd, &one, sizeof(one)) == -1) {
+        // the counter is already non-zero
+    }
+}
+
+// waitFrame blocks until a buffer can be dequeued, the read is interrupted
+// (EINTR) or timeout milliseconds have passed (ETIMEDOUT).
+static int waitFrame(CaptureSession *s, int timeout)
+{
+    struct pollfd fds[2] = {
+        { .fd = s->fd, .events = POLLIN },
+        { .fd = s->wakefd, .events = POLLIN },
+    };
+    int r = poll(fds, 2, timeout);
+    if (r == -1) {
+        return errno;
+    }
+    if (fds[1].revents & POLLIN) {
+        uint64_t count;
+        if (read(s->wakefd, &count, sizeof(count)) == -1) {
+            // drained concurrently
+        }
+        return EINTR;
+    }
+    if (r == 0) {
+        return ETIMEDOUT;
+    }
+    if (fds[0].revents & (POLLERR | POLLHUP | POLLNVAL)) {
+        return EIO;
+    }
+    return 0;
+}
+
+int readVideoFrame(CaptureSession *s, uint8_t *dst, size_t size, int64_t *timestamp, int timeout)
+{
+    struct v4l2_buffer buf;
+    int len, ret;
+
+    memset(&buf, 0, sizeof(buf));
+    buf.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    buf.memory = V4L2_MEMORY_MMAP;
+    while (xioctl(s->fd, VIDIOC_DQBUF, &buf) == -1) {
+        if (errno != EAGAIN) {
+            return -errno;
+        }
+        if ((ret = waitFrame(s, timeout)) != 0) {
+            return -ret;
+        }
+    }
+    if (buf.index >= s->bufferCount) {
+        return -EIO;
+    }
+    *timestamp = (int64_t)buf.timestamp.tv_sec * 1000000000 + (int64_t)buf.timestamp.tv_usec * 1000;
+    len = buf.bytesused;
+    if ((size_t)len > size) {
+        len = -ENOSPC;
+    } else {
+        memcpy(dst, s->buffers[buf.index].start, len);
+    }
+    if (xioctl(s->fd, VIDIOC_QBUF, &buf) == -1) {
+        return -errno;
+    }
+    return len;
+}
+
+size_t getVideoBufferSize(CaptureSession *s)
+{
+    return s->bufferSize;
+}
+
+static int appendFormat(FormatInfo **formats, int *count, int *capacity, FormatInfo info)
+{
+    if (*count == *capacity) {
+        int n = *capacity ? *capacity * 2 : 16;
+        FormatInfo *p = realloc(*formats, n * sizeof(FormatInfo));
+        if (!p) {
+            return ENOMEM;
+        }
+        *formats = p;
+        *capacity = n;
+    }
+    (*formats)[(*count)++] = info;
+    return 0;
+}
+
+static double intervalToFrameRate(struct v4l2_fract f)
+{
+    return f.numerator ? (double)f.denominator / (double)f.numerator : 0;
+}
+
+static void getFrameRateRange(int fd, FormatInfo *info)
+{
+    struct v4l2_frmivalenum ival;
+    memset(&ival, 0, sizeof(ival));
+    ival.pixel_format = info->pixelFormat;
+    ival.width = info->width;
+    ival.height = info->height;
+    for (ival.index = 0; xioctl(fd, VIDIOC_ENUM_FRAMEINTERVALS, &ival) == 0; ival.index++) {
+        if (ival.type == V4L2_FRMIVAL_TYPE_DISCRETE) {
+            double rate = intervalToFrameRate(ival.discrete);
+            if (info->minFrameRate == 0 || rate < info->minFrameRate) {
+                info->minFrameRate = rate;
+            }
+            if (rate > info->maxFrameRate) {
+                info->maxFrameRate = rate;
+            }
+        } else {
+            info->minFrameRate = intervalToFrameRate(ival.stepwise.max);
+            info->maxFrameRate = intervalToFrameRate(ival.stepwise.min);
+            break;
+        }
+    }
+}
+
+int listFormats(const char *device, char *name, size_t nameSize, FormatInfo **formats, int *count)
+{
+    struct v4l2_capability cap;
+    struct v4l2_fmtdesc desc;
+    struct v4l2_frmsizeenum size;
+    int capacity = 0, ret = 0;
+    uint32_t caps;
+
+    *formats = NULL;
+    *count = 0;
+    int fd = open(device, O_RDWR);
+    if (fd == -1) {
+        return errno;
+    }
+    memset(&cap, 0, sizeof(cap));
+    if (xioctl(fd, VIDIOC_QUERYCAP, &cap) == -1) {
+        ret = errno;
+        goto done;
+    }
+    caps = (cap.capabilities & V4L2_CAP_DEVICE_CAPS) ? cap.device_caps : cap.capabilities;
+    if (!(caps & V4L2_CAP_VIDEO_CAPTURE)) {
+        ret = ENODEV;
+        goto done;
+    }
+    snprintf(name, nameSize, "%s", (const char *)cap.card);
+
+    memset(&desc, 0, sizeof(desc));
+    desc.type = V4L2_BUF_TYPE_VIDEO_CAPTURE;
+    for (desc.index = 0; xioctl(fd, VIDIOC_ENUM_FMT, &desc) == 0; desc.index++) {
+        int found = 0;
+        memset(&size, 0, sizeof(size));
+        size.pixel_format = desc.pixelformat;
+        for (size.index = 0; xioctl(fd, VIDIOC_ENUM_FRAMESIZES, &size) == 0; size.index++) {
+            FormatInfo info = { desc.pixelformat };
+            found = 1;
+            if (size.type == V4L2_FRMSIZE_TYPE_DISCRETE) {
+                info.width = size.discrete.width;
+                info.height = size.discrete.height;
+                getFrameRateRange(fd, &info);
+                if ((ret = appendFormat(formats, count, &capacity, info)) != 0) {
+                    goto done;
+                }
+                continue;
+            }
+            // stepwise or continuous: report the smallest and largest sizes
+            info.width = size.stepwise.min_width;
+            info.height = size.stepwise.min_height;
+            getFrameRateRange(fd, &info);
+            if ((ret = appendFormat(formats, count, &capacity, info)) != 0) {
+                goto done;
+            }
+            info = (FormatInfo){ desc.pixelformat };
+            info.width = size.stepwise.max_width;
+            info.height = size.stepwise.max_height;
+            getFrameRateRange(fd, &info);
+            if ((ret = appendFormat(formats, count, &capacity, info)) != 0) {
+                goto done;
+            }
+            break;
+        }
+        if (!found) {
+            // frame sizes cannot be enumerated, any size may be requested
+            FormatInfo info = { desc.pixelformat };
+            if ((ret = appendFormat(formats, count, &capacity, info)) != 0) {
+                goto done;
+            }
+        }
+    }
+
+done:
+    close(fd);
+    if (ret != 0) {
+        free(*formats);
+        *formats = NULL;
+        *count = 0;
+    }
+    return ret;
+}
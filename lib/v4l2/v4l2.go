@@ -0,0 +1,123 @@
+// +build linux
+
+package v4l2
+
+/*
+#include <stdlib.h>
+#include "v4l2.h"
+
+extern void closeSession(CaptureSession *s);
+extern int initSession(CaptureSession *s, const char *device);
+extern int readVideoFrame(CaptureSession *s, uint8_t *buf, size_t size, int64_t *timestamp, int timeout);
+extern void interruptRead(CaptureSession *s);
+extern size_t getVideoBufferSize(CaptureSession *s);
+*/
+import "C"
+import (
+	"context"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+const DefaultDevice = "/dev/video0"
+
+// Property.DeviceID is the device node path, DefaultDevice if empty.
+type Property = capture.Property
+
+type Session struct {
+	s       C.CaptureSession
+	p       Property
+	bufSize int
+	planes  []video.Plane
+	seq     uint64
+}
+
+func NewSession(p Property) (*Session, error) {
+	var s Session
+	if p.DeviceID == "" {
+		p.DeviceID = DefaultDevice
+	}
+	if p.PixelFormat != "" { // or the driver's current format
+		c, ok := pixelFormatToFourCC(p.PixelFormat)
+		if !ok {
+			return nil, video.ErrUnsupportedPixelFormat
+		}
+		s.s.property.pixelFormat = c
+	}
+	s.s.property.width = C.int(p.Width)
+	s.s.property.height = C.int(p.Height)
+	s.s.property.frameRate = C.double(p.FrameRate)
+	device := C.CString(p.DeviceID)
+	defer C.free(unsafe.Pointer(device))
+	ret := C.initSession(&s.s, device)
+	if ret != 0 {
+		return nil, syscall.Errno(ret)
+	}
+	s.p.DeviceID = p.DeviceID
+	return s.init(), nil
+}
+
+func (s *Session) init() *Session {
+	size := C.getVideoBufferSize(&s.s)
+	s.bufSize = int(size)
+	s.p.Width = int(s.s.property.width)
+	s.p.Height = int(s.s.property.height)
+	s.p.FrameRate = float64(s.s.property.frameRate)
+	s.p.PixelFormat, _ = fourCCToPixelFormat(s.s.property.pixelFormat)
+	s.p.Colorimetry = colorimetry(s.p.PixelFormat, s.s.property.ycbcrEnc, s.s.property.quantization)
+	// drivers may pad rows; chroma planes follow the luma stride
+	s.planes = video.Planes(s.p.PixelFormat, s.p.Width, s.p.Height, int(s.s.property.bytesPerLine))
+	return s
+}
+
+func (s *Session) BufferSize() int    { return s.bufSize }
+func (s *Session) Property() Property { return s.p }
+func (s *Session) Close()             { C.closeSession(&s.s) }
+
+// ReadVideoFrame timestamps frames with the driver's buffer timestamp, usually
+// on the CLOCK_MONOTONIC clock.
+func (s *Session) ReadVideoFrame(ctx context.Context, buf []byte) (info capture.FrameInfo, err error) {
+	if len(buf) == 0 {
+		return info, syscall.ENOSPC
+	}
+	if err = ctx.Err(); err != nil {
+		return info, err
+	}
+	stop := capture.AfterFunc(ctx, func() { C.interruptRead(&s.s) })
+	defer stop()
+	deadline := capture.Deadline(ctx)
+	var timestamp C.int64_t
+	var ret C.int
+	for {
+		timeout := time.Until(deadline) / time.Millisecond
+		if timeout < 0 {
+			timeout = 0
+		}
+		ret = C.readVideoFrame(&s.s, (*C.uchar)(&buf[0]), C.size_t(len(buf)), &timestamp, C.int(timeout))
+		if ret != -C.int(syscall.EINTR) {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			return info, err
+		}
+	}
+	if ret == -C.int(syscall.ETIMEDOUT) {
+		return info, capture.ErrTimeout
+	}
+	if ret < 0 {
+		return info, syscall.Errno(-ret)
+	}
+	info.Size = int(ret)
+	info.Planes = s.planes
+	info.PTS = time.Duration(timestamp)
+	if s.p.FrameRate > 0 {
+		info.Duration = time.Duration(float64(time.Second) / s.p.FrameRate)
+	}
+	info.Sequence = s.seq
+	s.seq++
+	return info, nil
+}
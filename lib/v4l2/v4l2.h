@@ -0,0 +1,41 @@
+#pragma once
+
+#include <stdint.h>
+#include <stddef.h>
+#include <linux/videodev2.h>
+
+#define MAX_BUFFER_COUNT 4
+
+typedef struct
+{
+    uint32_t pixelFormat;
+    int width, height;
+    int bytesPerLine;
+    uint32_t ycbcrEnc;     // enum v4l2_ycbcr_encoding, defaults resolved
+    uint32_t quantization; // enum v4l2_quantization, defaults resolved
+    double frameRate;
+} VideoProperty;
+
+typedef struct
+{
+    void   *start;
+    size_t length;
+} MappedBuffer;
+
+typedef struct
+{
+    int           fd;
+    int           wakefd; // eventfd to interrupt a blocking read
+    VideoProperty property;
+    size_t        bufferSize;
+    MappedBuffer  buffers[MAX_BUFFER_COUNT];
+    unsigned int  bufferCount;
+    int           streaming;
+} CaptureSession;
+
+typedef struct
+{
+    uint32_t pixelFormat;
+    int width, height;
+    double minFrameRate, maxFrameRate;
+} FormatInfo;
@@ -0,0 +1,91 @@
+// +build linux,cgo
+
+package v4l2
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+// testDevice returns the device node to test against, e.g. a `vivid' or
+// `v4l2loopback' device selected with V4L2_DEVICE.
+func testDevice(t *testing.T) string {
+	device := os.Getenv("V4L2_DEVICE")
+	if device == "" {
+		device = DefaultDevice
+	}
+	if _, err := os.Stat(device); err != nil {
+		t.Skip(err)
+	}
+	return device
+}
+
+func TestSession(t *testing.T) {
+	s, err := NewSession(Property{PixelFormat: format.YUY2, FrameRate: 30, DeviceID: testDevice(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p := s.Property()
+	t.Logf("%#v\n", s.Property())
+	buf := make([]byte, s.BufferSize())
+	for i := 0; i < 10; i++ {
+		info, err := s.ReadVideoFrame(context.Background(), buf)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if info.Size != s.BufferSize() {
+			t.Error("size mismatch")
+		} else if info.Sequence != uint64(i) {
+			t.Errorf("unexpected sequence %d", info.Sequence)
+		}
+		if _, err = video.Decode(info.Raw(p, buf)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSessionPixelFormat(t *testing.T) {
+	if _, err := NewSession(Property{PixelFormat: format.I444}); err != video.ErrUnsupportedPixelFormat {
+		t.Errorf("expected unsupported pixel format, got %v", err)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	device := testDevice(t)
+	devices, err := Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices {
+		if d.ID == device {
+			t.Logf("%+v", d)
+			if len(d.Formats) == 0 {
+				t.Error("no formats reported")
+			}
+			return
+		}
+	}
+	t.Errorf("%s not enumerated: %+v", device, devices)
+}
+
+func TestPixelFormats(t *testing.T) {
+	for _, f := range []format.PixelFormat{
+		format.I420, format.I422, format.NV12, format.NV21, format.YUY2, format.UYVY,
+		format.YV12, format.RAW, format.ARGB, format.BGRA, format.RGBA, format.MJPG,
+	} {
+		c, ok := pixelFormatToFourCC(f)
+		if !ok {
+			t.Errorf("%s: no V4L2 code", f)
+			continue
+		}
+		if pf, ok := fourCCToPixelFormat(c); !ok || pf != f {
+			t.Errorf("%s: round trip through %q gave %q", f, format.FourCC(uint32(c)), pf)
+		}
+	}
+}
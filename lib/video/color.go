@@ -0,0 +1,188 @@
+package video
+
+import (
+	"fmt"
+	"math"
+)
+
+// ColorSpace is the matrix relating Y'CbCr samples to R'G'B'.
+type ColorSpace int
+
+const (
+	BT601 ColorSpace = iota
+	BT709
+	BT2020
+)
+
+func (s ColorSpace) String() string {
+	switch s {
+	case BT601:
+		return "BT.601"
+	case BT709:
+		return "BT.709"
+	case BT2020:
+		return "BT.2020"
+	}
+	return fmt.Sprintf("ColorSpace(%d)", int(s))
+}
+
+// ColorRange is the quantization range of Y'CbCr samples.
+type ColorRange int
+
+const (
+	LimitedRange ColorRange = iota // Y in [16, 235], Cb and Cr in [16, 240]
+	FullRange                      // all in [0, 255]
+)
+
+func (r ColorRange) String() string {
+	switch r {
+	case LimitedRange:
+		return "limited"
+	case FullRange:
+		return "full"
+	}
+	return fmt.Sprintf("ColorRange(%d)", int(r))
+}
+
+// Colorimetry tells how the Y'CbCr samples of a frame map to colours. The
+// zero value is limited-range BT.601, the usual default for video.
+type Colorimetry struct {
+	Space ColorSpace
+	Range ColorRange
+}
+
+// JPEG is the colorimetry of JFIF images, as read and written by
+// image/jpeg and assumed by image.YCbCr.At.
+var JPEG = Colorimetry{BT601, FullRange}
+
+func (c Colorimetry) String() string { return c.Space.String() + "/" + c.Range.String() }
+
+// matrix holds the conversion coefficients of a colorimetry in 16.16 fixed
+// point.
+type matrix struct {
+	// R'G'B' to Y'CbCr
+	yr, yg, yb, yOffset int32
+	cbr, cbg, cbb       int32
+	crr, crg, crb       int32
+	// Y'CbCr to R'G'B'
+	ry, rcr, gcb, gcr, bcb int32
+	yMin                   int32
+}
+
+// luma coefficients of red and blue, by ColorSpace
+var (
+	kr = [...]float64{0.299, 0.2126, 0.2627}
+	kb = [...]float64{0.114, 0.0722, 0.0593}
+)
+
+var matrices = func() (m [3][2]matrix) {
+	fix := func(f float64) int32 {
+		if f < 0 {
+			return int32(f*65536 - 0.5)
+		}
+		return int32(f*65536 + 0.5)
+	}
+	for s := range m {
+		kg := 1 - kr[s] - kb[s]
+		for r := range m[s] {
+			ys, cs, y0 := 219.0/255, 224.0/255, int32(16)
+			if ColorRange(r) == FullRange {
+				ys, cs, y0 = 1, 1, 0
+			}
+			m[s][r] = matrix{
+				yr: fix(kr[s] * ys), yg: fix(kg * ys), yb: fix(kb[s] * ys), yOffset: y0,
+				cbr: fix(-kr[s] / (2 * (1 - kb[s])) * cs), cbg: fix(-kg / (2 * (1 - kb[s])) * cs), cbb: fix(0.5 * cs),
+				crr: fix(0.5 * cs), crg: fix(-kg / (2 * (1 - kr[s])) * cs), crb: fix(-kb[s] / (2 * (1 - kr[s])) * cs),
+				ry:   fix(1 / ys),
+				rcr:  fix(2 * (1 - kr[s]) / cs),
+				gcb:  fix(2 * kb[s] * (1 - kb[s]) / kg / cs),
+				gcr:  fix(2 * kr[s] * (1 - kr[s]) / kg / cs),
+				bcb:  fix(2 * (1 - kb[s]) / cs),
+				yMin: y0,
+			}
+		}
+	}
+	return
+}()
+
+// valid replaces unknown spaces and ranges in c by the defaults.
+func (c Colorimetry) valid() Colorimetry {
+	if c.Space < BT601 || c.Space > BT2020 {
+		c.Space = BT601
+	}
+	if c.Range != FullRange {
+		c.Range = LimitedRange
+	}
+	return c
+}
+
+func (c Colorimetry) matrix() *matrix {
+	c = c.valid()
+	return &matrices[c.Space][c.Range]
+}
+
+// scale returns the luma scale, luma offset and chroma scale of c.
+func (c Colorimetry) scale() (ys, y0, cs float64) {
+	if c.Range == FullRange {
+		return 255, 0, 255
+	}
+	return 219, 16, 224
+}
+
+// remap converts a Y'CbCr triple from colorimetry c to colorimetry to,
+// without clamping in between.
+func (c Colorimetry) remap(to Colorimetry, y, cb, cr uint8) (uint8, uint8, uint8) {
+	c, to = c.valid(), to.valid()
+	ys, y0, cs := c.scale()
+	ey, pb, pr := (float64(y)-y0)/ys, (float64(cb)-128)/cs, (float64(cr)-128)/cs
+	r1, b1 := kr[c.Space], kb[c.Space]
+	// colour differences R'-Y', G'-Y' and B'-Y'
+	dr := 2 * (1 - r1) * pr
+	db := 2 * (1 - b1) * pb
+	dg := -(2*b1*(1-b1)*pb + 2*r1*(1-r1)*pr) / (1 - r1 - b1)
+	r2, b2 := kr[to.Space], kb[to.Space]
+	dy := r2*dr + (1-r2-b2)*dg + b2*db
+	ys, y0, cs = to.scale()
+	round := func(v float64) uint8 { return clamp8(int32(math.Floor(v + 0.5))) }
+	return round((ey+dy)*ys + y0), round((db-dy)/(2*(1-b2))*cs + 128), round((dr-dy)/(2*(1-r2))*cs + 128)
+}
+
+func clamp8(v int32) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xff {
+		return 0xff
+	}
+	return uint8(v)
+}
+
+// RGBToYCbCr converts an R'G'B' triple to Y'CbCr in c.
+func (c Colorimetry) RGBToYCbCr(r, g, b uint8) (y, cb, cr uint8) {
+	m := c.matrix()
+	r1, g1, b1 := int32(r), int32(g), int32(b)
+	return m.luma(r1, g1, b1), m.cb(r1, g1, b1), m.cr(r1, g1, b1)
+}
+
+func (m *matrix) luma(r, g, b int32) uint8 {
+	return clamp8((m.yr*r+m.yg*g+m.yb*b+1<<15)>>16 + m.yOffset)
+}
+
+func (m *matrix) cb(r, g, b int32) uint8 {
+	return clamp8((m.cbr*r + m.cbg*g + m.cbb*b + 128<<16 + 1<<15) >> 16)
+}
+
+func (m *matrix) cr(r, g, b int32) uint8 {
+	return clamp8((m.crr*r + m.crg*g + m.crb*b + 128<<16 + 1<<15) >> 16)
+}
+
+// YCbCrToRGB converts a Y'CbCr triple in c to R'G'B'.
+func (c Colorimetry) YCbCrToRGB(y, cb, cr uint8) (r, g, b uint8) {
+	m := c.matrix()
+	y1 := (int32(y) - m.yMin) * m.ry
+	cb1, cr1 := int32(cb)-128, int32(cr)-128
+	r = clamp8((y1 + m.rcr*cr1 + 1<<15) >> 16)
+	g = clamp8((y1 - m.gcb*cb1 - m.gcr*cr1 + 1<<15) >> 16)
+	b = clamp8((y1 + m.bcb*cb1 + 1<<15) >> 16)
+	return
+}
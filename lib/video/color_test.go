@@ -0,0 +1,107 @@
+package video
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func TestColorimetry(t *testing.T) {
+	testCases := []struct {
+		c         Colorimetry
+		r, g, b   uint8
+		y, cb, cr uint8
+	}{
+		{Colorimetry{BT601, LimitedRange}, 0, 0, 0, 16, 128, 128},
+		{Colorimetry{BT601, LimitedRange}, 255, 255, 255, 235, 128, 128},
+		{Colorimetry{BT601, LimitedRange}, 255, 0, 0, 81, 90, 240},
+		{Colorimetry{BT709, LimitedRange}, 255, 0, 0, 63, 102, 240},
+		{Colorimetry{BT709, LimitedRange}, 0, 0, 255, 32, 240, 118},
+		{Colorimetry{BT2020, LimitedRange}, 0, 255, 0, 164, 47, 25},
+		{Colorimetry{BT709, FullRange}, 255, 255, 255, 255, 128, 128},
+		{Colorimetry{BT709, FullRange}, 0, 255, 0, 182, 30, 12},
+		{JPEG, 255, 0, 0, 76, 85, 255},
+	}
+	for _, c := range testCases {
+		y, cb, cr := c.c.RGBToYCbCr(c.r, c.g, c.b)
+		if y != c.y || cb != c.cb || cr != c.cr {
+			t.Errorf("%v: RGB(%d,%d,%d) -> (%d,%d,%d), expected (%d,%d,%d)", c.c, c.r, c.g, c.b, y, cb, cr, c.y, c.cb, c.cr)
+		}
+		r, g, b := c.c.YCbCrToRGB(c.y, c.cb, c.cr)
+		if abs(int(r)-int(c.r)) > 2 || abs(int(g)-int(c.g)) > 2 || abs(int(b)-int(c.b)) > 2 {
+			t.Errorf("%v: (%d,%d,%d) -> RGB(%d,%d,%d), expected (%d,%d,%d)", c.c, c.y, c.cb, c.cr, r, g, b, c.r, c.g, c.b)
+		}
+	}
+}
+
+func TestColorimetryJPEG(t *testing.T) {
+	for i := 0; i < 1<<24; i += 997 {
+		r, g, b := uint8(i>>16), uint8(i>>8), uint8(i)
+		y0, cb0, cr0 := color.RGBToYCbCr(r, g, b)
+		y1, cb1, cr1 := JPEG.RGBToYCbCr(r, g, b)
+		if abs(int(y0)-int(y1)) > 1 || abs(int(cb0)-int(cb1)) > 1 || abs(int(cr0)-int(cr1)) > 1 {
+			t.Fatalf("RGB(%d,%d,%d): image/color (%d,%d,%d), got (%d,%d,%d)", r, g, b, y0, cb0, cr0, y1, cb1, cr1)
+		}
+		r0, g0, b0 := color.YCbCrToRGB(y0, cb0, cr0)
+		r1, g1, b1 := JPEG.YCbCrToRGB(y0, cb0, cr0)
+		if abs(int(r0)-int(r1)) > 1 || abs(int(g0)-int(g1)) > 1 || abs(int(b0)-int(b1)) > 1 {
+			t.Fatalf("(%d,%d,%d): image/color RGB(%d,%d,%d), got (%d,%d,%d)", y0, cb0, cr0, r0, g0, b0, r1, g1, b1)
+		}
+	}
+}
+
+func TestRecolor(t *testing.T) {
+	from, to := Colorimetry{BT709, LimitedRange}, JPEG
+	img := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	colors := [][3]uint8{{255, 0, 0}, {0, 255, 0}}
+	for i, c := range colors {
+		y, cb, cr := from.RGBToYCbCr(c[0], c[1], c[2])
+		for row := 0; row < 2; row++ {
+			for col := 2 * i; col < 2*i+2; col++ {
+				img.Y[img.YOffset(col, row)] = y
+			}
+		}
+		img.Cb[i], img.Cr[i] = cb, cr
+	}
+	if Recolor(img, from, from) != img {
+		t.Error("expected identity for equal colorimetries")
+	}
+	dst := Recolor(img, from, to)
+	for i, c := range colors {
+		y, cb, cr := to.RGBToYCbCr(c[0], c[1], c[2])
+		if g := dst.YCbCrAt(2*i, 1); abs(int(g.Y)-int(y)) > 1 || abs(int(g.Cb)-int(cb)) > 1 || abs(int(g.Cr)-int(cr)) > 1 {
+			t.Errorf("RGB%v: expected (%d,%d,%d), got %v", c, y, cb, cr, g)
+		}
+	}
+}
+
+func TestRecolorSubImage(t *testing.T) {
+	from, to := Colorimetry{BT709, LimitedRange}, JPEG
+	for _, ratio := range []image.YCbCrSubsampleRatio{image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio422} {
+		img := image.NewYCbCr(image.Rect(0, 0, 8, 8), ratio)
+		for i := range img.Y {
+			img.Y[i] = uint8(64 + i)
+		}
+		for i := range img.Cb {
+			img.Cb[i], img.Cr[i] = uint8(60+3*i), uint8(200-3*i)
+		}
+		whole := Recolor(img, from, to)
+		// an odd origin cuts the chroma blocks of the first and last pixels
+		sub := img.SubImage(image.Rect(1, 1, 7, 7)).(*image.YCbCr)
+		dst := Recolor(sub, from, to)
+		for y := sub.Rect.Min.Y; y < sub.Rect.Max.Y; y++ {
+			for x := sub.Rect.Min.X; x < sub.Rect.Max.X; x++ {
+				if e, g := whole.YCbCrAt(x, y), dst.YCbCrAt(x, y); e != g {
+					t.Fatalf("%v (%d,%d): expected %v, got %v", ratio, x, y, e, g)
+				}
+			}
+		}
+	}
+}
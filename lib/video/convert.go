@@ -3,78 +3,351 @@ package video
 import (
 	"errors"
 	"image"
-	"image/color"
 )
 
-var ErrUnsupportedPixelFormat = errors.New("unsupported pixel format")
+var (
+	ErrUnsupportedPixelFormat = errors.New("unsupported pixel format")
+	ErrDestinationMismatch    = errors.New("destination does not match source")
+)
+
+// Convert brings src to 4:2:0 Y'CbCr. RGB and grey images are converted with
+// the matrix and range of c, composited onto black where they are not
+// opaque; Y'CbCr images are assumed to be in c already. Images of types not
+// known to Convert are read through their colour model, frames as their
+// image. src is never modified: a 4:2:0 src is returned as is, anything else
+// is converted into a new image, see ConvertTo.
+func Convert(src image.Image, c Colorimetry) (*image.YCbCr, error) {
+	src = ImageOf(src)
+	if img, ok := src.(*image.YCbCr); ok && img.SubsampleRatio == image.YCbCrSubsampleRatio420 {
+		return img, nil
+	}
+	dst := image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio420)
+	if err := ConvertTo(dst, src, c); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
 
-func Convert(src image.Image) (*image.YCbCr, error) {
+// ConvertTo is like Convert but writes into dst, a 4:2:0 image of the same
+// size as src. It does not allocate, unless it converts Y'CbCr or RGBA
+// images in parallel, see SetParallelism.
+func ConvertTo(dst *image.YCbCr, src image.Image, c Colorimetry) error {
+	return convertTo(dst, src, c, Parallelism())
+}
+
+// convertTo is ConvertTo with the parallelism setting n.
+func convertTo(dst *image.YCbCr, src image.Image, c Colorimetry, n int) error {
+	if dst.SubsampleRatio != image.YCbCrSubsampleRatio420 || dst.Rect.Size() != src.Bounds().Size() {
+		return ErrDestinationMismatch
+	}
+	src = ImageOf(src)
+	// stripes of rows at an even origin do not share chroma samples
+	h, even := dst.Rect.Dy(), dst.Rect.Min.X%2 == 0 && dst.Rect.Min.Y%2 == 0
+	n = stripeCount(n, h)
 	switch img := src.(type) {
+	case *image.YCbCr:
+		switch {
+		case !even:
+			copyLuma(dst, img, 0, h)
+			chromaBlocks(dst, func(ci, x, y, bw, bh int) {
+				dst.Cb[ci], dst.Cr[ci] = chroma(img, x, y, bw, bh)
+			})
+		case n > 1:
+			stripes(n, h, func(y0, y1 int) { convertYCbCr(dst, img, y0, y1) })
+		default:
+			convertYCbCr(dst, img, 0, h)
+		}
 	case *image.RGBA:
-		bounds := img.Bounds()
-		dst := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
-		for row := 0; row < bounds.Max.Y; row++ {
-			for col := 0; col < bounds.Max.X; col++ {
-				r, g, b, _ := img.At(col, row).RGBA()
-				dst.Y[dst.YOffset(col, row)],
-					dst.Cb[dst.COffset(col, row)],
-					dst.Cr[dst.COffset(col, row)] = color.RGBToYCbCr(uint8(r), uint8(g), uint8(b))
+		m := c.matrix()
+		switch {
+		case !even:
+			x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+			convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+				p := img.Pix[img.PixOffset(x0+x, y0+y):]
+				return p[0], p[1], p[2]
+			})
+		case n > 1:
+			stripes(n, h, func(y0, y1 int) { convertRGBA(dst, img, m, y0, y1) })
+		default:
+			convertRGBA(dst, img, m, 0, h)
+		}
+	case *image.NRGBA:
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+			p := img.Pix[img.PixOffset(x0+x, y0+y):]
+			if a := uint32(p[3]); a != 0xff {
+				return uint8(uint32(p[0]) * a / 0xff), uint8(uint32(p[1]) * a / 0xff), uint8(uint32(p[2]) * a / 0xff)
 			}
+			return p[0], p[1], p[2]
+		})
+	case *image.RGBA64:
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+			p := img.Pix[img.PixOffset(x0+x, y0+y):]
+			return p[0], p[2], p[4]
+		})
+	case *image.NRGBA64:
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+			p := img.Pix[img.PixOffset(x0+x, y0+y):]
+			a := uint32(p[6])<<8 | uint32(p[7])
+			r1, g1, b1 := uint32(p[0])<<8|uint32(p[1]), uint32(p[2])<<8|uint32(p[3]), uint32(p[4])<<8|uint32(p[5])
+			return uint8(r1 * a / 0xffff >> 8), uint8(g1 * a / 0xffff >> 8), uint8(b1 * a / 0xffff >> 8)
+		})
+	case *image.Paletted:
+		var palette [256][3]uint8
+		for i, col := range img.Palette {
+			if i == len(palette) {
+				break
+			}
+			r, g, b, _ := col.RGBA()
+			palette[i] = [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
 		}
-		return dst, nil
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+			p := &palette[img.Pix[img.PixOffset(x0+x, y0+y)]]
+			return p[0], p[1], p[2]
+		})
+	case *image.Gray:
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertGray(dst, c, func(x, y int) uint8 { return img.Pix[img.PixOffset(x0+x, y0+y)] })
+	case *image.Gray16:
+		x0, y0 := img.Rect.Min.X, img.Rect.Min.Y
+		convertGray(dst, c, func(x, y int) uint8 { return img.Pix[img.PixOffset(x0+x, y0+y)] })
+	default:
+		// anything else through the colour model, which is slow
+		x0, y0 := img.Bounds().Min.X, img.Bounds().Min.Y
+		convertRGB(dst, c, func(x, y int) (r, g, b uint8) {
+			r1, g1, b1, _ := img.At(x0+x, y0+y).RGBA()
+			return uint8(r1 >> 8), uint8(g1 >> 8), uint8(b1 >> 8)
+		})
+	}
+	return nil
+}
 
-	case *image.YCbCr:
-		switch img.SubsampleRatio {
-		case image.YCbCrSubsampleRatio444:
-			h := img.Rect.Dy()
-			addrSrc0 := 0
-			addrSrc1 := img.CStride
-			addrDst := 0
-			for i := 0; i < h/2; i++ {
-				for j := 0; j < img.CStride/2; j++ {
-					cb := uint16(img.Cb[addrSrc0]) + uint16(img.Cb[addrSrc1]) +
-						uint16(img.Cb[addrSrc0+1]) + uint16(img.Cb[addrSrc1+1])
-					cr := uint16(img.Cr[addrSrc0]) + uint16(img.Cr[addrSrc1]) +
-						uint16(img.Cr[addrSrc0+1]) + uint16(img.Cr[addrSrc1+1])
-					img.Cb[addrDst] = uint8(cb / 4)
-					img.Cr[addrDst] = uint8(cr / 4)
-					addrSrc0 += 2
-					addrSrc1 += 2
-					addrDst++
-				}
-				addrSrc0 += img.CStride
-				addrSrc1 += img.CStride
+// convertRGB converts the R'G'B' pixels returned by at, relative to the
+// origin, into dst with the matrix and range of c. Chroma is computed from
+// the average colour of each block.
+func convertRGB(dst *image.YCbCr, c Colorimetry, at func(x, y int) (r, g, b uint8)) {
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	for y := 0; y < h; y++ {
+		dy := dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):][:w]
+		for x := range dy {
+			dy[x], _, _ = c.RGBToYCbCr(at(x, y))
+		}
+	}
+	chromaBlocks(dst, func(ci, x, y, bw, bh int) {
+		var r, g, b int
+		for yy := y; yy < y+bh; yy++ {
+			for xx := x; xx < x+bw; xx++ {
+				r1, g1, b1 := at(xx, yy)
+				r, g, b = r+int(r1), g+int(g1), b+int(b1)
 			}
-			img.CStride = img.CStride / 2
-			cLen := img.CStride * (h / 2)
-			img.Cb = img.Cb[:cLen]
-			img.Cr = img.Cr[:cLen]
-			img.SubsampleRatio = image.YCbCrSubsampleRatio420
-			return img, nil
-		case image.YCbCrSubsampleRatio422:
-			h := img.Rect.Dy()
-			addrSrc := 0
-			addrDst := 0
-			for i := 0; i < h/2; i++ {
-				for j := 0; j < img.CStride; j++ {
-					cb := uint16(img.Cb[addrSrc]) + uint16(img.Cb[addrSrc+img.CStride])
-					cr := uint16(img.Cr[addrSrc]) + uint16(img.Cr[addrSrc+img.CStride])
-					img.Cb[addrDst] = uint8(cb / 2)
-					img.Cr[addrDst] = uint8(cr / 2)
-					addrDst++
-					addrSrc++
-				}
-				addrSrc += img.CStride
+		}
+		n := bw * bh
+		_, dst.Cb[ci], dst.Cr[ci] = c.RGBToYCbCr(uint8((r+n/2)/n), uint8((g+n/2)/n), uint8((b+n/2)/n))
+	})
+}
+
+// copyLuma copies rows [y0, y1) of the luma of src to dst, relative to their
+// origins.
+func copyLuma(dst, src *image.YCbCr, y0, y1 int) {
+	w := dst.Rect.Dx()
+	for y := y0; y < y1; y++ {
+		copy(dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):][:w], yRow(src, y))
+	}
+}
+
+// convertYCbCr converts rows [y0, y1) of img into dst, whose origin and y0
+// are even.
+func convertYCbCr(dst, img *image.YCbCr, y0, y1 int) {
+	copyLuma(dst, img, y0, y1)
+	ci := dst.COffset(dst.Rect.Min.X, dst.Rect.Min.Y)
+	subsample(dst.Cb[ci:], dst.Cr[ci:], dst.CStride, img, 2, 2, y0/2, (y1+1)/2)
+}
+
+// convertRGBA is convertRGB for rows [y0, y1) of RGBA pixels, two rows at a
+// time; the origin of dst and y0 are even.
+func convertRGBA(dst *image.YCbCr, img *image.RGBA, m *matrix, y0, y1 int) {
+	w := dst.Rect.Dx()
+	for y := y0; y < y1; y += 2 {
+		p0 := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):][:4*w]
+		l0 := dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):][:w]
+		p1, l1 := p0, l0
+		if y+1 < y1 {
+			p1 = img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y+1):][:4*w]
+			l1 = dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y+1):][:w]
+		}
+		ci := dst.COffset(dst.Rect.Min.X, dst.Rect.Min.Y+y)
+		cb, cr := dst.Cb[ci:ci+(w+1)/2], dst.Cr[ci:ci+(w+1)/2]
+		for x := 0; x < w; x += 2 {
+			i, j := 4*x, 4*x+4
+			if x+1 == w {
+				j = i
+			}
+			r00, g00, b00 := int32(p0[i]), int32(p0[i+1]), int32(p0[i+2])
+			r01, g01, b01 := int32(p0[j]), int32(p0[j+1]), int32(p0[j+2])
+			r10, g10, b10 := int32(p1[i]), int32(p1[i+1]), int32(p1[i+2])
+			r11, g11, b11 := int32(p1[j]), int32(p1[j+1]), int32(p1[j+2])
+			l0[x] = m.luma(r00, g00, b00)
+			l1[x] = m.luma(r10, g10, b10)
+			if x+1 < w {
+				l0[x+1] = m.luma(r01, g01, b01)
+				l1[x+1] = m.luma(r11, g11, b11)
 			}
-			cLen := img.CStride * (h / 2)
-			img.Cb = img.Cb[:cLen]
-			img.Cr = img.Cr[:cLen]
-			img.SubsampleRatio = image.YCbCrSubsampleRatio420
-			return img, nil
-		case image.YCbCrSubsampleRatio420:
-			return img, nil
+			r, g, b := (r00+r01+r10+r11+2)>>2, (g00+g01+g10+g11+2)>>2, (b00+b01+b10+b11+2)>>2
+			cb[x/2], cr[x/2] = m.cb(r, g, b), m.cr(r, g, b)
+		}
+	}
+}
+
+// convertGray converts the grey levels returned by at, relative to the
+// origin, into luma in the range of c and neutral chroma.
+func convertGray(dst *image.YCbCr, c Colorimetry, at func(x, y int) uint8) {
+	var luma [256]uint8
+	for i := range luma {
+		luma[i], _, _ = c.RGBToYCbCr(uint8(i), uint8(i), uint8(i))
+	}
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	for y := 0; y < h; y++ {
+		dy := dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y+y):][:w]
+		for x := range dy {
+			dy[x] = luma[at(x, y)]
 		}
 	}
+	chromaBlocks(dst, func(ci, _, _, _, _ int) {
+		dst.Cb[ci], dst.Cr[ci] = 128, 128
+	})
+}
 
-	return nil, ErrUnsupportedPixelFormat
+// chromaBlocks calls fn for each chroma sample of the 4:2:0 image img with
+// its index and the block of pixels it covers, relative to the origin.
+func chromaBlocks(img *image.YCbCr, fn func(ci, x, y, bw, bh int)) {
+	r := img.Rect
+	for y0 := r.Min.Y; y0 < r.Max.Y; y0 = (y0 + 2) &^ 1 {
+		y1 := (y0 + 2) &^ 1
+		if y1 > r.Max.Y {
+			y1 = r.Max.Y
+		}
+		ci := img.COffset(r.Min.X, y0)
+		for x0 := r.Min.X; x0 < r.Max.X; x0 = (x0 + 2) &^ 1 {
+			x1 := (x0 + 2) &^ 1
+			if x1 > r.Max.X {
+				x1 = r.Max.X
+			}
+			fn(ci, x0-r.Min.X, y0-r.Min.Y, x1-x0, y1-y0)
+			ci++
+		}
+	}
+}
+
+// subsample writes the chroma of img, averaged over blocks of bw×bh pixels,
+// to cb and cr with rows stride bytes apart; chroma rows [cy0, cy1) only.
+func subsample(cb, cr []byte, stride int, img *image.YCbCr, bw, bh, cy0, cy1 int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cw := (w + bw - 1) / bw
+	sw, sh := ratio(img.SubsampleRatio)
+	aligned := img.Rect.Min.X%sw == 0 && img.Rect.Min.Y%sh == 0
+	switch {
+	case sw == bw && sh == bh && aligned:
+		for y := cy0; y < cy1; y++ {
+			ci := img.COffset(img.Rect.Min.X, img.Rect.Min.Y+y*bh)
+			copy(cb[y*stride:y*stride+cw], img.Cb[ci:])
+			copy(cr[y*stride:y*stride+cw], img.Cr[ci:])
+		}
+	case sw == 1 && sh == 1 && bw == 2 && bh == 2, sw == 2 && sh == 1 && bw == 2 && bh == 2 && aligned:
+		// 4:4:4 or 4:2:2 to 4:2:0: average the samples of each block
+		for y := cy0; y < cy1; y++ {
+			s0 := img.COffset(img.Rect.Min.X, img.Rect.Min.Y+2*y)
+			s1 := s0
+			if 2*y+1 < h {
+				s1 += img.CStride
+			}
+			dcb, dcr := cb[y*stride:y*stride+cw], cr[y*stride:y*stride+cw]
+			if sw == 2 {
+				kern.average2(dcb, img.Cb[s0:s0+cw], img.Cb[s1:s1+cw])
+				kern.average2(dcr, img.Cr[s0:s0+cw], img.Cr[s1:s1+cw])
+				continue
+			}
+			kern.average2x2(dcb[:w/2], img.Cb[s0:s0+w], img.Cb[s1:s1+w])
+			kern.average2x2(dcr[:w/2], img.Cr[s0:s0+w], img.Cr[s1:s1+w])
+			if w%2 != 0 {
+				// the last column pairs with itself
+				dcb[cw-1] = uint8((int(img.Cb[s0+w-1]) + int(img.Cb[s1+w-1]) + 1) >> 1)
+				dcr[cw-1] = uint8((int(img.Cr[s0+w-1]) + int(img.Cr[s1+w-1]) + 1) >> 1)
+			}
+		}
+	default:
+		for y := cy0; y < cy1; y++ {
+			for x := 0; x < cw; x++ {
+				cb[y*stride+x], cr[y*stride+x] = chroma(img, x*bw, y*bh, bw, bh)
+			}
+		}
+	}
+}
+
+// Recolor returns a copy of img with its samples converted from colorimetry
+// from to colorimetry to, or img itself if the two are the same.
+func Recolor(img *image.YCbCr, from, to Colorimetry) *image.YCbCr {
+	if from == to {
+		return img
+	}
+	dst := image.NewYCbCr(img.Rect, img.SubsampleRatio)
+	RecolorTo(dst, img, from, to)
+	return dst
+}
+
+// RecolorTo is like Recolor but writes into dst, an image with the bounds
+// and subsample ratio of src. It does not allocate, unless it runs in
+// parallel, see SetParallelism.
+func RecolorTo(dst, src *image.YCbCr, from, to Colorimetry) error {
+	return recolorTo(dst, src, from, to, Parallelism())
+}
+
+// recolorTo is RecolorTo with the parallelism setting n.
+func recolorTo(dst, src *image.YCbCr, from, to Colorimetry, n int) error {
+	if dst.SubsampleRatio != src.SubsampleRatio || dst.Rect != src.Rect {
+		return ErrDestinationMismatch
+	}
+	h := src.Rect.Dy()
+	if _, bh := ratio(src.SubsampleRatio); bh > 1 && src.Rect.Min.Y%2 != 0 {
+		n = 1
+	}
+	if n = stripeCount(n, h); n > 1 {
+		stripes(n, h, func(y0, y1 int) { recolor(dst, src, from, to, y0, y1) })
+	} else {
+		recolor(dst, src, from, to, 0, h)
+	}
+	return nil
+}
+
+// recolor recolors rows [y0, y1) of src, relative to its origin, into dst;
+// y0 starts a row of chroma samples.
+func recolor(dst, src *image.YCbCr, from, to Colorimetry, y0, y1 int) {
+	r := src.Rect
+	for row := r.Min.Y + y0; row < r.Min.Y+y1; row++ {
+		for col := r.Min.X; col < r.Max.X; col++ {
+			ci := src.COffset(col, row)
+			dst.Y[dst.YOffset(col, row)], _, _ = from.remap(to, src.Y[src.YOffset(col, row)], src.Cb[ci], src.Cr[ci])
+		}
+	}
+	// chroma does not depend on luma, map each sample once: the samples of
+	// the first and last pixels, and those in between, even where an odd
+	// origin cuts a chroma block
+	bw, bh := ratio(src.SubsampleRatio)
+	top, bottom := r.Min.Y+y0, r.Min.Y+y1
+	for cy := top / bh; cy <= (bottom-1)/bh; cy++ {
+		row := cy * bh
+		if row < top {
+			row = top
+		}
+		for cx := r.Min.X / bw; cx <= (r.Max.X-1)/bw; cx++ {
+			col := cx * bw
+			if col < r.Min.X {
+				col = r.Min.X
+			}
+			ci, di := src.COffset(col, row), dst.COffset(col, row)
+			_, dst.Cb[di], dst.Cr[di] = from.remap(to, 128, src.Cb[ci], src.Cr[ci])
+		}
+	}
 }
@@ -0,0 +1,233 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+func cloneYCbCr(img *image.YCbCr) *image.YCbCr {
+	c := *img
+	c.Y = append([]byte(nil), img.Y...)
+	c.Cb = append([]byte(nil), img.Cb...)
+	c.Cr = append([]byte(nil), img.Cr...)
+	return &c
+}
+
+func TestConvertKeepsSource(t *testing.T) {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio440,
+	} {
+		src := image.NewYCbCr(image.Rect(0, 0, 5, 3), ratio)
+		for i := range src.Y {
+			src.Y[i] = uint8(i)
+		}
+		for i := range src.Cb {
+			src.Cb[i], src.Cr[i] = uint8(10*i), uint8(255-10*i)
+		}
+		orig := cloneYCbCr(src)
+		dst, err := Convert(src, Colorimetry{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, orig) {
+			t.Errorf("%v: source modified", ratio)
+		}
+		if dst.SubsampleRatio != image.YCbCrSubsampleRatio420 || dst.Rect != src.Rect {
+			t.Errorf("%v: unexpected result %v %v", ratio, dst.SubsampleRatio, dst.Rect)
+		}
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				if dst.YCbCrAt(x, y).Y != src.YCbCrAt(x, y).Y {
+					t.Fatalf("%v: luma mismatch at (%d, %d)", ratio, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertTo(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	expected, err := Convert(src, Colorimetry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a sub-image with an odd origin converts like the same pixels at (0, 0)
+	sub := src.SubImage(image.Rect(1, 1, 6, 4)).(*image.RGBA)
+	flat := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	for y := 0; y < 3; y++ {
+		copy(flat.Pix[y*flat.Stride:], sub.Pix[y*sub.Stride:y*sub.Stride+4*5])
+	}
+	want, _ := Convert(flat, Colorimetry{})
+	dst := image.NewYCbCr(image.Rect(0, 0, 5, 3), image.YCbCrSubsampleRatio420)
+	if err = ConvertTo(dst, sub, Colorimetry{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("expected\n%+v\ngot\n%+v", want, dst)
+	}
+	if err = ConvertTo(dst, src, Colorimetry{}); err != ErrDestinationMismatch {
+		t.Errorf("expected ErrDestinationMismatch, got %v", err)
+	}
+	dst = image.NewYCbCr(expected.Rect, image.YCbCrSubsampleRatio420)
+	if err = ConvertTo(dst, src, Colorimetry{}); err != nil || !reflect.DeepEqual(dst, expected) {
+		t.Errorf("ConvertTo differs from Convert: %v", err)
+	}
+}
+
+// nonStandard is an image type Convert has no fast path for.
+type nonStandard struct{ *image.RGBA }
+
+func TestConvertImageTypes(t *testing.T) {
+	// opaque colours of the web palette, so that every image type holds the
+	// exact same pixels
+	bounds := image.Rect(3, 1, 14, 8)
+	src := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			src.Set(x, y, palette.WebSafe[(x*7+y*13)%len(palette.WebSafe)])
+		}
+	}
+	for _, c := range []Colorimetry{{}, {BT709, FullRange}} {
+		expected, err := Convert(src, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images := []image.Image{
+			image.NewNRGBA(bounds),
+			image.NewRGBA64(bounds),
+			image.NewNRGBA64(bounds),
+			image.NewPaletted(bounds, palette.WebSafe),
+			nonStandard{image.NewRGBA(bounds)},
+		}
+		for _, img := range images {
+			draw.Draw(img.(draw.Image), bounds, src, bounds.Min, draw.Src)
+			yuv, err := Convert(img, c)
+			if err != nil {
+				t.Fatalf("%T: %v", img, err)
+			}
+			if !reflect.DeepEqual(yuv, expected) {
+				t.Errorf("%T, %v: expected\n%+v\ngot\n%+v", img, c, expected, yuv)
+			}
+		}
+	}
+}
+
+func TestConvertGray(t *testing.T) {
+	gray := image.NewGray(image.Rect(1, 1, 4, 3))
+	gray16 := image.NewGray16(gray.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 51)
+		gray16.Pix[2*i] = uint8(i * 51)
+	}
+	for _, img := range []image.Image{gray, gray16} {
+		for _, c := range []Colorimetry{{}, JPEG} {
+			yuv, err := Convert(img, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 1; y < 3; y++ {
+				for x := 1; x < 4; x++ {
+					v := gray.GrayAt(x, y).Y
+					expected, _, _ := c.RGBToYCbCr(v, v, v)
+					if p := yuv.YCbCrAt(x, y); p != (color.YCbCr{Y: expected, Cb: 128, Cr: 128}) {
+						t.Errorf("%T, %v: unexpected %v at (%d, %d) for grey %d", img, c, p, x, y, v)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecoderAllocs(t *testing.T) {
+	const width, height = 64, 48
+	formats := []PixelFormat{
+		format.I420, format.I422, format.NV12, format.NV21, format.YUY2, format.UYVY,
+		format.ARGB, format.BGRA, format.RGBA, format.RAW,
+	}
+	for _, f := range formats {
+		buf := make([]byte, FrameSize(f, width, height))
+		raw := NewRawFrame(f, buf, width, height)
+		var d Decoder
+		allocs := testing.AllocsPerRun(10, func() {
+			if _, err := d.DecodeToYUV420(raw, Colorimetry{BT709, LimitedRange}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %v allocations per frame", f, allocs)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	var p Pool
+	img := p.Get(image.Rect(0, 0, 16, 16))
+	p.Put(img)
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 8, 8), image.Rect(2, 2, 33, 17)} {
+		img = p.Get(r)
+		expected := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+		if img.Rect != r || img.YStride != expected.YStride || img.CStride != expected.CStride ||
+			len(img.Y) != len(expected.Y) || len(img.Cb) != len(expected.Cb) || len(img.Cr) != len(expected.Cr) {
+			t.Errorf("%v: unexpected layout", r)
+		}
+		p.Put(img)
+	}
+}
+
+func BenchmarkConvert(b *testing.B) {
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+	} {
+		ratio := ratio
+		b.Run(fmt.Sprint(ratio), func(b *testing.B) {
+			src := image.NewYCbCr(image.Rect(0, 0, 1920, 1080), ratio)
+			dst := image.NewYCbCr(src.Rect, image.YCbCrSubsampleRatio420)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := ConvertTo(dst, src, Colorimetry{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkConvertRGBA(b *testing.B) {
+	src := image.NewRGBA(image.Rect(0, 0, 1920, 1080))
+	dst := image.NewYCbCr(src.Rect, image.YCbCrSubsampleRatio420)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ConvertTo(dst, src, Colorimetry{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	for _, f := range []PixelFormat{format.NV12, format.YUY2, format.ARGB} {
+		f := f
+		b.Run(string(f), func(b *testing.B) {
+			raw := NewRawFrame(f, make([]byte, FrameSize(f, 1920, 1080)), 1920, 1080)
+			var d Decoder
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := d.DecodeToYUV420(raw, Colorimetry{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -5,36 +5,144 @@ package video
 import (
 	"errors"
 	"fmt"
+	"image"
 
 	"github.com/zyxar/mediastream/lib/format"
 )
 
 var decoders = map[PixelFormat]decoder{
-	format.I420: decodeI420,
-	format.I444: decodeI444,
-	format.NV21: decodeNV21,
-	format.NV12: decodeNV12,
-	format.YUY2: decodeYUY2,
-	format.UYVY: decodeUYVY,
-	format.ARGB: decodeARGB,
-	format.BGRA: decodeBGRA,
+	format.I420: (*Decoder).decodeI420,
+	format.I422: (*Decoder).decodeI422,
+	format.I444: (*Decoder).decodeI444,
+	format.NV21: (*Decoder).decodeNV21,
+	format.NV12: (*Decoder).decodeNV12,
+	format.YUY2: (*Decoder).decodeYUY2,
+	format.UYVY: (*Decoder).decodeUYVY,
+	format.YV12: (*Decoder).decodeYV12,
+	format.YV24: (*Decoder).decodeYV24,
+	format.ARGB: (*Decoder).decodeARGB,
+	format.BGRA: (*Decoder).decodeBGRA,
+	format.RAW:  (*Decoder).decodeRAW,
+	format.RGBA: (*Decoder).decodeRGBA,
+	format.MJPG: (*Decoder).decodeMJPG,
 }
 
-func Decode(f PixelFormat, buf []byte, width, height int) (Frame, error) {
-	if decode, ok := decoders[f]; ok {
-		return decode(buf, width, height)
-	}
-	return nil, fmt.Errorf("no decoder found for pixel format %q", f)
+// Decode wraps f in an image, into a frame with the format, colorimetry and
+// timing of f; MJPEG frames are full-range BT.601. The image shares the
+// buffer of f where its layout allows; padded rows are skipped, not copied.
+// Planes that have to be rearranged are copied to new buffers on every
+// call, see Decoder.
+func Decode(f RawFrame) (Frame, error) {
+	return new(Decoder).Decode(f)
 }
 
-type decoder func(buf []byte, width, height int) (Frame, error)
+type decoder func(d *Decoder, f RawFrame) (image.Image, error)
 
 var ErrInsufficientFrameBuffer = errors.New("insufficient frame buffer")
 
-func DecodeToYUV420(f PixelFormat, buf []byte, width, height int) (Frame, error) {
-	frame, err := Decode(f, buf, width, height)
+// DecodeToYUV420 decodes f to a 4:2:0 frame in colorimetry c. Y'CbCr frames
+// are converted from the colorimetry Decode gives them, RGB frames with the matrix of c. The
+// frame has the colorimetry c, and the format and timing of f.
+func DecodeToYUV420(f RawFrame, c Colorimetry) (Frame, error) {
+	return new(Decoder).DecodeToYUV420(f, c)
+}
+
+// Decoder decodes raw frames like Decode, but keeps the buffers it needs
+// across calls: once warmed up on a stream of frames of one size, it decodes
+// them without allocating, MJPEG aside. A frame it returns is valid until
+// the next call, or until the buffer of the raw frame is reused. The zero
+// value is ready to use; a Decoder must not be used concurrently.
+type Decoder struct {
+	// Parallelism overrides SetParallelism for the calls of the Decoder if
+	// not 0; below 0, it means runtime.GOMAXPROCS(0).
+	Parallelism int
+
+	yuv, yuv420, recolored image.YCbCr
+	rgba                   image.RGBA
+	planes                 []byte // of frames that cannot be wrapped
+}
+
+func (d *Decoder) Decode(f RawFrame) (Frame, error) {
+	decode, ok := decoders[f.Format.Canonical()]
+	if !ok {
+		return Frame{}, fmt.Errorf("no decoder found for pixel format %q", f.Format)
+	}
+	img, err := decode(d, f)
+	if err != nil {
+		return Frame{}, err
+	}
+	c := f.Colorimetry
+	if f.Format.Canonical() == format.MJPG {
+		c = JPEG // JFIF, whatever the source reports
+	}
+	return Frame{Image: img, Format: f.Format, Colorimetry: c, Timing: f.Timing}, nil
+}
+
+// DecodeToYUV420 is like the package function DecodeToYUV420.
+func (d *Decoder) DecodeToYUV420(f RawFrame, c Colorimetry) (Frame, error) {
+	frame, err := d.Decode(f)
+	if err != nil {
+		return Frame{}, err
+	}
+	frame.Image, err = d.toYUV420(frame.Image, frame.Colorimetry, c)
 	if err != nil {
+		return Frame{}, err
+	}
+	frame.Colorimetry = c
+	return frame, nil
+}
+
+// toYUV420 brings img, in colorimetry from if it is a Y'CbCr image, to 4:2:0
+// in c.
+func (d *Decoder) toYUV420(img image.Image, from, c Colorimetry) (image.Image, error) {
+	yuv, ok := img.(*image.YCbCr)
+	if !ok || yuv.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		if !ok {
+			from = c
+		}
+		reuseYCbCr(&d.yuv420, img.Bounds(), image.YCbCrSubsampleRatio420)
+		if err := convertTo(&d.yuv420, img, from, d.parallelism()); err != nil {
+			return nil, err
+		}
+		if !ok {
+			return &d.yuv420, nil
+		}
+		yuv = &d.yuv420
+	}
+	if from == c {
+		return yuv, nil
+	}
+	reuseYCbCr(&d.recolored, yuv.Rect, yuv.SubsampleRatio)
+	if err := recolorTo(&d.recolored, yuv, from, c, d.parallelism()); err != nil {
 		return nil, err
 	}
-	return Convert(frame)
+	return &d.recolored, nil
+}
+
+func (d *Decoder) parallelism() int {
+	if d.Parallelism != 0 {
+		return d.Parallelism
+	}
+	return Parallelism()
+}
+
+// grow returns buf resliced to n bytes, reallocated if it is too small.
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// reuseYCbCr lays out img as a tightly packed image of bounds r and the
+// subsample ratio sr, in its own buffers where they are large enough.
+func reuseYCbCr(img *image.YCbCr, r image.Rectangle, sr image.YCbCrSubsampleRatio) {
+	w, h := r.Dx(), r.Dy()
+	bw, bh := ratio(sr)
+	cw, ch := (r.Max.X+bw-1)/bw-r.Min.X/bw, (r.Max.Y+bh-1)/bh-r.Min.Y/bh
+	img.Y = grow(img.Y, w*h)
+	img.Cb = grow(img.Cb, cw*ch)
+	img.Cr = grow(img.Cr, cw*ch)
+	img.YStride, img.CStride = w, cw
+	img.SubsampleRatio, img.Rect = sr, r
 }
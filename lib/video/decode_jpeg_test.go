@@ -0,0 +1,153 @@
+package video
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+// stripDHT removes the DHT segments from a JPEG stream, as webcams do.
+func stripDHT(buf []byte) []byte {
+	out := append([]byte(nil), buf[:2]...)
+	i := 2
+	for i+4 <= len(buf) && buf[i+1] != 0xda {
+		n := 2 + int(binary.BigEndian.Uint16(buf[i+2:]))
+		if buf[i+1] != 0xc4 {
+			out = append(out, buf[i:i+n]...)
+		}
+		i += n
+	}
+	return append(out, buf[i:]...)
+}
+
+func TestDecodeMJPG(t *testing.T) {
+	const (
+		width  = 48
+		height = 32
+	)
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			src.Set(x, y, color.RGBA{uint8(x * 5), uint8(y * 7), uint8(x * y), 0xff})
+		}
+	}
+	gray := image.NewGray(src.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	testCases := []struct {
+		name string
+		img  image.Image
+	}{
+		{"color", src},
+		{"gray", gray},
+	}
+	for _, c := range testCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, c.img, &jpeg.Options{Quality: 90}); err != nil {
+				t.Fatal(err)
+			}
+			stripped := stripDHT(buf.Bytes())
+			if bytes.Contains(stripped, []byte{0xff, 0xc4}) {
+				t.Fatal("DHT segment not stripped")
+			}
+			expected, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range [][]byte{buf.Bytes(), stripped} {
+				img, err := Decode(RawFrame{Format: format.JPEG, Width: width, Height: height, Data: data})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if img.Bounds() != expected.Bounds() {
+					t.Fatalf("bounds: expected %v, got %v", expected.Bounds(), img.Bounds())
+				}
+				for y := 0; y < height; y++ {
+					for x := 0; x < width; x++ {
+						if e, g := expected.At(x, y), img.At(x, y); e != g {
+							t.Fatalf("pixel (%d,%d): expected %v, got %v", x, y, e, g)
+						}
+					}
+				}
+				yuv, err := Convert(img, JPEG)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if yuv.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+					t.Errorf("unexpected subsample ratio %v", yuv.SubsampleRatio)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMJPGColorimetry(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	red := color.RGBA{200, 40, 60, 0xff}
+	draw.Draw(src, src.Rect, image.NewUniform(red), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	// the source does not know MJPEG is full range
+	raw := RawFrame{Format: format.MJPG, Width: 32, Height: 32, Data: buf.Bytes()}
+	frame, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Colorimetry != JPEG {
+		t.Errorf("expected colorimetry %v, got %v", JPEG, frame.Colorimetry)
+	}
+	for _, c := range []Colorimetry{{}, {BT709, LimitedRange}, JPEG} {
+		frame, err := DecodeToYUV420(raw, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		yc := frame.Image.(*image.YCbCr).YCbCrAt(16, 16)
+		r, g, b := c.YCbCrToRGB(yc.Y, yc.Cb, yc.Cr)
+		if abs(int(r)-int(red.R)) > 3 || abs(int(g)-int(red.G)) > 3 || abs(int(b)-int(red.B)) > 3 {
+			t.Errorf("%v: expected %v, got (%d, %d, %d)", c, red, r, g, b)
+		}
+	}
+}
+
+func TestConvertSubsampleRatio(t *testing.T) {
+	r := image.Rect(0, 0, 6, 4)
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	} {
+		img := image.NewYCbCr(r, ratio)
+		for i := range img.Y {
+			img.Y[i] = uint8(i)
+		}
+		for i := range img.Cb {
+			img.Cb[i], img.Cr[i] = 100, 200
+		}
+		yuv, err := Convert(img, Colorimetry{})
+		if err != nil {
+			t.Fatalf("%v: %v", ratio, err)
+		}
+		if yuv.SubsampleRatio != image.YCbCrSubsampleRatio420 || yuv.CStride != 3 || len(yuv.Cb) != 6 {
+			t.Errorf("%v: unexpected layout %v, stride %d, %d samples", ratio, yuv.SubsampleRatio, yuv.CStride, len(yuv.Cb))
+		}
+		for i := range yuv.Cb {
+			if yuv.Cb[i] != 100 || yuv.Cr[i] != 200 {
+				t.Errorf("%v: unexpected chroma %d,%d at %d", ratio, yuv.Cb[i], yuv.Cr[i], i)
+			}
+		}
+	}
+}
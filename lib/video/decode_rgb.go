@@ -1,43 +1,74 @@
 package video
 
-import (
-	"image"
-	"math/bits"
-	"unsafe"
-)
-
-func decodeARGB(frame []byte, width, height int) (image.Image, error) {
-	size := 4 * width * height
-	if size > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
+import "image"
+
+func (d *Decoder) decodeARGB(f RawFrame) (image.Image, error) {
+	return d.decodeSwizzled(f, kern.swapRB)
+}
+
+func (d *Decoder) decodeBGRA(f RawFrame) (image.Image, error) {
+	return d.decodeSwizzled(f, kern.rotateRGBA)
+}
+
+func (d *Decoder) decodeRGBA(f RawFrame) (image.Image, error) {
+	return d.decodeSwizzled(f, kern.reverseRGBA)
+}
+
+// decodeSwizzled rearranges the 32-bit pixels of f to R,G,B,A with swizzle,
+// one row at a time, in a buffer of the Decoder; f is left as it is.
+func (d *Decoder) decodeSwizzled(f RawFrame, swizzle func(pix []byte)) (image.Image, error) {
+	src, stride, err := f.plane(0, 4*f.Width, f.Height)
+	if err != nil {
+		return nil, err
 	}
-	r := image.Rect(0, 0, width, height)
-	for i := 0; i < size; i += 4 {
-		*(*uint32)(unsafe.Pointer(&frame[i])) = func(v uint32) uint32 {
-			return (v & 0xFF00FF00) | (v&0xFF)<<16 | (v&0xFF0000)>>16
-		}(*(*uint32)(unsafe.Pointer(&frame[i])))
-		//frame[i], frame[i+2] = frame[i+2], frame[i]
+	img := &d.rgba
+	img.Pix = grow(d.planes, 4*f.Width*f.Height)
+	img.Stride, img.Rect = 4*f.Width, image.Rect(0, 0, f.Width, f.Height)
+	d.planes = img.Pix
+	if n := stripeCount(d.parallelism(), f.Height); n > 1 {
+		stripes(n, f.Height, func(r0, r1 int) { swizzleRows(swizzle, img, src, stride, r0, r1) })
+	} else {
+		swizzleRows(swizzle, img, src, stride, 0, f.Height)
 	}
-	return &image.RGBA{
-		Pix:    frame[:size:size],
-		Stride: 4 * r.Dx(),
-		Rect:   r,
-	}, nil
+	return img, nil
 }
 
-func decodeBGRA(frame []byte, width, height int) (image.Image, error) {
-	size := 4 * width * height
-	if size > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
+// swizzleRows copies rows [r0, r1) of src into img, and swizzles them there.
+func swizzleRows(swizzle func(pix []byte), img *image.RGBA, src []byte, stride, r0, r1 int) {
+	n := 4 * img.Rect.Dx()
+	for row := r0; row < r1; row++ {
+		dst := img.Pix[row*img.Stride : row*img.Stride+n]
+		copy(dst, src[row*stride:row*stride+n])
+		swizzle(dst)
 	}
-	r := image.Rect(0, 0, width, height)
-	for i := 0; i < size; i += 4 {
-		*(*uint32)(unsafe.Pointer(&frame[i])) = bits.RotateLeft32(*(*uint32)(unsafe.Pointer(&frame[i])), -8)
-		//frame[i], frame[i+1], frame[i+2], frame[i+3] = frame[i+1], frame[i+2], frame[i+3], frame[i]
+}
+
+// decodeRAW expands 24-bit RGB into an opaque RGBA image.
+func (d *Decoder) decodeRAW(f RawFrame) (image.Image, error) {
+	src, stride, err := f.plane(0, 3*f.Width, f.Height)
+	if err != nil {
+		return nil, err
+	}
+	img := &d.rgba
+	img.Pix = grow(d.planes, 4*f.Width*f.Height)
+	img.Stride, img.Rect = 4*f.Width, image.Rect(0, 0, f.Width, f.Height)
+	d.planes = img.Pix
+	if n := stripeCount(d.parallelism(), f.Height); n > 1 {
+		stripes(n, f.Height, func(r0, r1 int) { expandRAW(img, src, stride, r0, r1) })
+	} else {
+		expandRAW(img, src, stride, 0, f.Height)
+	}
+	return img, nil
+}
+
+// expandRAW expands rows [r0, r1) of src into img.
+func expandRAW(img *image.RGBA, src []byte, stride, r0, r1 int) {
+	w := img.Rect.Dx()
+	for row := r0; row < r1; row++ {
+		line := src[row*stride : row*stride+3*w]
+		dst := img.Pix[row*img.Stride : row*img.Stride+4*w]
+		for i, j := 0, 0; i < len(line); i, j = i+3, j+4 {
+			dst[j], dst[j+1], dst[j+2], dst[j+3] = line[i], line[i+1], line[i+2], 0xff
+		}
 	}
-	return &image.RGBA{
-		Pix:    frame[:size:size],
-		Stride: 4 * r.Dx(),
-		Rect:   r,
-	}, nil
 }
@@ -1,48 +1,160 @@
 package video
 
 import (
-	"fmt"
+	"image"
+	"reflect"
 	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
 )
 
-func BenchmarkDecodeBGRA(b *testing.B) {
-	sizes := []struct {
-		width, height int
-	}{
-		{640, 480},
-		{1920, 1080},
-	}
-	for _, sz := range sizes {
-		sz := sz
-		b.Run(fmt.Sprintf("%dx%d", sz.width, sz.height), func(b *testing.B) {
-			input := make([]byte, sz.width*sz.height*4)
-			for i := 0; i < b.N; i++ {
-				_, err := decodeBGRA(input, sz.width, sz.height)
-				if err != nil {
-					b.Fatal(err)
-				}
-			}
-		})
+func TestDecodeARGB(t *testing.T) {
+	const (
+		width  = 2
+		height = 1
+	)
+	input := []byte{
+		//B   G     R     A
+		0x30, 0x20, 0x10, 0xff,
+		0x60, 0x50, 0x40, 0x80,
+	}
+	expected := &image.RGBA{
+		Pix: []byte{
+			//R   G     B     A
+			0x10, 0x20, 0x30, 0xff,
+			0x40, 0x50, 0x60, 0x80,
+		},
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeARGB(NewRawFrame(format.ARGB, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeBGRA(t *testing.T) {
+	const (
+		width  = 2
+		height = 1
+	)
+	input := []byte{
+		//A   R     G     B
+		0xff, 0x10, 0x20, 0x30,
+		0x80, 0x40, 0x50, 0x60,
+	}
+	expected := &image.RGBA{
+		Pix: []byte{
+			//R   G     B     A
+			0x10, 0x20, 0x30, 0xff,
+			0x40, 0x50, 0x60, 0x80,
+		},
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeBGRA(NewRawFrame(format.BGRA, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeRGBA(t *testing.T) {
+	const (
+		width  = 2
+		height = 1
+	)
+	input := []byte{
+		//A   B     G     R
+		0xff, 0x30, 0x20, 0x10,
+		0x80, 0x60, 0x50, 0x40,
+	}
+	expected := &image.RGBA{
+		Pix: []byte{
+			//R   G     B     A
+			0x10, 0x20, 0x30, 0xff,
+			0x40, 0x50, 0x60, 0x80,
+		},
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeRGBA(NewRawFrame(format.RGBA, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
 	}
 }
 
-func BenchmarkDecodeARGB(b *testing.B) {
-	sizes := []struct {
-		width, height int
-	}{
-		{640, 480},
-		{1920, 1080},
-	}
-	for _, sz := range sizes {
-		sz := sz
-		b.Run(fmt.Sprintf("%dx%d", sz.width, sz.height), func(b *testing.B) {
-			input := make([]byte, sz.width*sz.height*4)
-			for i := 0; i < b.N; i++ {
-				_, err := decodeARGB(input, sz.width, sz.height)
-				if err != nil {
-					b.Fatal(err)
-				}
-			}
-		})
+func TestDecodeRAW(t *testing.T) {
+	const (
+		width  = 2
+		height = 1
+	)
+	input := []byte{
+		//R   G     B
+		0x10, 0x20, 0x30,
+		0x40, 0x50, 0x60,
+	}
+	expected := &image.RGBA{
+		Pix: []byte{
+			//R   G     B     A
+			0x10, 0x20, 0x30, 0xff,
+			0x40, 0x50, 0x60, 0xff,
+		},
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeRAW(NewRawFrame(format.RAW, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func BenchmarkDecodeBGRA(b *testing.B) { benchmarkDecode(b, format.BGRA) }
+
+func BenchmarkDecodeARGB(b *testing.B) { benchmarkDecode(b, format.ARGB) }
+
+func BenchmarkDecodeRGBA(b *testing.B) { benchmarkDecode(b, format.RGBA) }
+
+func TestDecodeSwizzledSource(t *testing.T) {
+	const (
+		width  = 3
+		height = 2
+		stride = 4*width + 4
+	)
+	input := make([]byte, stride*height)
+	for i := range input {
+		input[i] = uint8(i)
+	}
+	orig := append([]byte(nil), input...)
+	for _, f := range []PixelFormat{format.ARGB, format.BGRA, format.RGBA} {
+		raw := NewRawFrame(f, input, width, height)
+		raw.Planes = []Plane{{Offset: 0, Stride: stride}}
+		var d Decoder
+		first, err := d.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pix := append([]byte(nil), first.Image.(*image.RGBA).Pix...)
+		if !reflect.DeepEqual(input, orig) {
+			t.Fatalf("%s: source modified", f)
+		}
+		second, err := d.Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(pix, second.Image.(*image.RGBA).Pix) {
+			t.Errorf("%s: decoding twice differs", f)
+		}
 	}
 }
@@ -2,156 +2,152 @@ package video
 
 import "image"
 
-func decodeI420(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	cbi := yi + yi/4
-	cri := cbi + yi/4
-	if cri > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
+// decodePlanar wraps the planes of f; u and v are the indices of its Cb and
+// Cr planes.
+func (d *Decoder) decodePlanar(f RawFrame, ratio image.YCbCrSubsampleRatio, u, v int) (image.Image, error) {
+	desc, _ := f.Format.Descriptor()
+	cw, ch := desc.PlaneSize(u, f.Width, f.Height)
+	y, yStride, err := f.plane(0, f.Width, f.Height)
+	if err != nil {
+		return nil, err
 	}
-	return &image.YCbCr{
-		Y:              frame[:yi],
-		YStride:        width,
-		Cb:             frame[yi:cbi],
-		Cr:             frame[cbi:cri],
-		CStride:        width / 2,
-		SubsampleRatio: image.YCbCrSubsampleRatio420,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
-}
-
-func decodeYV12(frame []byte, width, height int) (image.Image, error) {
-	img, err := decodeI420(frame, width, height)
+	cb, cbStride, err := f.plane(u, cw, ch)
+	if err != nil {
+		return nil, err
+	}
+	cr, crStride, err := f.plane(v, cw, ch)
 	if err != nil {
-		return img, err
+		return nil, err
+	}
+	if cbStride != crStride {
+		return nil, ErrInvalidPlanes
+	}
+	d.yuv = image.YCbCr{
+		Y:              y,
+		YStride:        yStride,
+		Cb:             cb,
+		Cr:             cr,
+		CStride:        cbStride,
+		SubsampleRatio: ratio,
+		Rect:           image.Rect(0, 0, f.Width, f.Height),
 	}
-	yuv := img.(*image.YCbCr)
-	yuv.Cb, yuv.Cr = yuv.Cr, yuv.Cb
-	return yuv, err
+	return &d.yuv, nil
 }
 
-func decodeI444(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	cbi := yi * 2
-	cri := yi * 3
-	if cri > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
-	}
-	return &image.YCbCr{
-		Y:              frame[:yi],
-		YStride:        width,
-		Cb:             frame[yi:cbi],
-		Cr:             frame[cbi:cri],
-		CStride:        width,
-		SubsampleRatio: image.YCbCrSubsampleRatio444,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
+func (d *Decoder) decodeI420(f RawFrame) (image.Image, error) {
+	return d.decodePlanar(f, image.YCbCrSubsampleRatio420, 1, 2)
 }
 
-func decodeYV24(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	cbi := yi * 2
-	cri := yi * 3
-	if cri > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
-	}
-	return &image.YCbCr{
-		Y:              frame[:yi],
-		YStride:        width,
-		Cr:             frame[yi:cbi],
-		Cb:             frame[cbi:cri],
-		CStride:        width,
-		SubsampleRatio: image.YCbCrSubsampleRatio444,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
+func (d *Decoder) decodeYV12(f RawFrame) (image.Image, error) {
+	return d.decodePlanar(f, image.YCbCrSubsampleRatio420, 2, 1)
+}
+
+func (d *Decoder) decodeI422(f RawFrame) (image.Image, error) {
+	return d.decodePlanar(f, image.YCbCrSubsampleRatio422, 1, 2)
+}
+
+func (d *Decoder) decodeI444(f RawFrame) (image.Image, error) {
+	return d.decodePlanar(f, image.YCbCrSubsampleRatio444, 1, 2)
 }
 
-func decodeNV21(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	ci := yi + yi/2
-	if ci > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
+func (d *Decoder) decodeYV24(f RawFrame) (image.Image, error) {
+	return d.decodePlanar(f, image.YCbCrSubsampleRatio444, 2, 1)
+}
+
+// decodeSemiPlanar splits the interleaved chroma plane of f; u is the
+// position of Cb within each pair.
+func (d *Decoder) decodeSemiPlanar(f RawFrame, u int) (image.Image, error) {
+	desc, _ := f.Format.Descriptor()
+	uvBytes, ch := desc.PlaneSize(1, f.Width, f.Height)
+	cw := uvBytes / 2
+	y, yStride, err := f.plane(0, f.Width, f.Height)
+	if err != nil {
+		return nil, err
+	}
+	uv, uvStride, err := f.plane(1, uvBytes, ch)
+	if err != nil {
+		return nil, err
 	}
-	cb := make([]byte, 0, yi/2)
-	cr := make([]byte, 0, yi/2)
-	for i := yi; i < ci; i += 2 {
-		cr = append(cr, frame[i])
-		cb = append(cb, frame[i+1])
+	d.planes = grow(d.planes, 2*cw*ch)
+	cb, cr := d.planes[:cw*ch], d.planes[cw*ch:]
+	first, second := cb, cr
+	if u != 0 {
+		first, second = cr, cb
 	}
-	return &image.YCbCr{
-		Y:              frame[:yi],
-		YStride:        width,
+	if n := stripeCount(d.parallelism(), ch); n > 1 {
+		stripes(n, ch, func(r0, r1 int) { deinterleaveRows(first, second, uv, cw, uvStride, r0, r1) })
+	} else {
+		deinterleaveRows(first, second, uv, cw, uvStride, 0, ch)
+	}
+	d.yuv = image.YCbCr{
+		Y:              y,
+		YStride:        yStride,
 		Cb:             cb,
 		Cr:             cr,
-		CStride:        width / 2,
+		CStride:        cw,
 		SubsampleRatio: image.YCbCrSubsampleRatio420,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
+		Rect:           image.Rect(0, 0, f.Width, f.Height),
+	}
+	return &d.yuv, nil
 }
 
-func decodeNV12(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	ci := yi + yi/2
-	if ci > len(frame) {
-		return nil, ErrInsufficientFrameBuffer
+// deinterleaveRows splits rows [r0, r1) of pairs of uv into u and v, with
+// rows cw bytes apart.
+func deinterleaveRows(u, v, uv []byte, cw, uvStride, r0, r1 int) {
+	for row := r0; row < r1; row++ {
+		kern.deinterleave(u[row*cw:(row+1)*cw], v[row*cw:(row+1)*cw], uv[row*uvStride:row*uvStride+2*cw])
 	}
-	cb := make([]byte, 0, yi/2)
-	cr := make([]byte, 0, yi/2)
-	for i := yi; i < ci; i += 2 {
-		cb = append(cb, frame[i])
-		cr = append(cr, frame[i+1])
-	}
-	return &image.YCbCr{
-		Y:              frame[:yi],
-		YStride:        width,
-		Cb:             cb,
-		Cr:             cr,
-		CStride:        width / 2,
-		SubsampleRatio: image.YCbCrSubsampleRatio420,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
 }
 
-func decodeYUY2(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	ci := yi / 2
-	fi := yi * 2
-	if len(frame) < fi {
-		return nil, ErrInsufficientFrameBuffer
+func (d *Decoder) decodeNV21(f RawFrame) (image.Image, error) {
+	return d.decodeSemiPlanar(f, 1)
+}
+
+func (d *Decoder) decodeNV12(f RawFrame) (image.Image, error) {
+	return d.decodeSemiPlanar(f, 0)
+}
+
+// decodePacked422 splits the macropixels of f with unpack, one row at a time.
+func (d *Decoder) decodePacked422(f RawFrame, unpack func(y, cb, cr, src []byte)) (image.Image, error) {
+	desc, _ := f.Format.Descriptor()
+	rowBytes, _ := desc.PlaneSize(0, f.Width, f.Height)
+	cw := rowBytes / 4
+	buf, stride, err := f.plane(0, rowBytes, f.Height)
+	if err != nil {
+		return nil, err
+	}
+	n := f.Width * f.Height
+	d.planes = grow(d.planes, n+2*cw*f.Height)
+	y, cb, cr := d.planes[:n], d.planes[n:n+cw*f.Height], d.planes[n+cw*f.Height:]
+	if n := stripeCount(d.parallelism(), f.Height); n > 1 {
+		stripes(n, f.Height, func(r0, r1 int) { unpackRows(unpack, y, cb, cr, buf, f.Width, stride, r0, r1) })
+	} else {
+		unpackRows(unpack, y, cb, cr, buf, f.Width, stride, 0, f.Height)
 	}
-	y := make([]byte, yi)
-	cb := make([]byte, ci)
-	cr := make([]byte, ci)
-	fillYUY2(y, cb, cr, frame, width, height)
-	return &image.YCbCr{
+	d.yuv = image.YCbCr{
 		Y:              y,
-		YStride:        width,
+		YStride:        f.Width,
 		Cb:             cb,
 		Cr:             cr,
-		CStride:        width / 2,
+		CStride:        cw,
 		SubsampleRatio: image.YCbCrSubsampleRatio422,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
+		Rect:           image.Rect(0, 0, f.Width, f.Height),
+	}
+	return &d.yuv, nil
 }
 
-func decodeUYVY(frame []byte, width, height int) (image.Image, error) {
-	yi := width * height
-	ci := yi / 2
-	fi := yi * 2
-	if len(frame) < fi {
-		return nil, ErrInsufficientFrameBuffer
+func (d *Decoder) decodeYUY2(f RawFrame) (image.Image, error) {
+	return d.decodePacked422(f, kern.unpackYUY2)
+}
+
+func (d *Decoder) decodeUYVY(f RawFrame) (image.Image, error) {
+	return d.decodePacked422(f, kern.unpackUYVY)
+}
+
+// unpackRows unpacks rows [r0, r1) of buf, w pixels wide, with unpack.
+func unpackRows(unpack func(y, cb, cr, src []byte), y, cb, cr, buf []byte, w, stride, r0, r1 int) {
+	cw := (w + 1) / 2
+	for row := r0; row < r1; row++ {
+		unpack(y[row*w:(row+1)*w], cb[row*cw:(row+1)*cw], cr[row*cw:(row+1)*cw], buf[row*stride:row*stride+4*cw])
 	}
-	y := make([]byte, yi)
-	cb := make([]byte, ci)
-	cr := make([]byte, ci)
-	fillUYVY(y, cb, cr, frame, width, height)
-	return &image.YCbCr{
-		Y:              y,
-		YStride:        width,
-		Cb:             cb,
-		Cr:             cr,
-		CStride:        width / 2,
-		SubsampleRatio: image.YCbCrSubsampleRatio422,
-		Rect:           image.Rect(0, 0, width, height),
-	}, nil
 }
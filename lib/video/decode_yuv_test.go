@@ -5,6 +5,8 @@ import (
 	"image"
 	"reflect"
 	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
 )
 
 func TestDecodeYUY2(t *testing.T) {
@@ -26,7 +28,7 @@ func TestDecodeYUY2(t *testing.T) {
 		SubsampleRatio: image.YCbCrSubsampleRatio422,
 		Rect:           image.Rect(0, 0, width, height),
 	}
-	img, err := decodeYUY2(input, width, height)
+	img, err := new(Decoder).decodeYUY2(NewRawFrame(format.YUY2, input, width, height))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -54,7 +56,7 @@ func TestDecodeUYVY(t *testing.T) {
 		SubsampleRatio: image.YCbCrSubsampleRatio422,
 		Rect:           image.Rect(0, 0, width, height),
 	}
-	img, err := decodeUYVY(input, width, height)
+	img, err := new(Decoder).decodeUYVY(NewRawFrame(format.UYVY, input, width, height))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -82,7 +84,7 @@ func TestDecodeNV21(t *testing.T) {
 		SubsampleRatio: image.YCbCrSubsampleRatio420,
 		Rect:           image.Rect(0, 0, width, height),
 	}
-	img, err := decodeNV21(input, width, height)
+	img, err := new(Decoder).decodeNV21(NewRawFrame(format.NV21, input, width, height))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,7 +112,35 @@ func TestDecodeNV12(t *testing.T) {
 		SubsampleRatio: image.YCbCrSubsampleRatio420,
 		Rect:           image.Rect(0, 0, width, height),
 	}
-	img, err := decodeNV12(input, width, height)
+	img, err := new(Decoder).decodeNV12(NewRawFrame(format.NV12, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeI420(t *testing.T) {
+	const (
+		width  = 2
+		height = 2
+	)
+	input := []byte{
+		0x01, 0x03, 0x05, 0x07, // Y
+		0x82, // Cb
+		0x84, // Cr
+	}
+	expected := &image.YCbCr{
+		Y:              []byte{0x01, 0x03, 0x05, 0x07},
+		YStride:        width,
+		Cb:             []byte{0x82},
+		Cr:             []byte{0x84},
+		CStride:        width / 2,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeI420(NewRawFrame(format.I420, input, width, height))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,7 +149,121 @@ func TestDecodeNV12(t *testing.T) {
 	}
 }
 
-func BenchmarkDecodeYUY2(b *testing.B) {
+func TestDecodeYV12(t *testing.T) {
+	const (
+		width  = 2
+		height = 2
+	)
+	input := []byte{
+		0x01, 0x03, 0x05, 0x07, // Y
+		0x84, // Cr
+		0x82, // Cb
+	}
+	expected := &image.YCbCr{
+		Y:              []byte{0x01, 0x03, 0x05, 0x07},
+		YStride:        width,
+		Cb:             []byte{0x82},
+		Cr:             []byte{0x84},
+		CStride:        width / 2,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeYV12(NewRawFrame(format.YV12, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeI422(t *testing.T) {
+	const (
+		width  = 2
+		height = 2
+	)
+	input := []byte{
+		0x01, 0x03, 0x05, 0x07, // Y
+		0x82, 0x86, // Cb
+		0x84, 0x88, // Cr
+	}
+	expected := &image.YCbCr{
+		Y:              []byte{0x01, 0x03, 0x05, 0x07},
+		YStride:        width,
+		Cb:             []byte{0x82, 0x86},
+		Cr:             []byte{0x84, 0x88},
+		CStride:        width / 2,
+		SubsampleRatio: image.YCbCrSubsampleRatio422,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeI422(NewRawFrame(format.I422, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeI444(t *testing.T) {
+	const (
+		width  = 2
+		height = 2
+	)
+	input := []byte{
+		0x01, 0x03, 0x05, 0x07, // Y
+		0x82, 0x86, 0x8a, 0x8e, // Cb
+		0x84, 0x88, 0x8c, 0x90, // Cr
+	}
+	expected := &image.YCbCr{
+		Y:              []byte{0x01, 0x03, 0x05, 0x07},
+		YStride:        width,
+		Cb:             []byte{0x82, 0x86, 0x8a, 0x8e},
+		Cr:             []byte{0x84, 0x88, 0x8c, 0x90},
+		CStride:        width,
+		SubsampleRatio: image.YCbCrSubsampleRatio444,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeI444(NewRawFrame(format.I444, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+func TestDecodeYV24(t *testing.T) {
+	const (
+		width  = 2
+		height = 2
+	)
+	input := []byte{
+		0x01, 0x03, 0x05, 0x07, // Y
+		0x84, 0x88, 0x8c, 0x90, // Cr
+		0x82, 0x86, 0x8a, 0x8e, // Cb
+	}
+	expected := &image.YCbCr{
+		Y:              []byte{0x01, 0x03, 0x05, 0x07},
+		YStride:        width,
+		Cb:             []byte{0x82, 0x86, 0x8a, 0x8e},
+		Cr:             []byte{0x84, 0x88, 0x8c, 0x90},
+		CStride:        width,
+		SubsampleRatio: image.YCbCrSubsampleRatio444,
+		Rect:           image.Rect(0, 0, width, height),
+	}
+	img, err := new(Decoder).decodeYV24(NewRawFrame(format.YV24, input, width, height))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, img) {
+		t.Errorf("Wrong decode result,\nexpected:\n%+v\ngot:\n%+v", expected, img)
+	}
+}
+
+// benchmarkDecode decodes frames of pixel format f with a Decoder; these
+// benchmarks guard the kernels behind the decoders.
+func benchmarkDecode(b *testing.B, f PixelFormat) {
 	sizes := []struct {
 		width, height int
 	}{
@@ -129,13 +273,54 @@ func BenchmarkDecodeYUY2(b *testing.B) {
 	for _, sz := range sizes {
 		sz := sz
 		b.Run(fmt.Sprintf("%dx%d", sz.width, sz.height), func(b *testing.B) {
-			input := make([]byte, sz.width*sz.height*2)
+			input := make([]byte, FrameSize(f, sz.width, sz.height))
+			frame := NewRawFrame(f, input, sz.width, sz.height)
+			var d Decoder
+			b.SetBytes(int64(len(input)))
+			b.ReportAllocs()
 			for i := 0; i < b.N; i++ {
-				_, err := decodeYUY2(input, sz.width, sz.height)
-				if err != nil {
+				if _, err := d.Decode(frame); err != nil {
 					b.Fatal(err)
 				}
 			}
 		})
 	}
 }
+
+func BenchmarkDecodeYUY2(b *testing.B) { benchmarkDecode(b, format.YUY2) }
+
+func BenchmarkDecodeUYVY(b *testing.B) { benchmarkDecode(b, format.UYVY) }
+
+func BenchmarkDecodeNV12(b *testing.B) { benchmarkDecode(b, format.NV12) }
+
+func TestDecodeAlias(t *testing.T) {
+	testCases := []struct {
+		alias, format PixelFormat
+	}{
+		{"IYUV", format.I420},
+		{"YU12", format.I420},
+		{"YU16", format.I422},
+		{"YU24", format.I444},
+		{"YUYV", format.YUY2},
+		{"YUVS", format.YUY2},
+		{"RGB3", format.RAW},
+		{"CM24", format.RAW},
+		{"CM32", format.BGRA},
+	}
+	for _, c := range testCases {
+		input := make([]byte, 4*4*4)
+		for i := range input {
+			input[i] = byte(i)
+		}
+		expected, err := Decode(NewRawFrame(c.format, append([]byte(nil), input...), 4, 4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := Decode(NewRawFrame(c.alias, append([]byte(nil), input...), 4, 4))
+		if err != nil {
+			t.Errorf("%s: %v", c.alias, err)
+		} else if !reflect.DeepEqual(expected.Image, img.Image) {
+			t.Errorf("%s: decoded differently from %s", c.alias, c.format)
+		}
+	}
+}
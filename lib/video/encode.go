@@ -0,0 +1,177 @@
+package video
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+var encoders = map[PixelFormat]encoder{
+	format.I420: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePlanar(dst, img, 2, 2, false) },
+	format.I422: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePlanar(dst, img, 2, 1, false) },
+	format.I444: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePlanar(dst, img, 1, 1, false) },
+	format.YV12: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePlanar(dst, img, 2, 2, true) },
+	format.YV24: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePlanar(dst, img, 1, 1, true) },
+	format.NV12: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodeSemiPlanar(dst, img, 0) },
+	format.NV21: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodeSemiPlanar(dst, img, 1) },
+	format.YUY2: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePacked422(dst, img, 0, 2, 1, 3) },
+	format.UYVY: func(dst []byte, img *image.YCbCr, _ Colorimetry) { encodePacked422(dst, img, 1, 3, 0, 2) },
+	format.ARGB: func(dst []byte, img *image.YCbCr, c Colorimetry) { encodeRGB(dst, img, c, 4, 2, 1, 0, 3) },  // B, G, R, A
+	format.BGRA: func(dst []byte, img *image.YCbCr, c Colorimetry) { encodeRGB(dst, img, c, 4, 1, 2, 3, 0) },  // A, R, G, B
+	format.RGBA: func(dst []byte, img *image.YCbCr, c Colorimetry) { encodeRGB(dst, img, c, 4, 3, 2, 1, 0) },  // A, B, G, R
+	format.RAW:  func(dst []byte, img *image.YCbCr, c Colorimetry) { encodeRGB(dst, img, c, 3, 0, 1, 2, -1) }, // R, G, B
+}
+
+type encoder func(dst []byte, img *image.YCbCr, c Colorimetry)
+
+// FrameSize returns the size in bytes of a tightly packed frame, or 0 for
+// pixel formats without a fixed size.
+func FrameSize(f PixelFormat, width, height int) int {
+	return f.FrameSize(width, height, nil)
+}
+
+// Encode packs img, whose samples are in colorimetry c, into a new tightly
+// packed buffer in pixel format f, the inverse of Decode. Chroma is averaged
+// or replicated as the subsampling of f requires; RGB formats are converted
+// with the matrix and range of c.
+func Encode(f PixelFormat, img *image.YCbCr, c Colorimetry) ([]byte, error) {
+	buf := make([]byte, FrameSize(f, img.Rect.Dx(), img.Rect.Dy()))
+	n, err := EncodeTo(buf, f, img, c)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// EncodeTo is like Encode but packs into dst, returning the number of bytes
+// written.
+func EncodeTo(dst []byte, f PixelFormat, img *image.YCbCr, c Colorimetry) (int, error) {
+	enc, ok := encoders[f.Canonical()]
+	if !ok {
+		return 0, fmt.Errorf("no encoder found for pixel format %q", f)
+	}
+	size := FrameSize(f, img.Rect.Dx(), img.Rect.Dy())
+	if len(dst) < size {
+		return 0, ErrInsufficientFrameBuffer
+	}
+	enc(dst[:size], img, c)
+	return size, nil
+}
+
+// yRow returns row y of the luma plane of img, relative to its origin.
+func yRow(img *image.YCbCr, y int) []byte {
+	i := img.YOffset(img.Rect.Min.X, img.Rect.Min.Y+y)
+	return img.Y[i : i+img.Rect.Dx()]
+}
+
+// chroma averages Cb and Cr of img over the bw×bh block of pixels at (x, y),
+// relative to its origin and clipped to its bounds.
+func chroma(img *image.YCbCr, x, y, bw, bh int) (cb, cr byte) {
+	x0, y0 := img.Rect.Min.X+x, img.Rect.Min.Y+y
+	x1, y1 := x0+bw, y0+bh
+	if x1 > img.Rect.Max.X {
+		x1 = img.Rect.Max.X
+	}
+	if y1 > img.Rect.Max.Y {
+		y1 = img.Rect.Max.Y
+	}
+	var sb, sr, n int
+	for yy := y0; yy < y1; yy++ {
+		for xx := x0; xx < x1; xx++ {
+			ci := img.COffset(xx, yy)
+			sb += int(img.Cb[ci])
+			sr += int(img.Cr[ci])
+			n++
+		}
+	}
+	return byte((sb + n/2) / n), byte((sr + n/2) / n)
+}
+
+// ratio returns the chroma block size of a subsampling ratio.
+func ratio(r image.YCbCrSubsampleRatio) (bw, bh int) {
+	switch r {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+// encodePlanar writes the Y plane followed by the chroma planes subsampled by
+// bw×bh, Cr first if swap is set.
+func encodePlanar(dst []byte, img *image.YCbCr, bw, bh int, swap bool) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cw, ch := (w+bw-1)/bw, (h+bh-1)/bh
+	for y := 0; y < h; y++ {
+		copy(dst[y*w:], yRow(img, y))
+	}
+	cb, cr := dst[w*h:w*h+cw*ch], dst[w*h+cw*ch:w*h+2*cw*ch]
+	if swap {
+		cb, cr = cr, cb
+	}
+	subsample(cb, cr, cw, img, bw, bh, 0, ch)
+}
+
+// encodeSemiPlanar writes the Y plane followed by interleaved 4:2:0 chroma;
+// u is the position of Cb within each pair.
+func encodeSemiPlanar(dst []byte, img *image.YCbCr, u int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cw, ch := (w+1)/2, (h+1)/2
+	for y := 0; y < h; y++ {
+		copy(dst[y*w:], yRow(img, y))
+	}
+	uv := dst[w*h:]
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw; x++ {
+			i := 2 * (y*cw + x)
+			uv[i+u], uv[i+1-u] = chroma(img, 2*x, 2*y, 2, 2)
+		}
+	}
+}
+
+// encodePacked422 writes macropixels of two pixels in 4 bytes, with the two
+// luma samples and Cb and Cr at the given byte offsets.
+func encodePacked422(dst []byte, img *image.YCbCr, y0i, y1i, cbi, cri int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	cw := (w + 1) / 2
+	i := 0
+	for y := 0; y < h; y++ {
+		row := yRow(img, y)
+		for x := 0; x < cw; x++ {
+			y0, y1 := row[2*x], row[2*x]
+			if 2*x+1 < w {
+				y1 = row[2*x+1]
+			}
+			dst[i+y0i], dst[i+y1i] = y0, y1
+			dst[i+cbi], dst[i+cri] = chroma(img, 2*x, y, 2, 1)
+			i += 4
+		}
+	}
+}
+
+// encodeRGB writes pixels of n bytes with r, g, b and alpha at the given
+// byte offsets; a negative offset omits the component.
+func encodeRGB(dst []byte, img *image.YCbCr, c Colorimetry, n, ri, gi, bi, ai int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	i := 0
+	for y := 0; y < h; y++ {
+		row := yRow(img, y)
+		for x := 0; x < w; x++ {
+			ci := img.COffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			r, g, b := c.YCbCrToRGB(row[x], img.Cb[ci], img.Cr[ci])
+			dst[i+ri], dst[i+gi], dst[i+bi] = r, g, b
+			if ai >= 0 {
+				dst[i+ai] = 0xff
+			}
+			i += n
+		}
+	}
+}
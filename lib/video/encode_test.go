@@ -0,0 +1,119 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+func newTestImage(width, height int, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), ratio)
+	for i := range img.Y {
+		img.Y[i] = byte(16 + i*7%220)
+	}
+	for i := range img.Cb {
+		img.Cb[i] = byte(16 + i*11%224)
+		img.Cr[i] = byte(240 - i*13%224)
+	}
+	return img
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	testCases := []struct {
+		format PixelFormat
+		ratio  image.YCbCrSubsampleRatio
+	}{
+		{format.I420, image.YCbCrSubsampleRatio420},
+		{format.YV12, image.YCbCrSubsampleRatio420},
+		{format.NV12, image.YCbCrSubsampleRatio420},
+		{format.NV21, image.YCbCrSubsampleRatio420},
+		{format.I422, image.YCbCrSubsampleRatio422},
+		{format.YUY2, image.YCbCrSubsampleRatio422},
+		{format.UYVY, image.YCbCrSubsampleRatio422},
+		{format.I444, image.YCbCrSubsampleRatio444},
+		{format.YV24, image.YCbCrSubsampleRatio444},
+	}
+	for _, c := range testCases {
+		for _, sz := range []image.Point{{6, 4}, {5, 3}} {
+			c, sz := c, sz
+			t.Run(fmt.Sprintf("%s/%dx%d", c.format, sz.X, sz.Y), func(t *testing.T) {
+				src := newTestImage(sz.X, sz.Y, c.ratio)
+				buf, err := Encode(c.format, src, Colorimetry{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(buf) != FrameSize(c.format, sz.X, sz.Y) {
+					t.Fatalf("encoded %d bytes, expected %d", len(buf), FrameSize(c.format, sz.X, sz.Y))
+				}
+				img, err := Decode(NewRawFrame(c.format, buf, sz.X, sz.Y))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(src, img.Image) {
+					t.Errorf("Wrong round trip result,\nexpected:\n%+v\ngot:\n%+v", src, img.Image)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeRGB(t *testing.T) {
+	src := newTestImage(5, 3, image.YCbCrSubsampleRatio420)
+	for _, cm := range []Colorimetry{{BT601, LimitedRange}, {BT709, LimitedRange}, JPEG} {
+		expected := image.NewRGBA(src.Rect)
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				c := src.YCbCrAt(x, y)
+				r, g, b := cm.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+				expected.SetRGBA(x, y, color.RGBA{r, g, b, 0xff})
+			}
+		}
+		for _, f := range []PixelFormat{format.ARGB, format.BGRA, format.RGBA, format.RAW} {
+			buf, err := Encode(f, src, cm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := Decode(NewRawFrame(f, buf, 5, 3))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(expected, img.Image) {
+				t.Errorf("%s/%v: Wrong round trip result,\nexpected:\n%+v\ngot:\n%+v", f, cm, expected, img.Image)
+			}
+		}
+	}
+}
+
+func TestEncodeSubsample(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio444)
+	copy(src.Y, []byte{0x01, 0x03, 0x05, 0x07})
+	copy(src.Cb, []byte{0x80, 0x82, 0x84, 0x86})
+	copy(src.Cr, []byte{0x90, 0x90, 0x90, 0x91})
+	testCases := []struct {
+		format   PixelFormat
+		expected []byte
+	}{
+		{format.I420, []byte{0x01, 0x03, 0x05, 0x07, 0x83, 0x90}},
+		{format.NV21, []byte{0x01, 0x03, 0x05, 0x07, 0x90, 0x83}},
+		{format.YUY2, []byte{0x01, 0x81, 0x03, 0x90, 0x05, 0x85, 0x07, 0x91}},
+	}
+	for _, c := range testCases {
+		buf, err := Encode(c.format, src, Colorimetry{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c.expected, buf) {
+			t.Errorf("%s: expected % x, got % x", c.format, c.expected, buf)
+		}
+	}
+	if _, err := EncodeTo(make([]byte, 5), format.I420, src, Colorimetry{}); err != ErrInsufficientFrameBuffer {
+		t.Errorf("short buffer: expected %v, got %v", ErrInsufficientFrameBuffer, err)
+	}
+	if _, err := Encode(format.MJPG, src, Colorimetry{}); err == nil {
+		t.Error("expected error for MJPG")
+	}
+}
@@ -2,9 +2,70 @@ package video
 
 import (
 	"image"
+	"time"
 
 	"github.com/zyxar/mediastream/lib/format"
 )
 
 type PixelFormat = format.PixelFormat
-type Frame = image.Image
+
+// Frame is a decoded frame: its image together with what is known about it.
+// A Frame is an image.Image itself, drawing as its Image, so code that wants
+// an image.Image can take one as is; see ImageOf.
+type Frame struct {
+	image.Image
+	Format      PixelFormat // of the raw frame it was decoded from
+	Colorimetry Colorimetry // of a Y'CbCr Image
+	Timing
+	release func()
+}
+
+// NewFrame returns a frame of img, without metadata, whose Release calls
+// release unless it is nil.
+func NewFrame(img image.Image, release func()) Frame {
+	return Frame{Image: img, release: release}
+}
+
+// Release hands the buffers of f back to where they came from, if they are
+// pooled. Neither f nor its copies may be used afterwards.
+func (f Frame) Release() {
+	if f.release != nil {
+		f.release()
+	}
+}
+
+// YCbCr returns the image of f if it is a Y'CbCr image.
+func (f Frame) YCbCr() (*image.YCbCr, bool) {
+	img, ok := f.Image.(*image.YCbCr)
+	return img, ok
+}
+
+// ImageOf returns the image of img if it is a Frame, or img itself.
+func ImageOf(img image.Image) image.Image {
+	switch f := img.(type) {
+	case Frame:
+		return f.Image
+	case *Frame:
+		return f.Image
+	}
+	return img
+}
+
+// TimingOf returns the timing of img if it is a Frame.
+func TimingOf(img image.Image) (Timing, bool) {
+	switch f := img.(type) {
+	case Frame:
+		return f.Timing, true
+	case *Frame:
+		return f.Timing, true
+	}
+	return Timing{}, false
+}
+
+// Timing describes when a frame was captured. PTS is on the clock of the
+// capture source, Sequence counts the frames delivered by it.
+type Timing struct {
+	PTS      time.Duration
+	Duration time.Duration
+	Sequence uint64
+}
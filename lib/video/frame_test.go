@@ -0,0 +1,52 @@
+package video
+
+import (
+	"image"
+	"testing"
+)
+
+func TestImageOf(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	f := NewFrame(img, nil)
+	for _, i := range []image.Image{img, f, &f} {
+		if ImageOf(i) != image.Image(img) {
+			t.Errorf("%T: expected the image of the frame", i)
+		}
+	}
+	if yuv, ok := f.YCbCr(); !ok || yuv != img {
+		t.Error("expected the Y'CbCr image of the frame")
+	}
+	if f.Bounds() != img.Rect {
+		t.Errorf("expected bounds %v, got %v", img.Rect, f.Bounds())
+	}
+	f.Release()
+}
+
+func TestTimingOf(t *testing.T) {
+	img := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	f := Frame{Image: img, Timing: Timing{PTS: 40, Duration: 40, Sequence: 1}}
+	for _, i := range []image.Image{f, &f} {
+		if timing, ok := TimingOf(i); !ok || timing != f.Timing {
+			t.Errorf("%T: expected timing %v, got %v", i, f.Timing, timing)
+		}
+	}
+	if _, ok := TimingOf(img); ok {
+		t.Error("expected no timing of an image")
+	}
+}
+
+func TestFrameRelease(t *testing.T) {
+	released := 0
+	f := NewFrame(image.NewGray(image.Rect(0, 0, 2, 2)), func() { released++ })
+	g := f
+	g.Release()
+	if released != 1 {
+		t.Errorf("expected one release, got %d", released)
+	}
+	var p Pool
+	f = p.Frame(image.Rect(0, 0, 8, 8))
+	if f.Bounds() != image.Rect(0, 0, 8, 8) {
+		t.Errorf("unexpected bounds %v", f.Bounds())
+	}
+	f.Release()
+}
@@ -0,0 +1,143 @@
+package video
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// kernels are the row loops of the decoders and converters. The portable
+// versions are below; kernels_amd64.go installs assembly versions where the
+// CPU supports them, kernels_neon.go those of kernels_arm64.go.
+type kernels struct {
+	name string
+	// unpackYUY2 and unpackUYVY split len(cb) macropixels of src; y is 2*len(cb)
+	// bytes long, or one less for odd widths.
+	unpackYUY2, unpackUYVY func(y, cb, cr, src []byte)
+	// deinterleave splits len(u) pairs of src.
+	deinterleave func(u, v, src []byte)
+	// swapRB, rotateRGBA and reverseRGBA rearrange B,G,R,A, A,R,G,B and
+	// A,B,G,R pixels to R,G,B,A in place.
+	swapRB, rotateRGBA, reverseRGBA func(pix []byte)
+	// average2x2 averages the 2x2 blocks of rows a and b, average2 the
+	// vertical pairs; into len(dst) samples, rounded.
+	average2x2, average2 func(dst, a, b []byte)
+}
+
+var genericKernels = kernels{
+	name:         "generic",
+	unpackYUY2:   unpackYUY2Generic,
+	unpackUYVY:   unpackUYVYGeneric,
+	deinterleave: deinterleaveGeneric,
+	swapRB:       swapRBGeneric,
+	rotateRGBA:   rotateRGBAGeneric,
+	reverseRGBA:  reverseRGBAGeneric,
+	average2x2:   average2x2Generic,
+	average2:     average2Generic,
+}
+
+// kern is the best set of kernels for the CPU.
+var kern = genericKernels
+
+func unpackYUY2Generic(y, cb, cr, src []byte) {
+	for i := range cb {
+		s := src[4*i : 4*i+4]
+		y[2*i], cb[i], cr[i] = s[0], s[1], s[3]
+		if 2*i+1 < len(y) {
+			y[2*i+1] = s[2]
+		}
+	}
+}
+
+func unpackUYVYGeneric(y, cb, cr, src []byte) {
+	for i := range cb {
+		s := src[4*i : 4*i+4]
+		cb[i], y[2*i], cr[i] = s[0], s[1], s[2]
+		if 2*i+1 < len(y) {
+			y[2*i+1] = s[3]
+		}
+	}
+}
+
+func deinterleaveGeneric(u, v, src []byte) {
+	for i := range u {
+		u[i], v[i] = src[2*i], src[2*i+1]
+	}
+}
+
+func swapRBGeneric(pix []byte) {
+	for i := 0; i+4 <= len(pix); i += 4 {
+		p := (*uint32)(unsafe.Pointer(&pix[i]))
+		v := *p
+		*p = (v & 0xFF00FF00) | (v&0xFF)<<16 | (v&0xFF0000)>>16
+	}
+}
+
+func rotateRGBAGeneric(pix []byte) {
+	for i := 0; i+4 <= len(pix); i += 4 {
+		p := (*uint32)(unsafe.Pointer(&pix[i]))
+		*p = bits.RotateLeft32(*p, -8)
+	}
+}
+
+func reverseRGBAGeneric(pix []byte) {
+	for i := 0; i+4 <= len(pix); i += 4 {
+		p := (*uint32)(unsafe.Pointer(&pix[i]))
+		*p = bits.ReverseBytes32(*p)
+	}
+}
+
+func average2x2Generic(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = uint8((int(a[2*i]) + int(a[2*i+1]) + int(b[2*i]) + int(b[2*i+1]) + 2) >> 2)
+	}
+}
+
+func average2Generic(dst, a, b []byte) {
+	for i := range dst {
+		dst[i] = uint8((int(a[i]) + int(b[i]) + 1) >> 1)
+	}
+}
+
+// The assembly kernels handle whole blocks of n samples only; these wrap
+// them to leave the rest to the portable versions.
+
+func blocked422(asm, generic func(y, cb, cr, src []byte), n int) func(y, cb, cr, src []byte) {
+	return func(y, cb, cr, src []byte) {
+		k := len(y) / 2 / n * n
+		if k > 0 {
+			asm(y[:2*k], cb[:k], cr[:k], src[:4*k])
+		}
+		generic(y[2*k:], cb[k:], cr[k:], src[4*k:])
+	}
+}
+
+func blockedSplit(asm, generic func(u, v, src []byte), n int) func(u, v, src []byte) {
+	return func(u, v, src []byte) {
+		k := len(u) / n * n
+		if k > 0 {
+			asm(u[:k], v[:k], src[:2*k])
+		}
+		generic(u[k:], v[k:], src[2*k:])
+	}
+}
+
+func blockedInPlace(asm, generic func(pix []byte), n int) func(pix []byte) {
+	return func(pix []byte) {
+		k := len(pix) / n * n
+		if k > 0 {
+			asm(pix[:k])
+		}
+		generic(pix[k:])
+	}
+}
+
+// blockedAverage wraps a kernel reading w samples of a and b per output.
+func blockedAverage(asm, generic func(dst, a, b []byte), n, w int) func(dst, a, b []byte) {
+	return func(dst, a, b []byte) {
+		k := len(dst) / n * n
+		if k > 0 {
+			asm(dst[:k], a[:w*k], b[:w*k])
+		}
+		generic(dst[k:], a[w*k:], b[w*k:])
+	}
+}
@@ -0,0 +1,101 @@
+package video
+
+// SSE2 is part of amd64; AVX2 is used where the CPU and OS support it.
+
+//go:noescape
+func unpackYUY2SSE2(y, cb, cr, src []byte)
+
+//go:noescape
+func unpackUYVYSSE2(y, cb, cr, src []byte)
+
+//go:noescape
+func deinterleaveSSE2(u, v, src []byte)
+
+//go:noescape
+func swapRBSSE2(pix []byte)
+
+//go:noescape
+func rotateRGBASSE2(pix []byte)
+
+//go:noescape
+func reverseRGBASSE2(pix []byte)
+
+//go:noescape
+func average2x2SSE2(dst, a, b []byte)
+
+//go:noescape
+func average2SSE2(dst, a, b []byte)
+
+//go:noescape
+func unpackYUY2AVX2(y, cb, cr, src []byte)
+
+//go:noescape
+func unpackUYVYAVX2(y, cb, cr, src []byte)
+
+//go:noescape
+func deinterleaveAVX2(u, v, src []byte)
+
+//go:noescape
+func swapRBAVX2(pix []byte)
+
+//go:noescape
+func rotateRGBAAVX2(pix []byte)
+
+//go:noescape
+func reverseRGBAAVX2(pix []byte)
+
+//go:noescape
+func average2x2AVX2(dst, a, b []byte)
+
+//go:noescape
+func average2AVX2(dst, a, b []byte)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+func xgetbv() (eax, edx uint32)
+
+func hasAVX2() bool {
+	if max, _, _, _ := cpuid(0, 0); max < 7 {
+		return false
+	}
+	const osxsave, avx = 1 << 27, 1 << 28
+	if _, _, ecx, _ := cpuid(1, 0); ecx&(osxsave|avx) != osxsave|avx {
+		return false
+	}
+	// the OS saves the XMM and YMM registers
+	if eax, _ := xgetbv(); eax&6 != 6 {
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	return ebx&(1<<5) != 0
+}
+
+// archKernels lists the kernels the CPU supports, best last.
+var archKernels = func() []kernels {
+	k := []kernels{{
+		name:         "sse2",
+		unpackYUY2:   blocked422(unpackYUY2SSE2, unpackYUY2Generic, 16),
+		unpackUYVY:   blocked422(unpackUYVYSSE2, unpackUYVYGeneric, 16),
+		deinterleave: blockedSplit(deinterleaveSSE2, deinterleaveGeneric, 16),
+		swapRB:       blockedInPlace(swapRBSSE2, swapRBGeneric, 16),
+		rotateRGBA:   blockedInPlace(rotateRGBASSE2, rotateRGBAGeneric, 16),
+		reverseRGBA:  blockedInPlace(reverseRGBASSE2, reverseRGBAGeneric, 16),
+		average2x2:   blockedAverage(average2x2SSE2, average2x2Generic, 16, 2),
+		average2:     blockedAverage(average2SSE2, average2Generic, 16, 1),
+	}}
+	if hasAVX2() {
+		k = append(k, kernels{
+			name:         "avx2",
+			unpackYUY2:   blocked422(unpackYUY2AVX2, unpackYUY2Generic, 32),
+			unpackUYVY:   blocked422(unpackUYVYAVX2, unpackUYVYGeneric, 32),
+			deinterleave: blockedSplit(deinterleaveAVX2, deinterleaveGeneric, 32),
+			swapRB:       blockedInPlace(swapRBAVX2, swapRBGeneric, 32),
+			rotateRGBA:   blockedInPlace(rotateRGBAAVX2, rotateRGBAGeneric, 32),
+			reverseRGBA:  blockedInPlace(reverseRGBAAVX2, reverseRGBAGeneric, 32),
+			average2x2:   blockedAverage(average2x2AVX2, average2x2Generic, 32, 2),
+			average2:     blockedAverage(average2AVX2, average2Generic, 32, 1),
+		})
+	}
+	return k
+}()
+
+func init() { kern = archKernels[len(archKernels)-1] }
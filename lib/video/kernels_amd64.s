@@ -0,0 +1,638 @@
+#include "textflag.h"
+
+// The kernels process whole blocks only, see kernels.go. PACKUSWB narrows
+// words to bytes; on YMM registers it packs within 128-bit lanes, which
+// VPERMQ $0xD8 puts back in order.
+
+// func unpackYUY2SSE2(y, cb, cr, src []byte)
+TEXT ·unpackYUY2SSE2(SB), NOSPLIT, $0-96
+	MOVQ y_base+0(FP), DI
+	MOVQ cb_base+24(FP), R8
+	MOVQ cb_len+32(FP), CX
+	MOVQ cr_base+48(FP), R9
+	MOVQ src_base+72(FP), SI
+	SHRQ $4, CX
+	JZ   yuy2sse2done
+	PCMPEQW X8, X8
+	PSRLW   $8, X8 // 0x00ff in each word
+
+yuy2sse2loop:
+	MOVOU 0(SI), X0
+	MOVOU 16(SI), X1
+	MOVOU 32(SI), X2
+	MOVOU 48(SI), X3
+
+	// luma in the even bytes
+	MOVO     X0, X4
+	PAND     X8, X4
+	MOVO     X1, X5
+	PAND     X8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 0(DI)
+	MOVO     X2, X4
+	PAND     X8, X4
+	MOVO     X3, X5
+	PAND     X8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 16(DI)
+
+	// chroma in the odd bytes, Cb and Cr alternating
+	PSRLW    $8, X0
+	PSRLW    $8, X1
+	PACKUSWB X1, X0
+	PSRLW    $8, X2
+	PSRLW    $8, X3
+	PACKUSWB X3, X2
+	MOVO     X0, X4
+	PAND     X8, X4
+	MOVO     X2, X5
+	PAND     X8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 0(R8)
+	PSRLW    $8, X0
+	PSRLW    $8, X2
+	PACKUSWB X2, X0
+	MOVOU    X0, 0(R9)
+
+	ADDQ $64, SI
+	ADDQ $32, DI
+	ADDQ $16, R8
+	ADDQ $16, R9
+	DECQ CX
+	JNZ  yuy2sse2loop
+
+yuy2sse2done:
+	RET
+
+// func unpackUYVYSSE2(y, cb, cr, src []byte)
+TEXT ·unpackUYVYSSE2(SB), NOSPLIT, $0-96
+	MOVQ y_base+0(FP), DI
+	MOVQ cb_base+24(FP), R8
+	MOVQ cb_len+32(FP), CX
+	MOVQ cr_base+48(FP), R9
+	MOVQ src_base+72(FP), SI
+	SHRQ $4, CX
+	JZ   uyvysse2done
+	PCMPEQW X8, X8
+	PSRLW   $8, X8
+
+uyvysse2loop:
+	MOVOU 0(SI), X0
+	MOVOU 16(SI), X1
+	MOVOU 32(SI), X2
+	MOVOU 48(SI), X3
+
+	// luma in the odd bytes
+	MOVO     X0, X4
+	PSRLW    $8, X4
+	MOVO     X1, X5
+	PSRLW    $8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 0(DI)
+	MOVO     X2, X4
+	PSRLW    $8, X4
+	MOVO     X3, X5
+	PSRLW    $8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 16(DI)
+
+	// chroma in the even bytes, Cb and Cr alternating
+	PAND     X8, X0
+	PAND     X8, X1
+	PACKUSWB X1, X0
+	PAND     X8, X2
+	PAND     X8, X3
+	PACKUSWB X3, X2
+	MOVO     X0, X4
+	PAND     X8, X4
+	MOVO     X2, X5
+	PAND     X8, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 0(R8)
+	PSRLW    $8, X0
+	PSRLW    $8, X2
+	PACKUSWB X2, X0
+	MOVOU    X0, 0(R9)
+
+	ADDQ $64, SI
+	ADDQ $32, DI
+	ADDQ $16, R8
+	ADDQ $16, R9
+	DECQ CX
+	JNZ  uyvysse2loop
+
+uyvysse2done:
+	RET
+
+// func deinterleaveSSE2(u, v, src []byte)
+TEXT ·deinterleaveSSE2(SB), NOSPLIT, $0-72
+	MOVQ u_base+0(FP), DI
+	MOVQ u_len+8(FP), CX
+	MOVQ v_base+24(FP), R8
+	MOVQ src_base+48(FP), SI
+	SHRQ $4, CX
+	JZ   splitsse2done
+	PCMPEQW X8, X8
+	PSRLW   $8, X8
+
+splitsse2loop:
+	MOVOU    0(SI), X0
+	MOVOU    16(SI), X1
+	MOVO     X0, X2
+	PAND     X8, X2
+	MOVO     X1, X3
+	PAND     X8, X3
+	PACKUSWB X3, X2
+	MOVOU    X2, 0(DI)
+	PSRLW    $8, X0
+	PSRLW    $8, X1
+	PACKUSWB X1, X0
+	MOVOU    X0, 0(R8)
+
+	ADDQ $32, SI
+	ADDQ $16, DI
+	ADDQ $16, R8
+	DECQ CX
+	JNZ  splitsse2loop
+
+splitsse2done:
+	RET
+
+// func swapRBSSE2(pix []byte)
+TEXT ·swapRBSSE2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $4, CX
+	JZ   swapsse2done
+	PCMPEQL X11, X11
+	PSRLL   $24, X11 // 0x000000ff
+	MOVO    X11, X10
+	PSLLL   $16, X10 // 0x00ff0000
+	PCMPEQL X9, X9
+	PXOR    X10, X9
+	PXOR    X11, X9  // 0xff00ff00
+
+swapsse2loop:
+	MOVOU (DI), X0
+	MOVO  X0, X1
+	PSLLL $16, X1
+	PAND  X10, X1
+	MOVO  X0, X2
+	PSRLL $16, X2
+	PAND  X11, X2
+	PAND  X9, X0
+	POR   X1, X0
+	POR   X2, X0
+	MOVOU X0, (DI)
+	ADDQ  $16, DI
+	DECQ  CX
+	JNZ   swapsse2loop
+
+swapsse2done:
+	RET
+
+// func rotateRGBASSE2(pix []byte)
+TEXT ·rotateRGBASSE2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $4, CX
+	JZ   rotatesse2done
+
+rotatesse2loop:
+	MOVOU (DI), X0
+	MOVO  X0, X1
+	PSRLL $8, X1
+	PSLLL $24, X0
+	POR   X1, X0
+	MOVOU X0, (DI)
+	ADDQ  $16, DI
+	DECQ  CX
+	JNZ   rotatesse2loop
+
+rotatesse2done:
+	RET
+
+// func reverseRGBASSE2(pix []byte)
+TEXT ·reverseRGBASSE2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $4, CX
+	JZ   reversesse2done
+
+reversesse2loop:
+	MOVOU   (DI), X0
+	MOVO    X0, X1
+	PSRLW   $8, X1
+	PSLLW   $8, X0
+	POR     X1, X0        // bytes swapped within words
+	PSHUFLW $0xb1, X0, X0 // and words within double words
+	PSHUFHW $0xb1, X0, X0
+	MOVOU   X0, (DI)
+	ADDQ    $16, DI
+	DECQ    CX
+	JNZ     reversesse2loop
+
+reversesse2done:
+	RET
+
+// func average2x2SSE2(dst, a, b []byte)
+TEXT ·average2x2SSE2(SB), NOSPLIT, $0-72
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), CX
+	MOVQ a_base+24(FP), SI
+	MOVQ b_base+48(FP), DX
+	SHRQ $4, CX
+	JZ   avg4sse2done
+	PCMPEQW X8, X8
+	PSRLW   $8, X8
+	PCMPEQW X9, X9
+	PSRLW   $15, X9
+	PSLLW   $1, X9 // 2 in each word, for rounding
+
+avg4sse2loop:
+	MOVOU 0(SI), X0
+	MOVOU 16(SI), X1
+	MOVOU 0(DX), X2
+	MOVOU 16(DX), X3
+
+	// sums of horizontal pairs as words
+	MOVO  X0, X4
+	PAND  X8, X4
+	PSRLW $8, X0
+	PADDW X0, X4
+	MOVO  X1, X5
+	PAND  X8, X5
+	PSRLW $8, X1
+	PADDW X1, X5
+	MOVO  X2, X6
+	PAND  X8, X6
+	PSRLW $8, X2
+	PADDW X2, X6
+	MOVO  X3, X7
+	PAND  X8, X7
+	PSRLW $8, X3
+	PADDW X3, X7
+
+	PADDW    X6, X4
+	PADDW    X7, X5
+	PADDW    X9, X4
+	PADDW    X9, X5
+	PSRLW    $2, X4
+	PSRLW    $2, X5
+	PACKUSWB X5, X4
+	MOVOU    X4, 0(DI)
+
+	ADDQ $32, SI
+	ADDQ $32, DX
+	ADDQ $16, DI
+	DECQ CX
+	JNZ  avg4sse2loop
+
+avg4sse2done:
+	RET
+
+// func average2SSE2(dst, a, b []byte)
+TEXT ·average2SSE2(SB), NOSPLIT, $0-72
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), CX
+	MOVQ a_base+24(FP), SI
+	MOVQ b_base+48(FP), DX
+	SHRQ $4, CX
+	JZ   avg2sse2done
+
+avg2sse2loop:
+	MOVOU (SI), X0
+	MOVOU (DX), X1
+	PAVGB X1, X0
+	MOVOU X0, (DI)
+	ADDQ  $16, SI
+	ADDQ  $16, DX
+	ADDQ  $16, DI
+	DECQ  CX
+	JNZ   avg2sse2loop
+
+avg2sse2done:
+	RET
+
+// func unpackYUY2AVX2(y, cb, cr, src []byte)
+TEXT ·unpackYUY2AVX2(SB), NOSPLIT, $0-96
+	MOVQ y_base+0(FP), DI
+	MOVQ cb_base+24(FP), R8
+	MOVQ cb_len+32(FP), CX
+	MOVQ cr_base+48(FP), R9
+	MOVQ src_base+72(FP), SI
+	SHRQ $5, CX
+	JZ   yuy2avx2done
+	VPCMPEQW Y8, Y8, Y8
+	VPSRLW   $8, Y8, Y8
+
+yuy2avx2loop:
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	VMOVDQU 64(SI), Y2
+	VMOVDQU 96(SI), Y3
+
+	VPAND     Y8, Y0, Y4
+	VPAND     Y8, Y1, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 0(DI)
+	VPAND     Y8, Y2, Y4
+	VPAND     Y8, Y3, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 32(DI)
+
+	VPSRLW    $8, Y0, Y0
+	VPSRLW    $8, Y1, Y1
+	VPACKUSWB Y1, Y0, Y0
+	VPERMQ    $0xd8, Y0, Y0
+	VPSRLW    $8, Y2, Y2
+	VPSRLW    $8, Y3, Y3
+	VPACKUSWB Y3, Y2, Y2
+	VPERMQ    $0xd8, Y2, Y2
+	VPAND     Y8, Y0, Y4
+	VPAND     Y8, Y2, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 0(R8)
+	VPSRLW    $8, Y0, Y0
+	VPSRLW    $8, Y2, Y2
+	VPACKUSWB Y2, Y0, Y0
+	VPERMQ    $0xd8, Y0, Y0
+	VMOVDQU   Y0, 0(R9)
+
+	ADDQ $128, SI
+	ADDQ $64, DI
+	ADDQ $32, R8
+	ADDQ $32, R9
+	DECQ CX
+	JNZ  yuy2avx2loop
+	VZEROUPPER
+
+yuy2avx2done:
+	RET
+
+// func unpackUYVYAVX2(y, cb, cr, src []byte)
+TEXT ·unpackUYVYAVX2(SB), NOSPLIT, $0-96
+	MOVQ y_base+0(FP), DI
+	MOVQ cb_base+24(FP), R8
+	MOVQ cb_len+32(FP), CX
+	MOVQ cr_base+48(FP), R9
+	MOVQ src_base+72(FP), SI
+	SHRQ $5, CX
+	JZ   uyvyavx2done
+	VPCMPEQW Y8, Y8, Y8
+	VPSRLW   $8, Y8, Y8
+
+uyvyavx2loop:
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	VMOVDQU 64(SI), Y2
+	VMOVDQU 96(SI), Y3
+
+	VPSRLW    $8, Y0, Y4
+	VPSRLW    $8, Y1, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 0(DI)
+	VPSRLW    $8, Y2, Y4
+	VPSRLW    $8, Y3, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 32(DI)
+
+	VPAND     Y8, Y0, Y0
+	VPAND     Y8, Y1, Y1
+	VPACKUSWB Y1, Y0, Y0
+	VPERMQ    $0xd8, Y0, Y0
+	VPAND     Y8, Y2, Y2
+	VPAND     Y8, Y3, Y3
+	VPACKUSWB Y3, Y2, Y2
+	VPERMQ    $0xd8, Y2, Y2
+	VPAND     Y8, Y0, Y4
+	VPAND     Y8, Y2, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 0(R8)
+	VPSRLW    $8, Y0, Y0
+	VPSRLW    $8, Y2, Y2
+	VPACKUSWB Y2, Y0, Y0
+	VPERMQ    $0xd8, Y0, Y0
+	VMOVDQU   Y0, 0(R9)
+
+	ADDQ $128, SI
+	ADDQ $64, DI
+	ADDQ $32, R8
+	ADDQ $32, R9
+	DECQ CX
+	JNZ  uyvyavx2loop
+	VZEROUPPER
+
+uyvyavx2done:
+	RET
+
+// func deinterleaveAVX2(u, v, src []byte)
+TEXT ·deinterleaveAVX2(SB), NOSPLIT, $0-72
+	MOVQ u_base+0(FP), DI
+	MOVQ u_len+8(FP), CX
+	MOVQ v_base+24(FP), R8
+	MOVQ src_base+48(FP), SI
+	SHRQ $5, CX
+	JZ   splitavx2done
+	VPCMPEQW Y8, Y8, Y8
+	VPSRLW   $8, Y8, Y8
+
+splitavx2loop:
+	VMOVDQU   0(SI), Y0
+	VMOVDQU   32(SI), Y1
+	VPAND     Y8, Y0, Y2
+	VPAND     Y8, Y1, Y3
+	VPACKUSWB Y3, Y2, Y2
+	VPERMQ    $0xd8, Y2, Y2
+	VMOVDQU   Y2, 0(DI)
+	VPSRLW    $8, Y0, Y0
+	VPSRLW    $8, Y1, Y1
+	VPACKUSWB Y1, Y0, Y0
+	VPERMQ    $0xd8, Y0, Y0
+	VMOVDQU   Y0, 0(R8)
+
+	ADDQ $64, SI
+	ADDQ $32, DI
+	ADDQ $32, R8
+	DECQ CX
+	JNZ  splitavx2loop
+	VZEROUPPER
+
+splitavx2done:
+	RET
+
+// func swapRBAVX2(pix []byte)
+TEXT ·swapRBAVX2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $5, CX
+	JZ   swapavx2done
+	VPCMPEQD Y11, Y11, Y11
+	VPSRLD   $24, Y11, Y11
+	VPSLLD   $16, Y11, Y10
+	VPCMPEQD Y9, Y9, Y9
+	VPXOR    Y10, Y9, Y9
+	VPXOR    Y11, Y9, Y9
+
+swapavx2loop:
+	VMOVDQU (DI), Y0
+	VPSLLD  $16, Y0, Y1
+	VPAND   Y10, Y1, Y1
+	VPSRLD  $16, Y0, Y2
+	VPAND   Y11, Y2, Y2
+	VPAND   Y9, Y0, Y0
+	VPOR    Y1, Y0, Y0
+	VPOR    Y2, Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ    $32, DI
+	DECQ    CX
+	JNZ     swapavx2loop
+	VZEROUPPER
+
+swapavx2done:
+	RET
+
+// func rotateRGBAAVX2(pix []byte)
+TEXT ·rotateRGBAAVX2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $5, CX
+	JZ   rotateavx2done
+
+rotateavx2loop:
+	VMOVDQU (DI), Y0
+	VPSRLD  $8, Y0, Y1
+	VPSLLD  $24, Y0, Y0
+	VPOR    Y1, Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ    $32, DI
+	DECQ    CX
+	JNZ     rotateavx2loop
+	VZEROUPPER
+
+rotateavx2done:
+	RET
+
+// func reverseRGBAAVX2(pix []byte)
+TEXT ·reverseRGBAAVX2(SB), NOSPLIT, $0-24
+	MOVQ pix_base+0(FP), DI
+	MOVQ pix_len+8(FP), CX
+	SHRQ $5, CX
+	JZ   reverseavx2done
+
+reverseavx2loop:
+	VMOVDQU  (DI), Y0
+	VPSRLW   $8, Y0, Y1
+	VPSLLW   $8, Y0, Y0
+	VPOR     Y1, Y0, Y0
+	VPSHUFLW $0xb1, Y0, Y0
+	VPSHUFHW $0xb1, Y0, Y0
+	VMOVDQU  Y0, (DI)
+	ADDQ     $32, DI
+	DECQ     CX
+	JNZ      reverseavx2loop
+	VZEROUPPER
+
+reverseavx2done:
+	RET
+
+// func average2x2AVX2(dst, a, b []byte)
+TEXT ·average2x2AVX2(SB), NOSPLIT, $0-72
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), CX
+	MOVQ a_base+24(FP), SI
+	MOVQ b_base+48(FP), DX
+	SHRQ $5, CX
+	JZ   avg4avx2done
+	VPCMPEQW Y8, Y8, Y8
+	VPSRLW   $8, Y8, Y8
+	VPCMPEQW Y9, Y9, Y9
+	VPSRLW   $15, Y9, Y9
+	VPSLLW   $1, Y9, Y9
+
+avg4avx2loop:
+	VMOVDQU 0(SI), Y0
+	VMOVDQU 32(SI), Y1
+	VMOVDQU 0(DX), Y2
+	VMOVDQU 32(DX), Y3
+
+	VPAND  Y8, Y0, Y4
+	VPSRLW $8, Y0, Y0
+	VPADDW Y0, Y4, Y4
+	VPAND  Y8, Y1, Y5
+	VPSRLW $8, Y1, Y1
+	VPADDW Y1, Y5, Y5
+	VPAND  Y8, Y2, Y6
+	VPSRLW $8, Y2, Y2
+	VPADDW Y2, Y6, Y6
+	VPAND  Y8, Y3, Y7
+	VPSRLW $8, Y3, Y3
+	VPADDW Y3, Y7, Y7
+
+	VPADDW    Y6, Y4, Y4
+	VPADDW    Y7, Y5, Y5
+	VPADDW    Y9, Y4, Y4
+	VPADDW    Y9, Y5, Y5
+	VPSRLW    $2, Y4, Y4
+	VPSRLW    $2, Y5, Y5
+	VPACKUSWB Y5, Y4, Y4
+	VPERMQ    $0xd8, Y4, Y4
+	VMOVDQU   Y4, 0(DI)
+
+	ADDQ $64, SI
+	ADDQ $64, DX
+	ADDQ $32, DI
+	DECQ CX
+	JNZ  avg4avx2loop
+	VZEROUPPER
+
+avg4avx2done:
+	RET
+
+// func average2AVX2(dst, a, b []byte)
+TEXT ·average2AVX2(SB), NOSPLIT, $0-72
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), CX
+	MOVQ a_base+24(FP), SI
+	MOVQ b_base+48(FP), DX
+	SHRQ $5, CX
+	JZ   avg2avx2done
+
+avg2avx2loop:
+	VMOVDQU (SI), Y0
+	VPAVGB  (DX), Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ    $32, SI
+	ADDQ    $32, DX
+	ADDQ    $32, DI
+	DECQ    CX
+	JNZ     avg2avx2loop
+	VZEROUPPER
+
+avg2avx2done:
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
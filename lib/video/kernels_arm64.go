@@ -0,0 +1,42 @@
+package video
+
+// NEON is part of arm64.
+
+//go:noescape
+func unpackYUY2NEON(y, cb, cr, src []byte)
+
+//go:noescape
+func unpackUYVYNEON(y, cb, cr, src []byte)
+
+//go:noescape
+func deinterleaveNEON(u, v, src []byte)
+
+//go:noescape
+func swapRBNEON(pix []byte)
+
+//go:noescape
+func rotateRGBANEON(pix []byte)
+
+//go:noescape
+func reverseRGBANEON(pix []byte)
+
+//go:noescape
+func average2x2NEON(dst, a, b []byte)
+
+//go:noescape
+func average2NEON(dst, a, b []byte)
+
+// archKernels lists the kernels the CPU supports, best last. They are
+// tested against the generic kernels, but not used unless built with the
+// neon tag, see kernels_neon.go.
+var archKernels = []kernels{{
+	name:         "neon",
+	unpackYUY2:   blocked422(unpackYUY2NEON, unpackYUY2Generic, 16),
+	unpackUYVY:   blocked422(unpackUYVYNEON, unpackUYVYGeneric, 16),
+	deinterleave: blockedSplit(deinterleaveNEON, deinterleaveGeneric, 16),
+	swapRB:       blockedInPlace(swapRBNEON, swapRBGeneric, 64),
+	rotateRGBA:   blockedInPlace(rotateRGBANEON, rotateRGBAGeneric, 64),
+	reverseRGBA:  blockedInPlace(reverseRGBANEON, reverseRGBAGeneric, 16),
+	average2x2:   blockedAverage(average2x2NEON, average2x2Generic, 16, 2),
+	average2:     blockedAverage(average2NEON, average2Generic, 16, 1),
+}}
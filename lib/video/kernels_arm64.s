@@ -0,0 +1,176 @@
+#include "textflag.h"
+
+// The kernels process whole blocks only, see kernels.go. VLD2 and VLD4
+// deinterleave bytes into registers, VST2 and VST4 interleave them back.
+
+// func unpackYUY2NEON(y, cb, cr, src []byte)
+TEXT ·unpackYUY2NEON(SB), NOSPLIT, $0-96
+	MOVD y_base+0(FP), R0
+	MOVD cb_base+24(FP), R1
+	MOVD cb_len+32(FP), R4
+	MOVD cr_base+48(FP), R2
+	MOVD src_base+72(FP), R3
+	LSR  $4, R4
+	CBZ  R4, yuy2done
+
+yuy2loop:
+	// Y0, Cb, Y1, Cr
+	VLD4.P 64(R3), [V0.B16, V1.B16, V2.B16, V3.B16]
+	VST1.P [V1.B16], 16(R1)
+	VST1.P [V3.B16], 16(R2)
+	VMOV   V2.B16, V1.B16
+	VST2.P [V0.B16, V1.B16], 32(R0)
+	SUB    $1, R4
+	CBNZ   R4, yuy2loop
+
+yuy2done:
+	RET
+
+// func unpackUYVYNEON(y, cb, cr, src []byte)
+TEXT ·unpackUYVYNEON(SB), NOSPLIT, $0-96
+	MOVD y_base+0(FP), R0
+	MOVD cb_base+24(FP), R1
+	MOVD cb_len+32(FP), R4
+	MOVD cr_base+48(FP), R2
+	MOVD src_base+72(FP), R3
+	LSR  $4, R4
+	CBZ  R4, uyvydone
+
+uyvyloop:
+	// Cb, Y0, Cr, Y1
+	VLD4.P 64(R3), [V0.B16, V1.B16, V2.B16, V3.B16]
+	VST1.P [V0.B16], 16(R1)
+	VST1.P [V2.B16], 16(R2)
+	VMOV   V3.B16, V2.B16
+	VST2.P [V1.B16, V2.B16], 32(R0)
+	SUB    $1, R4
+	CBNZ   R4, uyvyloop
+
+uyvydone:
+	RET
+
+// func deinterleaveNEON(u, v, src []byte)
+TEXT ·deinterleaveNEON(SB), NOSPLIT, $0-72
+	MOVD u_base+0(FP), R0
+	MOVD u_len+8(FP), R4
+	MOVD v_base+24(FP), R1
+	MOVD src_base+48(FP), R3
+	LSR  $4, R4
+	CBZ  R4, splitdone
+
+splitloop:
+	VLD2.P 32(R3), [V0.B16, V1.B16]
+	VST1.P [V0.B16], 16(R0)
+	VST1.P [V1.B16], 16(R1)
+	SUB    $1, R4
+	CBNZ   R4, splitloop
+
+splitdone:
+	RET
+
+// func swapRBNEON(pix []byte)
+TEXT ·swapRBNEON(SB), NOSPLIT, $0-24
+	MOVD pix_base+0(FP), R0
+	MOVD pix_len+8(FP), R4
+	LSR  $6, R4
+	CBZ  R4, swapdone
+
+swaploop:
+	// B, G, R, A to R, G, B, A
+	VLD4   (R0), [V0.B16, V1.B16, V2.B16, V3.B16]
+	VMOV   V0.B16, V4.B16
+	VMOV   V2.B16, V0.B16
+	VMOV   V4.B16, V2.B16
+	VST4.P [V0.B16, V1.B16, V2.B16, V3.B16], 64(R0)
+	SUB    $1, R4
+	CBNZ   R4, swaploop
+
+swapdone:
+	RET
+
+// func rotateRGBANEON(pix []byte)
+TEXT ·rotateRGBANEON(SB), NOSPLIT, $0-24
+	MOVD pix_base+0(FP), R0
+	MOVD pix_len+8(FP), R4
+	LSR  $6, R4
+	CBZ  R4, rotatedone
+
+rotateloop:
+	// A, R, G, B to R, G, B, A
+	VLD4   (R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VMOV   V1.B16, V5.B16
+	VST4.P [V2.B16, V3.B16, V4.B16, V5.B16], 64(R0)
+	SUB    $1, R4
+	CBNZ   R4, rotateloop
+
+rotatedone:
+	RET
+
+// func reverseRGBANEON(pix []byte)
+TEXT ·reverseRGBANEON(SB), NOSPLIT, $0-24
+	MOVD pix_base+0(FP), R0
+	MOVD pix_len+8(FP), R4
+	LSR  $4, R4
+	CBZ  R4, reversedone
+
+reverseloop:
+	VLD1   (R0), [V0.B16]
+	VREV32 V0.B16, V0.B16
+	VST1.P [V0.B16], 16(R0)
+	SUB    $1, R4
+	CBNZ   R4, reverseloop
+
+reversedone:
+	RET
+
+// func average2x2NEON(dst, a, b []byte)
+TEXT ·average2x2NEON(SB), NOSPLIT, $0-72
+	MOVD dst_base+0(FP), R0
+	MOVD dst_len+8(FP), R4
+	MOVD a_base+24(FP), R1
+	MOVD b_base+48(FP), R2
+	LSR  $4, R4
+	CBZ  R4, avg4done
+
+avg4loop:
+	// even and odd samples of both rows, summed as halfwords
+	VLD2.P 32(R1), [V0.B16, V1.B16]
+	VLD2.P 32(R2), [V2.B16, V3.B16]
+	VUXTL  V0.B8, V4.H8
+	VUXTL2 V0.B16, V5.H8
+	VUADDW V1.B8, V4.H8, V4.H8
+	VUADDW2 V1.B16, V5.H8, V5.H8
+	VUADDW V2.B8, V4.H8, V4.H8
+	VUADDW2 V2.B16, V5.H8, V5.H8
+	VUADDW V3.B8, V4.H8, V4.H8
+	VUADDW2 V3.B16, V5.H8, V5.H8
+	VSRSHR $2, V4.H8, V4.H8
+	VSRSHR $2, V5.H8, V5.H8
+	VXTN   V4.H8, V6.B8
+	VXTN2  V5.H8, V6.B16
+	VST1.P [V6.B16], 16(R0)
+	SUB    $1, R4
+	CBNZ   R4, avg4loop
+
+avg4done:
+	RET
+
+// func average2NEON(dst, a, b []byte)
+TEXT ·average2NEON(SB), NOSPLIT, $0-72
+	MOVD dst_base+0(FP), R0
+	MOVD dst_len+8(FP), R4
+	MOVD a_base+24(FP), R1
+	MOVD b_base+48(FP), R2
+	LSR  $4, R4
+	CBZ  R4, avg2done
+
+avg2loop:
+	VLD1.P  16(R1), [V0.B16]
+	VLD1.P  16(R2), [V1.B16]
+	VURHADD V1.B16, V0.B16, V2.B16
+	VST1.P  [V2.B16], 16(R0)
+	SUB     $1, R4
+	CBNZ    R4, avg2loop
+
+avg2done:
+	RET
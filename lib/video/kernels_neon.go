@@ -0,0 +1,7 @@
+// +build arm64,neon
+
+package video
+
+// The NEON kernels have yet to run on arm64 hardware in CI; until they do,
+// decoders and converters use them only when built with -tags neon.
+func init() { kern = archKernels[len(archKernels)-1] }
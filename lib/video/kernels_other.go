@@ -0,0 +1,6 @@
+// +build !amd64,!arm64
+
+package video
+
+// archKernels lists the kernels the CPU supports, best last.
+var archKernels []kernels
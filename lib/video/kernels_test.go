@@ -0,0 +1,120 @@
+package video
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
+
+// TestKernels checks the assembly kernels against the portable ones, for
+// lengths around their block sizes and at unaligned addresses.
+func TestKernels(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, k := range archKernels {
+		for n := 0; n <= 80; n++ {
+			off := n % 7
+			src := randomBytes(r, off+8*n+4)[off:]
+
+			for _, f := range []struct {
+				name       string
+				got, wants func(y, cb, cr, src []byte)
+			}{
+				{"unpackYUY2", k.unpackYUY2, unpackYUY2Generic},
+				{"unpackUYVY", k.unpackUYVY, unpackUYVYGeneric},
+			} {
+				for _, w := range []int{2 * n, 2*n - 1} {
+					if w < 0 {
+						continue
+					}
+					cw := (w + 1) / 2
+					y0, cb0, cr0 := make([]byte, w), make([]byte, cw), make([]byte, cw)
+					y1, cb1, cr1 := make([]byte, w), make([]byte, cw), make([]byte, cw)
+					f.wants(y0, cb0, cr0, src[:4*cw])
+					f.got(y1, cb1, cr1, src[:4*cw])
+					if !bytes.Equal(y0, y1) || !bytes.Equal(cb0, cb1) || !bytes.Equal(cr0, cr1) {
+						t.Errorf("%s %s, width %d: mismatch", k.name, f.name, w)
+					}
+				}
+			}
+
+			u0, v0, u1, v1 := make([]byte, n), make([]byte, n), make([]byte, n), make([]byte, n)
+			deinterleaveGeneric(u0, v0, src[:2*n])
+			k.deinterleave(u1, v1, src[:2*n])
+			if !bytes.Equal(u0, u1) || !bytes.Equal(v0, v1) {
+				t.Errorf("%s deinterleave, length %d: mismatch", k.name, n)
+			}
+
+			for _, f := range []struct {
+				name       string
+				got, wants func(pix []byte)
+			}{
+				{"swapRB", k.swapRB, swapRBGeneric},
+				{"rotateRGBA", k.rotateRGBA, rotateRGBAGeneric},
+				{"reverseRGBA", k.reverseRGBA, reverseRGBAGeneric},
+			} {
+				p0, p1 := append([]byte(nil), src[:4*n]...), append([]byte(nil), src[:4*n]...)
+				f.wants(p0)
+				f.got(p1)
+				if !bytes.Equal(p0, p1) {
+					t.Errorf("%s %s, %d pixels: mismatch", k.name, f.name, n)
+				}
+			}
+
+			for _, f := range []struct {
+				name       string
+				got, wants func(dst, a, b []byte)
+				w          int
+			}{
+				{"average2x2", k.average2x2, average2x2Generic, 2},
+				{"average2", k.average2, average2Generic, 1},
+			} {
+				a, b := src[:f.w*n], src[len(src)-f.w*n:]
+				d0, d1 := make([]byte, n), make([]byte, n)
+				f.wants(d0, a, b)
+				f.got(d1, a, b)
+				if !bytes.Equal(d0, d1) {
+					t.Errorf("%s %s, length %d: mismatch", k.name, f.name, n)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkKernels(b *testing.B) {
+	const n = 1920
+	src := make([]byte, 8*n)
+	dst := make([]byte, 4*n)
+	for _, k := range append([]kernels{genericKernels}, archKernels...) {
+		k := k
+		b.Run(k.name+"/unpackYUY2", func(b *testing.B) {
+			b.SetBytes(4 * n)
+			for i := 0; i < b.N; i++ {
+				k.unpackYUY2(dst[:2*n], dst[2*n:3*n], dst[3*n:], src[:4*n])
+			}
+		})
+		b.Run(k.name+"/deinterleave", func(b *testing.B) {
+			b.SetBytes(2 * n)
+			for i := 0; i < b.N; i++ {
+				k.deinterleave(dst[:n], dst[n:2*n], src[:2*n])
+			}
+		})
+		b.Run(k.name+"/swapRB", func(b *testing.B) {
+			b.SetBytes(4 * n)
+			for i := 0; i < b.N; i++ {
+				k.swapRB(dst[:4*n])
+			}
+		})
+		b.Run(k.name+"/average2x2", func(b *testing.B) {
+			b.SetBytes(4 * n)
+			for i := 0; i < b.N; i++ {
+				k.average2x2(dst[:n], src[:2*n], src[2*n:4*n])
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package video
+
+import (
+	"image"
+	"sync"
+)
+
+// Pool recycles 4:2:0 images, e.g. for frames handed from a capture loop to
+// other goroutines. Images of any size can be taken from the same pool;
+// their buffers are reused where they are large enough. The zero value is
+// ready to use.
+type Pool struct {
+	p sync.Pool
+}
+
+// Get returns a 4:2:0 image with bounds r. Its samples are not cleared.
+func (p *Pool) Get(r image.Rectangle) *image.YCbCr {
+	img, _ := p.p.Get().(*image.YCbCr)
+	if img == nil {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	}
+	reuseYCbCr(img, r, image.YCbCrSubsampleRatio420)
+	return img
+}
+
+// Put returns img to the pool; it must not be used afterwards.
+func (p *Pool) Put(img *image.YCbCr) {
+	if img != nil {
+		p.p.Put(img)
+	}
+}
+
+// Frame returns a frame of an image from Get, which goes back to p when the
+// frame is released.
+func (p *Pool) Frame(r image.Rectangle) Frame {
+	img := p.Get(r)
+	return NewFrame(img, func() { p.Put(img) })
+}
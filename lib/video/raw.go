@@ -0,0 +1,91 @@
+package video
+
+import (
+	"errors"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+var ErrInvalidPlanes = errors.New("invalid frame planes")
+
+// Plane locates one plane of a raw frame inside its buffer. Stride is the
+// distance in bytes between the starts of two consecutive rows, which may
+// include padding.
+type Plane struct {
+	Offset int
+	Stride int
+}
+
+// RawFrame describes a frame buffer as delivered by a capture source. Planes
+// are listed in the order the pixel format stores them, e.g. Y, V, U for
+// YV12. Colorimetry applies to Y'CbCr formats; Colorimetry and Timing are
+// carried over to decoded frames.
+type RawFrame struct {
+	Format      PixelFormat
+	Width       int
+	Height      int
+	Data        []byte
+	Planes      []Plane
+	Colorimetry Colorimetry
+	Timing      Timing
+}
+
+// NewRawFrame describes buf as a tightly packed frame.
+func NewRawFrame(f PixelFormat, buf []byte, width, height int) RawFrame {
+	return RawFrame{
+		Format: f,
+		Width:  width,
+		Height: height,
+		Data:   buf,
+		Planes: Planes(f, width, height, 0),
+	}
+}
+
+// Planes lays out the planes of a frame stored contiguously, with stride
+// bytes per row of the first plane. The strides of chroma planes follow
+// from it as they do for V4L2 single-planar buffers. A stride of 0 means
+// rows are tightly packed. Planes returns nil for unknown formats.
+func Planes(f PixelFormat, width, height, stride int) []Plane {
+	d, ok := f.Descriptor()
+	if !ok || d.Planes == 0 {
+		return nil
+	}
+	planes := make([]Plane, d.Planes)
+	offset := 0
+	for i := range planes {
+		rowBytes, rows := d.PlaneSize(i, width, height)
+		s := rowBytes
+		if stride > 0 {
+			s = stride
+			if i > 0 && d.Layout == format.Planar {
+				s = (stride + d.SubX - 1) / d.SubX
+			}
+			if i > 0 && s < rowBytes {
+				s = rowBytes
+			}
+		}
+		planes[i] = Plane{offset, s}
+		offset += s * rows
+	}
+	return planes
+}
+
+// plane returns the bytes of plane i, which holds rows of rowBytes each,
+// together with its stride.
+func (f RawFrame) plane(i, rowBytes, rows int) ([]byte, int, error) {
+	if i >= len(f.Planes) {
+		return nil, 0, ErrInvalidPlanes
+	}
+	p := f.Planes[i]
+	if p.Offset < 0 || p.Stride < rowBytes {
+		return nil, 0, ErrInvalidPlanes
+	}
+	if rows == 0 {
+		return nil, p.Stride, nil
+	}
+	end := p.Offset + p.Stride*(rows-1) + rowBytes
+	if end > len(f.Data) {
+		return nil, 0, ErrInsufficientFrameBuffer
+	}
+	return f.Data[p.Offset:end:end], p.Stride, nil
+}
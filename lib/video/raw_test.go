@@ -0,0 +1,81 @@
+package video
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+// pad copies the packed frame f into a buffer whose first plane has stride
+// bytes per row, laid out as a capture driver would.
+func pad(f RawFrame, stride int) RawFrame {
+	planes := Planes(f.Format, f.Width, f.Height, stride)
+	size := len(f.Data)
+	if n := len(planes); n > 0 {
+		last := f.Planes[n-1]
+		size = planes[n-1].Offset + planes[n-1].Stride*(len(f.Data)-last.Offset)/last.Stride
+	}
+	buf := make([]byte, size)
+	for i, p := range f.Planes {
+		end := len(f.Data)
+		if i+1 < len(f.Planes) {
+			end = f.Planes[i+1].Offset
+		}
+		for row := 0; p.Offset+row*p.Stride < end; row++ {
+			src := f.Data[p.Offset+row*p.Stride : p.Offset+(row+1)*p.Stride]
+			copy(buf[planes[i].Offset+row*planes[i].Stride:], src)
+		}
+	}
+	return RawFrame{Format: f.Format, Width: f.Width, Height: f.Height, Data: buf, Planes: planes}
+}
+
+func TestDecodePadded(t *testing.T) {
+	testCases := []struct {
+		format        PixelFormat
+		width, height int
+		size          int
+		stride        int
+	}{
+		{format.I420, 6, 4, 6*4 + 2*3*2, 16},
+		{format.I420, 5, 3, 5*3 + 2*3*2, 16},
+		{format.I444, 6, 4, 3 * 6 * 4, 8},
+		{format.NV12, 6, 4, 6*4 + 6*2, 16},
+		{format.NV21, 5, 3, 5*3 + 6*2, 8},
+		{format.YUY2, 6, 4, 2 * 6 * 4, 16},
+		{format.UYVY, 5, 3, 4 * 3 * 3, 16},
+		{format.ARGB, 6, 4, 4 * 6 * 4, 32},
+		{format.BGRA, 6, 4, 4 * 6 * 4, 28},
+	}
+	for _, c := range testCases {
+		c := c
+		t.Run(fmt.Sprintf("%s/%dx%d", c.format, c.width, c.height), func(t *testing.T) {
+			buf := make([]byte, c.size)
+			rand.Read(buf)
+			packed := NewRawFrame(c.format, buf, c.width, c.height)
+			padded := pad(packed, c.stride)
+			expected, err := Decode(packed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := Decode(padded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for y := 0; y < c.height; y++ {
+				for x := 0; x < c.width; x++ {
+					if e, g := expected.At(x, y), img.At(x, y); e != g {
+						t.Fatalf("pixel (%d,%d): expected %v, got %v", x, y, e, g)
+					}
+				}
+			}
+			if _, err := Decode(RawFrame{
+				Format: c.format, Width: c.width, Height: c.height,
+				Data: padded.Data[:len(padded.Data)/2], Planes: padded.Planes,
+			}); err != ErrInsufficientFrameBuffer {
+				t.Errorf("truncated buffer: expected %v, got %v", ErrInsufficientFrameBuffer, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package video
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Filter is the resampling filter of a Scaler.
+type Filter int
+
+const (
+	Nearest  Filter = iota
+	Bilinear        // triangle
+	Bicubic         // Catmull-Rom
+	Area            // averages the source pixels each output pixel covers
+)
+
+func (f Filter) String() string {
+	switch f {
+	case Nearest:
+		return "nearest"
+	case Bilinear:
+		return "bilinear"
+	case Bicubic:
+		return "bicubic"
+	case Area:
+		return "area"
+	}
+	return fmt.Sprintf("Filter(%d)", int(f))
+}
+
+// ChromaSiting is where subsampled chroma samples lie relative to luma.
+type ChromaSiting int
+
+const (
+	// SitingLeft puts chroma on the even luma columns and centres it
+	// vertically between luma rows, as MPEG-2, H.264 and most cameras do.
+	SitingLeft ChromaSiting = iota
+	// SitingCenter centres chroma between luma samples both ways, as JPEG
+	// and MPEG-1 do.
+	SitingCenter
+)
+
+var (
+	ErrInvalidSize = errors.New("invalid size")
+	ErrUnaligned   = errors.New("image origin not aligned to chroma samples")
+)
+
+// weights are in 2.14 fixed point; the horizontal pass keeps 6 bits of
+// fraction for the vertical one.
+const (
+	weightBits = 14
+	interBits  = 6
+)
+
+// filterTable resamples a line of samples: output i is the sum of taps
+// source samples from start[i] on, weighted by weights[i*taps:].
+type filterTable struct {
+	taps    int
+	start   []int
+	weights []int16
+}
+
+// newFilterTable computes the table from src to dst samples; sub and off
+// place sample i of the plane at luma position sub*i+off.
+func newFilterTable(f Filter, src, dst, sub int, off float64) filterTable {
+	// in samples of the plane
+	scale := float64(src) / float64(dst)
+	n := (src + sub - 1) / sub
+	m := (dst + sub - 1) / sub
+	support, stretch := 0.0, math.Max(scale, 1)
+	switch f {
+	case Bilinear:
+		support = stretch
+	case Bicubic:
+		support = 2 * stretch
+	case Area:
+		support = (scale + 1) / 2
+	}
+	t := filterTable{start: make([]int, m)}
+	w := make([][]float64, m)
+	for i := range w {
+		// centre of output sample i, mapped through luma positions
+		c := ((float64(sub*i)+off+0.5)*scale - 0.5 - off) / float64(sub)
+		first := int(math.Ceil(c - support))
+		if f == Nearest {
+			first = int(math.Floor(c + 0.5))
+		}
+		var taps []float64
+		for j := first; j <= int(math.Floor(c+support)) || j == first; j++ {
+			k := 1.0
+			switch x := float64(j) - c; f {
+			case Nearest:
+			case Bilinear:
+				k = math.Max(1-math.Abs(x)/stretch, 0)
+			case Bicubic:
+				k = catmullRom(x / stretch)
+			case Area:
+				// overlap of [j-0.5, j+0.5) with the footprint of the output
+				lo, hi := math.Max(x-0.5, -scale/2), math.Min(x+0.5, scale/2)
+				k = math.Max(hi-lo, 0)
+			}
+			// samples beyond the edges repeat the edge samples
+			clamped := j
+			if clamped < 0 {
+				clamped = 0
+			} else if clamped > n-1 {
+				clamped = n - 1
+			}
+			if len(taps) == 0 {
+				t.start[i] = clamped
+			}
+			if d := clamped - t.start[i]; d == len(taps) {
+				taps = append(taps, k)
+			} else {
+				taps[d] += k
+			}
+		}
+		w[i] = taps
+		if len(taps) > t.taps {
+			t.taps = len(taps)
+		}
+	}
+	t.weights = make([]int16, m*t.taps)
+	for i, taps := range w {
+		// keep the taps within the line
+		shift := 0
+		if over := t.start[i] + t.taps - n; over > 0 {
+			shift = over
+			t.start[i] -= over
+		}
+		var sum float64
+		for _, k := range taps {
+			sum += k
+		}
+		fixed := t.weights[i*t.taps : (i+1)*t.taps]
+		total, largest := 0, shift
+		for j, k := range taps {
+			fixed[shift+j] = int16(math.Round(k / sum * (1 << weightBits)))
+			total += int(fixed[shift+j])
+			if fixed[shift+j] > fixed[largest] {
+				largest = shift + j
+			}
+		}
+		// the weights of each sample add up to exactly one
+		fixed[largest] += int16(1<<weightBits - total)
+	}
+	return t
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// planeScaler scales one plane: horizontally into an intermediate buffer,
+// then vertically.
+type planeScaler struct {
+	x, y filterTable
+}
+
+// horizontal resamples source rows [r0, r1) of len(x.start) samples into tmp.
+func (p *planeScaler) horizontal(tmp []int16, src []byte, stride, r0, r1 int) {
+	t := &p.x
+	m := len(t.start)
+	for row := r0; row < r1; row++ {
+		line, out := src[row*stride:], tmp[row*m:(row+1)*m]
+		for i := range out {
+			s := line[t.start[i] : t.start[i]+t.taps]
+			w := t.weights[i*t.taps : (i+1)*t.taps]
+			var sum int32
+			for k, v := range s {
+				sum += int32(w[k]) * int32(v)
+			}
+			out[i] = int16((sum + 1<<(weightBits-interBits-1)) >> (weightBits - interBits))
+		}
+	}
+}
+
+// vertical resamples tmp into output rows [r0, r1) of dst.
+func (p *planeScaler) vertical(dst []byte, stride int, tmp []int16, r0, r1 int) {
+	t := &p.y
+	m := len(p.x.start)
+	for row := r0; row < r1; row++ {
+		out := dst[row*stride : row*stride+m]
+		w := t.weights[row*t.taps : (row+1)*t.taps]
+		in := tmp[t.start[row]*m:]
+		for i := range out {
+			var sum int32
+			for k, wk := range w {
+				sum += int32(wk) * int32(in[k*m+i])
+			}
+			v := (sum + 1<<(weightBits+interBits-1)) >> (weightBits + interBits)
+			if v < 0 {
+				v = 0
+			} else if v > 255 {
+				v = 255
+			}
+			out[i] = uint8(v)
+		}
+	}
+}
+
+// Scaler resamples Y'CbCr images of one size to another with the tables of a
+// filter computed once, without allocating per frame. A Scaler must not be
+// used concurrently.
+type Scaler struct {
+	src, dst     image.Point
+	ratio        image.YCbCrSubsampleRatio
+	luma, chroma planeScaler
+	tmp          []int16
+}
+
+// NewScaler returns a Scaler from images of size src to images of size dst,
+// both with the subsample ratio sr and chroma sited as siting.
+func NewScaler(dst, src image.Point, sr image.YCbCrSubsampleRatio, f Filter, siting ChromaSiting) (*Scaler, error) {
+	if src.X <= 0 || src.Y <= 0 || dst.X <= 0 || dst.Y <= 0 {
+		return nil, ErrInvalidSize
+	}
+	if f < Nearest || f > Area {
+		return nil, fmt.Errorf("unsupported filter %v", f)
+	}
+	bw, bh := ratio(sr)
+	offX, offY := float64(bw-1)/2, float64(bh-1)/2
+	if siting == SitingLeft {
+		offX = 0
+	}
+	s := &Scaler{
+		src:   src,
+		dst:   dst,
+		ratio: sr,
+		luma: planeScaler{
+			x: newFilterTable(f, src.X, dst.X, 1, 0),
+			y: newFilterTable(f, src.Y, dst.Y, 1, 0),
+		},
+		chroma: planeScaler{
+			x: newFilterTable(f, src.X, dst.X, bw, offX),
+			y: newFilterTable(f, src.Y, dst.Y, bh, offY),
+		},
+	}
+	s.tmp = make([]int16, dst.X*src.Y)
+	return s, nil
+}
+
+// Scale resamples src into dst. They must have the sizes and subsample ratio
+// the Scaler was made for, and origins on chroma sample boundaries.
+func (s *Scaler) Scale(dst, src *image.YCbCr) error {
+	if src.Rect.Size() != s.src || dst.Rect.Size() != s.dst || src.SubsampleRatio != s.ratio || dst.SubsampleRatio != s.ratio {
+		return ErrDestinationMismatch
+	}
+	bw, bh := ratio(s.ratio)
+	if src.Rect.Min.X%bw != 0 || src.Rect.Min.Y%bh != 0 || dst.Rect.Min.X%bw != 0 || dst.Rect.Min.Y%bh != 0 {
+		return ErrUnaligned
+	}
+	n := Parallelism()
+	s.scalePlane(&s.luma, dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.YStride,
+		src.Y[src.YOffset(src.Rect.Min.X, src.Rect.Min.Y):], src.YStride, n)
+	si, di := src.COffset(src.Rect.Min.X, src.Rect.Min.Y), dst.COffset(dst.Rect.Min.X, dst.Rect.Min.Y)
+	s.scalePlane(&s.chroma, dst.Cb[di:], dst.CStride, src.Cb[si:], src.CStride, n)
+	s.scalePlane(&s.chroma, dst.Cr[di:], dst.CStride, src.Cr[si:], src.CStride, n)
+	return nil
+}
+
+func (s *Scaler) scalePlane(p *planeScaler, dst []byte, dstStride int, src []byte, srcStride, n int) {
+	// the source rows the vertical pass reads, as x.start tells for columns
+	rows := p.y.start[len(p.y.start)-1] + p.y.taps
+	tmp := s.tmp[:rows*len(p.x.start)]
+	if k := stripeCount(n, rows); k > 1 {
+		stripes(k, rows, func(r0, r1 int) { p.horizontal(tmp, src, srcStride, r0, r1) })
+	} else {
+		p.horizontal(tmp, src, srcStride, 0, rows)
+	}
+	if k := stripeCount(n, len(p.y.start)); k > 1 {
+		stripes(k, len(p.y.start), func(r0, r1 int) { p.vertical(dst, dstStride, tmp, r0, r1) })
+	} else {
+		p.vertical(dst, dstStride, tmp, 0, len(p.y.start))
+	}
+}
+
+// Scale returns src resampled to w×h pixels with f, with chroma sited as
+// H.264 does; see Scaler to scale a stream of frames.
+func Scale(src *image.YCbCr, w, h int, f Filter) (*image.YCbCr, error) {
+	s, err := NewScaler(image.Pt(w, h), src.Rect.Size(), src.SubsampleRatio, f, SitingLeft)
+	if err != nil {
+		return nil, err
+	}
+	dst := image.NewYCbCr(image.Rect(0, 0, w, h), src.SubsampleRatio)
+	if err = s.Scale(dst, src); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
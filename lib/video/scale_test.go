@@ -0,0 +1,181 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+var filters = []Filter{Nearest, Bilinear, Bicubic, Area}
+
+func filled(r image.Rectangle, sr image.YCbCrSubsampleRatio, fill func(plane, i int) uint8) *image.YCbCr {
+	img := image.NewYCbCr(r, sr)
+	for p, b := range [][]byte{img.Y, img.Cb, img.Cr} {
+		for i := range b {
+			b[i] = fill(p, i)
+		}
+	}
+	return img
+}
+
+func TestScaleIdentity(t *testing.T) {
+	src := filled(image.Rect(0, 0, 37, 23), image.YCbCrSubsampleRatio420, func(p, i int) uint8 { return uint8(i*7 + p) })
+	for _, f := range filters {
+		dst, err := Scale(src, 37, 23, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dst, src) {
+			t.Errorf("%v: scaling to the same size changed the image", f)
+		}
+	}
+}
+
+func TestScaleConstant(t *testing.T) {
+	sizes := []struct{ src, dst image.Point }{
+		{image.Pt(64, 48), image.Pt(32, 24)},
+		{image.Pt(1280, 720), image.Pt(320, 180)},
+		{image.Pt(641, 479), image.Pt(320, 181)},
+		{image.Pt(33, 17), image.Pt(100, 61)},
+		{image.Pt(1, 1), image.Pt(5, 3)},
+		{image.Pt(7, 5), image.Pt(1, 1)},
+	}
+	for _, sr := range []image.YCbCrSubsampleRatio{image.YCbCrSubsampleRatio420, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio444} {
+		for _, size := range sizes {
+			src := filled(image.Rectangle{Max: size.src}, sr, func(p, _ int) uint8 { return uint8(40 + 80*p) })
+			for _, f := range filters {
+				dst, err := Scale(src, size.dst.X, size.dst.Y, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for p, b := range [][]byte{dst.Y, dst.Cb, dst.Cr} {
+					for i, v := range b {
+						if v != uint8(40+80*p) {
+							t.Fatalf("%v %v→%v %v: plane %d sample %d is %d", sr, size.src, size.dst, f, p, i, v)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestScaleArea(t *testing.T) {
+	src := filled(image.Rect(0, 0, 16, 8), image.YCbCrSubsampleRatio444, func(_, i int) uint8 { return uint8(i * 37) })
+	dst, err := Scale(src, 8, 4, Area)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			a, b := src.Y[2*y*16+2*x:], src.Y[(2*y+1)*16+2*x:]
+			expected := uint8((int(a[0]) + int(a[1]) + int(b[0]) + int(b[1]) + 2) >> 2)
+			if v := dst.Y[y*8+x]; v != expected {
+				t.Errorf("(%d, %d): expected %d, got %d", x, y, expected, v)
+			}
+		}
+	}
+}
+
+func TestScaleNearest(t *testing.T) {
+	src := filled(image.Rect(0, 0, 6, 4), image.YCbCrSubsampleRatio420, func(_, i int) uint8 { return uint8(i * 11) })
+	dst, err := Scale(src, 12, 8, Nearest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 12; x++ {
+			if dst.Y[y*12+x] != src.Y[y/2*6+x/2] {
+				t.Fatalf("(%d, %d): expected %d, got %d", x, y, src.Y[y/2*6+x/2], dst.Y[y*12+x])
+			}
+		}
+	}
+}
+
+func TestScaleSiting(t *testing.T) {
+	// a horizontal ramp of chroma, 40 per sample, doubled with bilinear: an
+	// output chroma sample at luma column 2i lies at source luma column
+	// i-1/4 with left siting, at i-1/2 with centred siting
+	src := filled(image.Rect(0, 0, 16, 4), image.YCbCrSubsampleRatio420, func(p, i int) uint8 { return uint8(40 * (i % 8)) })
+	for _, tc := range []struct {
+		siting ChromaSiting
+		offset int // of the output samples, in 1/40 of a source sample
+	}{
+		{SitingLeft, -5},
+		{SitingCenter, -10},
+	} {
+		s, err := NewScaler(image.Pt(32, 8), image.Pt(16, 4), src.SubsampleRatio, Bilinear, tc.siting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := image.NewYCbCr(image.Rect(0, 0, 32, 8), src.SubsampleRatio)
+		if err = s.Scale(dst, src); err != nil {
+			t.Fatal(err)
+		}
+		// away from the edges
+		for i := 2; i < 13; i++ {
+			if expected := 20*i + tc.offset; int(dst.Cb[i]) != expected {
+				t.Errorf("%v: sample %d: expected %d, got %d", tc.siting, i, expected, dst.Cb[i])
+			}
+		}
+	}
+}
+
+func TestScalerErrors(t *testing.T) {
+	if _, err := NewScaler(image.Pt(0, 4), image.Pt(4, 4), image.YCbCrSubsampleRatio420, Area, SitingLeft); err != ErrInvalidSize {
+		t.Errorf("expected ErrInvalidSize, got %v", err)
+	}
+	s, err := NewScaler(image.Pt(4, 4), image.Pt(8, 8), image.YCbCrSubsampleRatio420, Area, SitingLeft)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := image.NewYCbCr(image.Rect(0, 0, 9, 9), image.YCbCrSubsampleRatio420)
+	dst := image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio420)
+	if err = s.Scale(dst, src.SubImage(image.Rect(0, 0, 8, 8)).(*image.YCbCr)); err != nil {
+		t.Error(err)
+	}
+	if err = s.Scale(dst, src); err != ErrDestinationMismatch {
+		t.Errorf("expected ErrDestinationMismatch, got %v", err)
+	}
+	if err = s.Scale(dst, src.SubImage(image.Rect(1, 1, 9, 9)).(*image.YCbCr)); err != ErrUnaligned {
+		t.Errorf("expected ErrUnaligned, got %v", err)
+	}
+}
+
+func TestScalerAllocs(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 128, 72), image.YCbCrSubsampleRatio420)
+	dst := image.NewYCbCr(image.Rect(0, 0, 48, 27), image.YCbCrSubsampleRatio420)
+	for _, f := range filters {
+		s, err := NewScaler(dst.Rect.Size(), src.Rect.Size(), src.SubsampleRatio, f, SitingLeft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { s.Scale(dst, src) }); allocs != 0 {
+			t.Errorf("%v: %v allocations per frame", f, allocs)
+		}
+	}
+}
+
+func BenchmarkScale(b *testing.B) {
+	src := image.NewYCbCr(image.Rect(0, 0, 1280, 720), image.YCbCrSubsampleRatio420)
+	for _, size := range []image.Point{{320, 180}, {1920, 1080}} {
+		for _, f := range filters {
+			size, f := size, f
+			b.Run(fmt.Sprintf("%dx%d/%v", size.X, size.Y, f), func(b *testing.B) {
+				s, err := NewScaler(size, src.Rect.Size(), src.SubsampleRatio, f, SitingLeft)
+				if err != nil {
+					b.Fatal(err)
+				}
+				dst := image.NewYCbCr(image.Rectangle{Max: size}, src.SubsampleRatio)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := s.Scale(dst, src); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package video
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minStripeRows keeps stripes large enough to be worth a goroutine.
+const minStripeRows = 32
+
+var parallelism int32 = 1
+
+// SetParallelism sets into how many horizontal stripes decoding and
+// conversion split a frame by default, to be processed concurrently, and
+// returns the previous setting. n < 1 means runtime.GOMAXPROCS(0); the
+// default is 1, all on the calling goroutine. The results do not depend on
+// it. See also Decoder.Parallelism.
+func SetParallelism(n int) int {
+	return int(atomic.SwapInt32(&parallelism, int32(n)))
+}
+
+// Parallelism returns the setting of SetParallelism.
+func Parallelism() int { return int(atomic.LoadInt32(&parallelism)) }
+
+// stripeCount limits the stripes of a parallelism setting n to those of
+// rows worth a goroutine each.
+func stripeCount(n, rows int) int {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if max := rows / minStripeRows; n > max {
+		n = max
+	}
+	return n
+}
+
+type stripeTask struct {
+	fn     func(y0, y1 int)
+	y0, y1 int
+	wg     *sync.WaitGroup
+}
+
+var (
+	stripeWorkers      chan stripeTask
+	startStripeWorkers sync.Once
+)
+
+// stripes calls fn for n horizontal stripes of rows, see stripeCount,
+// together covering [0, rows), and returns when all are done. The stripes
+// start at even rows so that they do not share 4:2:0 chroma rows. One stripe
+// runs on the calling goroutine, the others on a pool of one worker per CPU;
+// stripes no worker is free for run on the calling goroutine too.
+//
+// The closure passed as fn escapes; callers call the function it wraps
+// directly when n is 1, so that the serial path does not allocate.
+func stripes(n, rows int, fn func(y0, y1 int)) {
+	if n <= 1 {
+		fn(0, rows)
+		return
+	}
+	startStripeWorkers.Do(func() {
+		stripeWorkers = make(chan stripeTask)
+		for i := 0; i < runtime.NumCPU(); i++ {
+			go func() {
+				for t := range stripeWorkers {
+					t.fn(t.y0, t.y1)
+					t.wg.Done()
+				}
+			}()
+		}
+	})
+	step := (rows/n + 1) &^ 1
+	var wg sync.WaitGroup
+	y := 0
+	for ; y+step < rows; y += step {
+		wg.Add(1)
+		select {
+		case stripeWorkers <- stripeTask{fn, y, y + step, &wg}:
+		default:
+			fn(y, y+step)
+			wg.Done()
+		}
+	}
+	fn(y, rows)
+	wg.Wait()
+}
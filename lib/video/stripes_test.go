@@ -0,0 +1,124 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+func TestStripes(t *testing.T) {
+	for _, rows := range []int{1, 63, 64, 65, 127, 1080, 2161} {
+		for n := 1; n <= 8; n++ {
+			var mu sync.Mutex
+			covered := make([]int, rows)
+			stripes(stripeCount(n, rows), rows, func(y0, y1 int) {
+				mu.Lock()
+				defer mu.Unlock()
+				if y0%2 != 0 {
+					t.Errorf("%d rows, %d stripes: stripe starts at odd row %d", rows, n, y0)
+				}
+				for y := y0; y < y1; y++ {
+					covered[y]++
+				}
+			})
+			for y, c := range covered {
+				if c != 1 {
+					t.Fatalf("%d rows, %d stripes: row %d covered %d times", rows, n, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestDecoderParallelism(t *testing.T) {
+	formats := []PixelFormat{
+		format.I420, format.I422, format.I444, format.NV12, format.NV21, format.YUY2, format.UYVY,
+		format.ARGB, format.BGRA, format.RGBA, format.RAW,
+	}
+	for _, f := range formats {
+		for _, size := range []image.Point{{320, 240}, {161, 131}} {
+			for _, c := range []Colorimetry{{}, {BT709, FullRange}} {
+				buf := make([]byte, FrameSize(f, size.X, size.Y))
+				for i := range buf {
+					buf[i] = uint8(i*7 + i>>8)
+				}
+				var expected *image.YCbCr
+				for _, parallelism := range []int{1, 2, 3, 8, -1} {
+					// decoding RGB frames rearranges them in place
+					raw := NewRawFrame(f, append([]byte(nil), buf...), size.X, size.Y)
+					d := Decoder{Parallelism: parallelism}
+					frame, err := d.DecodeToYUV420(raw, c)
+					if err != nil {
+						t.Fatal(err)
+					}
+					yuv := cloneYCbCr(frame.Image.(*image.YCbCr))
+					if expected == nil {
+						expected = yuv
+					} else if !reflect.DeepEqual(yuv, expected) {
+						t.Errorf("%s %v %v: parallelism %d differs from the serial result", f, size, c, parallelism)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestConvertParallelism(t *testing.T) {
+	defer SetParallelism(SetParallelism(1))
+	rgba := image.NewRGBA(image.Rect(2, 4, 300, 203))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i * 13)
+	}
+	yuv := image.NewYCbCr(rgba.Rect, image.YCbCrSubsampleRatio444)
+	for _, p := range [][]byte{yuv.Y, yuv.Cb, yuv.Cr} {
+		for i := range p {
+			p[i] = uint8(i * 5)
+		}
+	}
+	for _, src := range []image.Image{rgba, yuv, rgba.SubImage(image.Rect(3, 5, 300, 203))} {
+		var expected, expectedRecolored *image.YCbCr
+		for _, parallelism := range []int{1, 4} {
+			SetParallelism(parallelism)
+			dst := image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio420)
+			if err := ConvertTo(dst, src, Colorimetry{}); err != nil {
+				t.Fatal(err)
+			}
+			recolored := image.NewYCbCr(dst.Rect, dst.SubsampleRatio)
+			if err := RecolorTo(recolored, dst, Colorimetry{}, JPEG); err != nil {
+				t.Fatal(err)
+			}
+			if expected == nil {
+				expected, expectedRecolored = dst, recolored
+				continue
+			}
+			if !reflect.DeepEqual(dst, expected) {
+				t.Errorf("%T %v: ConvertTo differs in parallel", src, src.Bounds())
+			}
+			if !reflect.DeepEqual(recolored, expectedRecolored) {
+				t.Errorf("%T %v: RecolorTo differs in parallel", src, src.Bounds())
+			}
+		}
+	}
+}
+
+func BenchmarkDecoderParallelism(b *testing.B) {
+	for _, f := range []PixelFormat{format.NV12, format.YUY2, format.ARGB} {
+		for _, parallelism := range []int{1, -1} {
+			f, parallelism := f, parallelism
+			b.Run(fmt.Sprintf("%s/%d", f, parallelism), func(b *testing.B) {
+				raw := NewRawFrame(f, make([]byte, FrameSize(f, 3840, 2160)), 3840, 2160)
+				d := Decoder{Parallelism: parallelism}
+				b.SetBytes(int64(len(raw.Data)))
+				for i := 0; i < b.N; i++ {
+					if _, err := d.DecodeToYUV420(raw, Colorimetry{}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
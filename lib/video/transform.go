@@ -0,0 +1,183 @@
+package video
+
+import (
+	"fmt"
+	"image"
+)
+
+// Rotation is a clockwise rotation in degrees, a multiple of 90.
+type Rotation int
+
+const (
+	Rotate0   Rotation = 0
+	Rotate90  Rotation = 90
+	Rotate180 Rotation = 180
+	Rotate270 Rotation = 270
+)
+
+// Transform crops an image, then mirrors, flips and rotates it, in this
+// order. The zero value leaves images as they are.
+type Transform struct {
+	Crop   image.Rectangle // in the coordinates of the source; empty for all of it
+	Mirror bool            // left to right
+	Flip   bool            // top to bottom
+	Rotate Rotation
+}
+
+// Size returns the size of the result of t on an image of bounds r.
+func (t Transform) Size(r image.Rectangle) image.Point {
+	if !t.Crop.Empty() {
+		r = t.Crop
+	}
+	if t.rotation()%180 != 0 {
+		return image.Pt(r.Dy(), r.Dx())
+	}
+	return r.Size()
+}
+
+// SubsampleRatio returns the subsample ratio t gives an image of ratio sr:
+// rotating by 90° turns 4:2:2 into 4:4:0 and back, and 4:1:1 and 4:1:0,
+// which have no rotated counterparts, into 4:2:0.
+func (t Transform) SubsampleRatio(sr image.YCbCrSubsampleRatio) image.YCbCrSubsampleRatio {
+	if t.rotation()%180 == 0 {
+		return sr
+	}
+	switch sr {
+	case image.YCbCrSubsampleRatio422:
+		return image.YCbCrSubsampleRatio440
+	case image.YCbCrSubsampleRatio440:
+		return image.YCbCrSubsampleRatio422
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return image.YCbCrSubsampleRatio420
+	}
+	return sr
+}
+
+func (t Transform) rotation() Rotation {
+	return (t.Rotate%360 + 360) % 360
+}
+
+// Apply returns the result of t on src in a new image.
+func (t Transform) Apply(src *image.YCbCr) (*image.YCbCr, error) {
+	dst := image.NewYCbCr(image.Rectangle{Max: t.Size(src.Rect)}, t.SubsampleRatio(src.SubsampleRatio))
+	if err := t.ApplyTo(dst, src); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// ApplyTo writes the result of t on src into dst, of the size t gives and
+// with its origin on a chroma sample boundary. It does not allocate. Chroma
+// is copied sample by sample if dst has the subsample ratio t gives and the
+// chroma samples of the crop are whole; otherwise each sample of dst is
+// averaged from those of src under it.
+func (t Transform) ApplyTo(dst, src *image.YCbCr) error {
+	if t.Rotate%90 != 0 {
+		return fmt.Errorf("unsupported rotation %d", t.Rotate)
+	}
+	crop := t.Crop
+	if crop.Empty() {
+		crop = src.Rect
+	} else if !crop.In(src.Rect) {
+		return ErrInvalidSize
+	}
+	if dst.Rect.Size() != t.Size(src.Rect) {
+		return ErrDestinationMismatch
+	}
+	dbw, dbh := ratio(dst.SubsampleRatio)
+	if dst.Rect.Min.X%dbw != 0 || dst.Rect.Min.Y%dbh != 0 {
+		return ErrUnaligned
+	}
+	w, h := crop.Dx(), crop.Dy()
+	dw, dh := dst.Rect.Dx(), dst.Rect.Dy()
+	remapPlane(dst.Y[dst.YOffset(dst.Rect.Min.X, dst.Rect.Min.Y):], dst.YStride, dw, dh,
+		src.Y[src.YOffset(crop.Min.X, crop.Min.Y):], src.YStride, t.mapping(w, h))
+
+	di := dst.COffset(dst.Rect.Min.X, dst.Rect.Min.Y)
+	cdw, cdh := (dw+dbw-1)/dbw, (dh+dbh-1)/dbh
+	bw, bh := ratio(src.SubsampleRatio)
+	whole := crop.Min.X%bw == 0 && crop.Min.Y%bh == 0
+	if t != (Transform{Crop: t.Crop}) {
+		// reversed or swapped axes need whole samples at the far edges too
+		whole = whole && w%bw == 0 && h%bh == 0
+	}
+	if whole && dst.SubsampleRatio == t.SubsampleRatio(src.SubsampleRatio) {
+		si := src.COffset(crop.Min.X, crop.Min.Y)
+		m := t.mapping((w+bw-1)/bw, (h+bh-1)/bh)
+		remapPlane(dst.Cb[di:], dst.CStride, cdw, cdh, src.Cb[si:], src.CStride, m)
+		remapPlane(dst.Cr[di:], dst.CStride, cdw, cdh, src.Cr[si:], src.CStride, m)
+		return nil
+	}
+	m := t.mapping(w, h)
+	for cy := 0; cy < cdh; cy++ {
+		for cx := 0; cx < cdw; cx++ {
+			var sb, sr, n int
+			for y := cy * dbh; y < (cy+1)*dbh && y < dh; y++ {
+				for x := cx * dbw; x < (cx+1)*dbw && x < dw; x++ {
+					sx, sy := m.at(x, y)
+					ci := src.COffset(crop.Min.X+sx, crop.Min.Y+sy)
+					sb += int(src.Cb[ci])
+					sr += int(src.Cr[ci])
+					n++
+				}
+			}
+			dst.Cb[di+cy*dst.CStride+cx] = uint8((sb + n/2) / n)
+			dst.Cr[di+cy*dst.CStride+cx] = uint8((sr + n/2) / n)
+		}
+	}
+	return nil
+}
+
+// mapping gives the source of sample (x, y) of a transformed plane as
+// (x0 + xx*x + xy*y, y0 + yx*x + yy*y).
+type mapping struct{ x0, xx, xy, y0, yx, yy int }
+
+func (m mapping) at(x, y int) (int, int) {
+	return m.x0 + m.xx*x + m.xy*y, m.y0 + m.yx*x + m.yy*y
+}
+
+// mapping returns the mapping of t for a cropped plane of w×h samples.
+func (t Transform) mapping(w, h int) mapping {
+	var m mapping
+	// undo the rotation
+	switch t.rotation() {
+	case Rotate0:
+		m.xx, m.yy = 1, 1
+	case Rotate90:
+		m.xy, m.y0, m.yx = 1, h-1, -1
+	case Rotate180:
+		m.x0, m.xx, m.y0, m.yy = w-1, -1, h-1, -1
+	case Rotate270:
+		m.x0, m.xy, m.yx = w-1, -1, 1
+	}
+	// then the flips
+	if t.Mirror {
+		m.x0, m.xx, m.xy = w-1-m.x0, -m.xx, -m.xy
+	}
+	if t.Flip {
+		m.y0, m.yx, m.yy = h-1-m.y0, -m.yx, -m.yy
+	}
+	return m
+}
+
+// remapPlane fills w×h samples of dst from src through m, in coordinates
+// relative to the starts of the slices.
+func remapPlane(dst []byte, dstStride, w, h int, src []byte, srcStride int, m mapping) {
+	step := m.xx + m.yx*srcStride
+	if step == 1 {
+		for y := 0; y < h; y++ {
+			x, sy := m.at(0, y)
+			copy(dst[y*dstStride:y*dstStride+w], src[sy*srcStride+x:])
+		}
+		return
+	}
+	for y := 0; y < h; y++ {
+		x, sy := m.at(0, y)
+		p := sy*srcStride + x
+		row := dst[y*dstStride : y*dstStride+w]
+		for i := range row {
+			row[i] = src[p]
+			p += step
+		}
+	}
+}
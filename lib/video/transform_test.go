@@ -0,0 +1,173 @@
+package video
+
+import (
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// transformed returns the pixel of the source at (x, y) of the result of t,
+// relative to the origins, step by step; the crop is w×h.
+func transformed(t Transform, w, h, x, y int) (int, int) {
+	if t.rotation()%180 != 0 {
+		w, h = h, w
+	}
+	for r := t.rotation(); r > 0; r -= 90 {
+		// undo a quarter turn of an image w wide
+		x, y = y, w-1-x
+		w, h = h, w
+	}
+	if t.Mirror {
+		x = w - 1 - x
+	}
+	if t.Flip {
+		y = h - 1 - y
+	}
+	return x, y
+}
+
+var transforms = []Transform{
+	{},
+	{Mirror: true},
+	{Flip: true},
+	{Rotate: Rotate90},
+	{Rotate: Rotate180},
+	{Rotate: Rotate270},
+	{Rotate: -90},
+	{Mirror: true, Rotate: Rotate90},
+	{Flip: true, Mirror: true, Rotate: Rotate270},
+	{Crop: image.Rect(2, 4, 12, 10)},
+	{Crop: image.Rect(1, 3, 10, 8), Rotate: Rotate90},
+	{Crop: image.Rect(3, 1, 14, 10), Mirror: true, Rotate: Rotate180},
+}
+
+func TestTransform(t *testing.T) {
+	for _, sr := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+	} {
+		src := filled(image.Rect(0, 0, 16, 12), sr, func(p, i int) uint8 { return uint8(i*13 + p*50) })
+		for _, tr := range transforms {
+			dst, err := tr.Apply(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			crop := tr.Crop
+			if crop.Empty() {
+				crop = src.Rect
+			}
+			if bw, bh := ratio(sr); crop.Min.X%bw != 0 || crop.Min.Y%bh != 0 || crop.Dx()%bw != 0 || crop.Dy()%bh != 0 {
+				// luma only, chroma is averaged
+				for y := 0; y < dst.Rect.Dy(); y++ {
+					for x := 0; x < dst.Rect.Dx(); x++ {
+						sx, sy := transformed(tr, crop.Dx(), crop.Dy(), x, y)
+						if dst.Y[dst.YOffset(x, y)] != src.Y[src.YOffset(crop.Min.X+sx, crop.Min.Y+sy)] {
+							t.Fatalf("%v %+v: luma mismatch at (%d, %d)", sr, tr, x, y)
+						}
+					}
+				}
+				continue
+			}
+			for y := 0; y < dst.Rect.Dy(); y++ {
+				for x := 0; x < dst.Rect.Dx(); x++ {
+					sx, sy := transformed(tr, crop.Dx(), crop.Dy(), x, y)
+					if dst.YCbCrAt(x, y) != src.YCbCrAt(crop.Min.X+sx, crop.Min.Y+sy) {
+						t.Fatalf("%v %+v: mismatch at (%d, %d)", sr, tr, x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+// to444 returns img with its chroma samples repeated for every pixel.
+func to444(img *image.YCbCr) *image.YCbCr {
+	c := image.NewYCbCr(img.Rect, image.YCbCrSubsampleRatio444)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			p := img.YCbCrAt(x, y)
+			i := c.YOffset(x, y)
+			c.Y[i], c.Cb[i], c.Cr[i] = p.Y, p.Cb, p.Cr
+		}
+	}
+	return c
+}
+
+func TestTransformChroma(t *testing.T) {
+	// odd crops average the chroma under each sample, as subsampling the
+	// transformed 4:4:4 image does
+	src := filled(image.Rect(0, 0, 16, 12), image.YCbCrSubsampleRatio420, func(p, i int) uint8 { return uint8(i*29 + p*50) })
+	for _, tr := range transforms {
+		for _, crop := range []image.Rectangle{{}, image.Rect(1, 1, 12, 10), image.Rect(3, 2, 10, 11)} {
+			tr.Crop = crop
+			t.Run(fmt.Sprintf("%+v", tr), func(t *testing.T) {
+				full, err := tr.Apply(to444(src))
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := image.NewYCbCr(full.Rect, image.YCbCrSubsampleRatio420)
+				if err = ConvertTo(expected, full, Colorimetry{}); err != nil {
+					t.Fatal(err)
+				}
+				dst, err := tr.Apply(src)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(dst, expected) {
+					t.Errorf("expected\n%+v\ngot\n%+v", expected, dst)
+				}
+			})
+		}
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 8, 6), image.YCbCrSubsampleRatio420)
+	if _, err := (Transform{Crop: image.Rect(4, 4, 10, 6)}).Apply(src); err != ErrInvalidSize {
+		t.Errorf("expected ErrInvalidSize, got %v", err)
+	}
+	if _, err := (Transform{Rotate: 45}).Apply(src); err == nil {
+		t.Error("expected an error for a rotation of 45°")
+	}
+	dst := image.NewYCbCr(image.Rect(0, 0, 8, 6), image.YCbCrSubsampleRatio420)
+	if err := (Transform{Rotate: Rotate90}).ApplyTo(dst, src); err != ErrDestinationMismatch {
+		t.Errorf("expected ErrDestinationMismatch, got %v", err)
+	}
+	if err := (Transform{}).ApplyTo(dst.SubImage(image.Rect(1, 1, 8, 6)).(*image.YCbCr), src); err != ErrDestinationMismatch {
+		t.Errorf("expected ErrDestinationMismatch, got %v", err)
+	}
+	crop := Transform{Crop: image.Rect(0, 0, 7, 5)}
+	if err := crop.ApplyTo(dst.SubImage(image.Rect(1, 1, 8, 6)).(*image.YCbCr), src); err != ErrUnaligned {
+		t.Errorf("expected ErrUnaligned, got %v", err)
+	}
+}
+
+func TestTransformAllocs(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420)
+	for _, tr := range transforms {
+		dst := image.NewYCbCr(image.Rectangle{Max: tr.Size(src.Rect)}, image.YCbCrSubsampleRatio420)
+		if allocs := testing.AllocsPerRun(10, func() { tr.ApplyTo(dst, src) }); allocs != 0 {
+			t.Errorf("%+v: %v allocations per frame", tr, allocs)
+		}
+	}
+}
+
+func BenchmarkTransform(b *testing.B) {
+	src := image.NewYCbCr(image.Rect(0, 0, 1920, 1080), image.YCbCrSubsampleRatio420)
+	for _, tr := range []Transform{{Mirror: true}, {Rotate: Rotate90}, {Rotate: Rotate180}} {
+		tr := tr
+		b.Run(fmt.Sprintf("%+v", tr), func(b *testing.B) {
+			dst := image.NewYCbCr(image.Rectangle{Max: tr.Size(src.Rect)}, image.YCbCrSubsampleRatio420)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tr.ApplyTo(dst, src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
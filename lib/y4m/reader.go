@@ -0,0 +1,86 @@
+package y4m
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"strings"
+)
+
+type Reader struct {
+	r *bufio.Reader
+	h Header
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = ErrInvalidHeader
+		}
+		return nil, err
+	}
+	var rd = Reader{r: br}
+	if err = rd.h.parse(strings.TrimSuffix(line, "\n")); err != nil {
+		return nil, err
+	}
+	return &rd, nil
+}
+
+func (r *Reader) Header() Header { return r.h }
+
+func (r *Reader) readFrameHeader() error {
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && len(line) != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if !strings.HasPrefix(line, frameHeader) {
+		return ErrInvalidFrameHeader
+	}
+	return nil
+}
+
+// ReadRaw reads the next frame as packed Y, Cb and Cr planes into buf, which
+// must hold at least Header().FrameSize() bytes.
+func (r *Reader) ReadRaw(buf []byte) (int, error) {
+	size := r.h.FrameSize()
+	if len(buf) < size {
+		return 0, io.ErrShortBuffer
+	}
+	if err := r.readFrameHeader(); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(r.r, buf[:size]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// ReadFrame reads the next frame into a newly allocated image, and returns
+// io.EOF at the end of the stream.
+func (r *Reader) ReadFrame() (*image.YCbCr, error) {
+	ratio, err := r.h.ColorSpace.SubsampleRatio()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewYCbCr(image.Rect(0, 0, r.h.Width, r.h.Height), ratio)
+	if err = r.readFrameHeader(); err != nil {
+		return nil, err
+	}
+	for _, p := range [][]byte{img.Y, img.Cb, img.Cr} {
+		if _, err = io.ReadFull(r.r, p); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return img, nil
+}
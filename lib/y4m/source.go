@@ -0,0 +1,86 @@
+package y4m
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+)
+
+type Property = capture.Property
+
+// Source plays a Y4M file back as a capture source, paced at its frame rate.
+type Source struct {
+	f     *os.File
+	r     *Reader
+	p     Property
+	frame int64
+	start time.Time
+}
+
+// NewSource opens the file named by p.DeviceID. The negotiated property is
+// taken from the stream header, regardless of what p requests.
+func NewSource(p Property) (*Source, error) {
+	f, err := os.Open(p.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	h := r.Header()
+	s := Source{f: f, r: r}
+	s.p.DeviceID = p.DeviceID
+	s.p.Width, s.p.Height = h.Width, h.Height
+	s.p.FrameRate = h.FrameRate.Float64()
+	s.p.Colorimetry.Range = h.ColorRange()
+	switch h.ColorSpace {
+	case C422:
+		s.p.PixelFormat = format.I422
+	case C444:
+		s.p.PixelFormat = format.I444
+	default:
+		s.p.PixelFormat = format.I420
+	}
+	return &s, nil
+}
+
+func (s *Source) BufferSize() int    { return s.r.Header().FrameSize() }
+func (s *Source) Property() Property { return s.p }
+func (s *Source) Close()             { s.f.Close() }
+
+// ReadVideoFrame reads the next frame, and returns io.EOF at the end of the
+// file.
+func (s *Source) ReadVideoFrame(ctx context.Context, buf []byte) (info capture.FrameInfo, err error) {
+	if s.p.FrameRate > 0 {
+		info.PTS = time.Duration(float64(s.frame) * float64(time.Second) / s.p.FrameRate)
+		info.Duration = time.Duration(float64(time.Second) / s.p.FrameRate)
+		if s.frame == 0 {
+			s.start = time.Now()
+		} else if err = capture.Sleep(ctx, time.Until(s.start.Add(info.PTS))); err != nil {
+			return capture.FrameInfo{}, err
+		}
+	} else if err = ctx.Err(); err != nil {
+		return capture.FrameInfo{}, err
+	}
+	if info.Size, err = s.r.ReadRaw(buf); err != nil {
+		return capture.FrameInfo{}, err
+	}
+	info.Sequence = uint64(s.frame)
+	s.frame++
+	return info, nil
+}
+
+func init() {
+	capture.Register("y4m", capture.DriverFunc(func(p capture.Property) (capture.Source, error) {
+		s, err := NewSource(p)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}))
+}
@@ -0,0 +1,122 @@
+package y4m
+
+import (
+	"bufio"
+	"image"
+	"io"
+
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+type Writer struct {
+	w     *bufio.Writer
+	h     Header
+	ratio image.YCbCrSubsampleRatio
+}
+
+// NewWriter writes the stream header to w. An empty h.ColorSpace defaults to
+// 420jpeg.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	if h.Width <= 0 || h.Height <= 0 || h.Width > MaxSize || h.Height > MaxSize {
+		return nil, ErrInvalidHeader
+	}
+	if h.ColorSpace == "" {
+		h.ColorSpace = C420jpeg
+	}
+	ratio, err := h.ColorSpace.SubsampleRatio()
+	if err != nil {
+		return nil, err
+	}
+	wr := Writer{w: bufio.NewWriter(w), h: h, ratio: ratio}
+	if _, err = wr.w.WriteString(h.String() + "\n"); err != nil {
+		return nil, err
+	}
+	return &wr, wr.w.Flush()
+}
+
+// HeaderFor returns the header of a stream of frames like img, a
+// *image.YCbCr or a video.Frame of one, with the colour space derived from
+// its subsample ratio. 4:2:0 frames decoded from raw 4:2:0 Y'CbCr formats
+// keep the left chroma siting of cameras, 420mpeg2; JPEG frames, those
+// converted from RGB and plain images are taken to be centred, 420jpeg, as
+// image/jpeg and video.Convert make them.
+func HeaderFor(img image.Image, frameRate Ratio) (Header, error) {
+	yuv, ok := video.ImageOf(img).(*image.YCbCr)
+	if !ok {
+		return Header{}, ErrFrameMismatch
+	}
+	cs, err := colorSpaceOf(yuv.SubsampleRatio)
+	if err != nil {
+		return Header{}, err
+	}
+	if cs == C420jpeg && leftSited(img) {
+		cs = C420mpeg2
+	}
+	return Header{
+		Width:      yuv.Rect.Dx(),
+		Height:     yuv.Rect.Dy(),
+		FrameRate:  frameRate,
+		Interlace:  Progressive,
+		ColorSpace: cs,
+	}, nil
+}
+
+// leftSited reports whether img is a frame decoded from a raw 4:2:0 Y'CbCr
+// format.
+func leftSited(img image.Image) bool {
+	var f video.PixelFormat
+	switch frame := img.(type) {
+	case video.Frame:
+		f = frame.Format
+	case *video.Frame:
+		f = frame.Format
+	}
+	d, ok := f.Descriptor()
+	return ok && !d.RGB && d.Layout != format.Compressed && d.SubY == 2
+}
+
+// NewWriterFor is like NewWriter with the header given by HeaderFor.
+func NewWriterFor(w io.Writer, img image.Image, frameRate Ratio) (*Writer, error) {
+	h, err := HeaderFor(img, frameRate)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(w, h)
+}
+
+func (w *Writer) Header() Header { return w.h }
+
+// WriteFrame writes frame, a *image.YCbCr or a video.Frame of one, which
+// must match the size and subsample ratio of the stream, honouring its
+// strides and origin.
+func (w *Writer) WriteFrame(frame image.Image) error {
+	img, ok := video.ImageOf(frame).(*image.YCbCr)
+	if !ok {
+		return ErrFrameMismatch
+	}
+	r := img.Rect
+	if r.Dx() != w.h.Width || r.Dy() != w.h.Height || img.SubsampleRatio != w.ratio {
+		return ErrFrameMismatch
+	}
+	if _, err := w.w.WriteString(frameHeader + "\n"); err != nil {
+		return err
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.YOffset(r.Min.X, y)
+		if _, err := w.w.Write(img.Y[i : i+r.Dx()]); err != nil {
+			return err
+		}
+	}
+	cw, ch := chromaSize(w.ratio, w.h.Width, w.h.Height)
+	for _, p := range [][]byte{img.Cb, img.Cr} {
+		i0 := img.COffset(r.Min.X, r.Min.Y)
+		for y := 0; y < ch; y++ {
+			i := i0 + y*img.CStride
+			if _, err := w.w.Write(p[i : i+cw]); err != nil {
+				return err
+			}
+		}
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,218 @@
+package y4m
+
+// ref: https://wiki.multimedia.cx/index.php/YUV4MPEG2
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+const (
+	signature   = "YUV4MPEG2"
+	frameHeader = "FRAME"
+)
+
+// MaxSize bounds the width and height of streams read, so that a corrupt
+// header cannot make readers allocate gigabytes, nor frame sizes overflow an
+// int on 32-bit platforms.
+const MaxSize = 16384
+
+var (
+	ErrInvalidHeader         = errors.New("y4m: invalid stream header")
+	ErrInvalidFrameHeader    = errors.New("y4m: invalid frame header")
+	ErrUnsupportedColorSpace = errors.New("y4m: unsupported colour space")
+	ErrFrameMismatch         = errors.New("y4m: frame does not match stream header")
+)
+
+type Ratio struct{ Num, Den int }
+
+func (r Ratio) String() string { return fmt.Sprintf("%d:%d", r.Num, r.Den) }
+func (r Ratio) Float64() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func parseRatio(s string) (r Ratio, err error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return r, ErrInvalidHeader
+	}
+	if r.Num, err = strconv.Atoi(s[:i]); err != nil {
+		return r, ErrInvalidHeader
+	}
+	if r.Den, err = strconv.Atoi(s[i+1:]); err != nil {
+		return r, ErrInvalidHeader
+	}
+	return r, nil
+}
+
+type Interlace byte
+
+const (
+	InterlaceUnknown Interlace = '?'
+	Progressive      Interlace = 'p'
+	TopFieldFirst    Interlace = 't'
+	BottomFieldFirst Interlace = 'b'
+	MixedModes       Interlace = 'm'
+)
+
+type ColorSpace string
+
+const (
+	C420jpeg  ColorSpace = "420jpeg"  // 4:2:0, chroma centred between luma samples
+	C420mpeg2 ColorSpace = "420mpeg2" // 4:2:0, chroma co-sited horizontally
+	C420paldv ColorSpace = "420paldv" // 4:2:0, chroma co-sited with Cr on odd lines
+	C422      ColorSpace = "422"
+	C444      ColorSpace = "444"
+)
+
+func (c ColorSpace) SubsampleRatio() (image.YCbCrSubsampleRatio, error) {
+	switch c {
+	case C420jpeg, C420mpeg2, C420paldv, "420":
+		return image.YCbCrSubsampleRatio420, nil
+	case C422:
+		return image.YCbCrSubsampleRatio422, nil
+	case C444:
+		return image.YCbCrSubsampleRatio444, nil
+	}
+	return 0, ErrUnsupportedColorSpace
+}
+
+func colorSpaceOf(r image.YCbCrSubsampleRatio) (ColorSpace, error) {
+	switch r {
+	case image.YCbCrSubsampleRatio420:
+		return C420jpeg, nil
+	case image.YCbCrSubsampleRatio422:
+		return C422, nil
+	case image.YCbCrSubsampleRatio444:
+		return C444, nil
+	}
+	return "", ErrUnsupportedColorSpace
+}
+
+// Header is the stream header; zero values of the optional fields are left
+// out when written, and default to progressive 420jpeg when read.
+type Header struct {
+	Width, Height int
+	FrameRate     Ratio
+	AspectRatio   Ratio // pixel aspect ratio, 0:0 if unknown
+	Interlace     Interlace
+	ColorSpace    ColorSpace
+	Params        []string // X parameters, without the leading `X'
+}
+
+func (h *Header) parse(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != signature {
+		return ErrInvalidHeader
+	}
+	*h = Header{Interlace: Progressive, ColorSpace: C420jpeg}
+	var err error
+	for _, f := range fields[1:] {
+		v := f[1:]
+		switch f[0] {
+		case 'W':
+			h.Width, err = strconv.Atoi(v)
+		case 'H':
+			h.Height, err = strconv.Atoi(v)
+		case 'F':
+			h.FrameRate, err = parseRatio(v)
+		case 'A':
+			h.AspectRatio, err = parseRatio(v)
+		case 'I':
+			if len(v) != 1 {
+				return ErrInvalidHeader
+			}
+			h.Interlace = Interlace(v[0])
+		case 'C':
+			h.ColorSpace = ColorSpace(v)
+			_, err = h.ColorSpace.SubsampleRatio()
+		case 'X':
+			h.Params = append(h.Params, v)
+		}
+		if err != nil {
+			if err == ErrUnsupportedColorSpace {
+				return err
+			}
+			return ErrInvalidHeader
+		}
+	}
+	if h.Width <= 0 || h.Height <= 0 || h.Width > MaxSize || h.Height > MaxSize {
+		return ErrInvalidHeader
+	}
+	return nil
+}
+
+func (h Header) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s W%d H%d", signature, h.Width, h.Height)
+	if h.FrameRate.Num != 0 {
+		fmt.Fprintf(&b, " F%s", h.FrameRate)
+	}
+	if h.Interlace != 0 {
+		fmt.Fprintf(&b, " I%c", h.Interlace)
+	}
+	if h.AspectRatio.Num != 0 {
+		fmt.Fprintf(&b, " A%s", h.AspectRatio)
+	}
+	if h.ColorSpace != "" {
+		fmt.Fprintf(&b, " C%s", h.ColorSpace)
+	}
+	for _, p := range h.Params {
+		fmt.Fprintf(&b, " X%s", p)
+	}
+	return b.String()
+}
+
+// colorRangeParam is the X parameter ffmpeg uses to tag the sample range.
+const colorRangeParam = "COLORRANGE="
+
+// ColorRange returns the sample range tagged by an XCOLORRANGE parameter,
+// limited if there is none.
+func (h Header) ColorRange() video.ColorRange {
+	for _, p := range h.Params {
+		if strings.HasPrefix(p, colorRangeParam) && strings.EqualFold(p[len(colorRangeParam):], "FULL") {
+			return video.FullRange
+		}
+	}
+	return video.LimitedRange
+}
+
+// SetColorRange tags the sample range with an XCOLORRANGE parameter.
+func (h *Header) SetColorRange(r video.ColorRange) {
+	params := h.Params[:0:0]
+	for _, p := range h.Params {
+		if !strings.HasPrefix(p, colorRangeParam) {
+			params = append(params, p)
+		}
+	}
+	v := "LIMITED"
+	if r == video.FullRange {
+		v = "FULL"
+	}
+	h.Params = append(params, colorRangeParam+v)
+}
+
+// FrameSize returns the size of the raw planes of one frame.
+func (h Header) FrameSize() int {
+	r, _ := h.ColorSpace.SubsampleRatio()
+	cw, ch := chromaSize(r, h.Width, h.Height)
+	return h.Width*h.Height + 2*cw*ch
+}
+
+func chromaSize(r image.YCbCrSubsampleRatio, width, height int) (int, int) {
+	switch r {
+	case image.YCbCrSubsampleRatio420:
+		return (width + 1) / 2, (height + 1) / 2
+	case image.YCbCrSubsampleRatio422:
+		return (width + 1) / 2, height
+	}
+	return width, height
+}
@@ -0,0 +1,236 @@
+package y4m
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zyxar/mediastream/lib/capture"
+	"github.com/zyxar/mediastream/lib/format"
+	"github.com/zyxar/mediastream/lib/video"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected Header
+		err      error
+	}{
+		{
+			"YUV4MPEG2 W640 H480 F30000:1001 It A1:1 C420mpeg2 XYSCSS=420MPEG2",
+			Header{640, 480, Ratio{30000, 1001}, Ratio{1, 1}, TopFieldFirst, C420mpeg2, []string{"YSCSS=420MPEG2"}},
+			nil,
+		},
+		{
+			"YUV4MPEG2 W2 H2",
+			Header{Width: 2, Height: 2, Interlace: Progressive, ColorSpace: C420jpeg},
+			nil,
+		},
+		{"YUV4MPEG2 W2 H2 C444alpha", Header{}, ErrUnsupportedColorSpace},
+		{"YUV4MPEG2 W2 H2 Fx:1", Header{}, ErrInvalidHeader},
+		{"YUV4MPEG2 H2", Header{}, ErrInvalidHeader},
+		{"YUV4MPEG2 W16385 H2", Header{}, ErrInvalidHeader},
+		{"YUV4MPEG2 W2 H99999999999999999999", Header{}, ErrInvalidHeader},
+		{"YUV4MPEG2 W-2 H2", Header{}, ErrInvalidHeader},
+		{"YUV4MPEG W2 H2", Header{}, ErrInvalidHeader},
+	}
+	for _, tt := range tests {
+		var h Header
+		err := h.parse(tt.line)
+		if err != tt.err {
+			t.Errorf("%q: expected error %v, got %v", tt.line, tt.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(h, tt.expected) {
+			t.Errorf("%q: expected\n%+v\ngot\n%+v", tt.line, tt.expected, h)
+		}
+	}
+}
+
+func TestHeaderString(t *testing.T) {
+	h := Header{640, 480, Ratio{30, 1}, Ratio{1, 1}, Progressive, C422, []string{"COLORRANGE=FULL"}}
+	const expected = "YUV4MPEG2 W640 H480 F30:1 Ip A1:1 C422 XCOLORRANGE=FULL"
+	if h.String() != expected {
+		t.Errorf("expected %q, got %q", expected, h.String())
+	}
+}
+
+func TestHeaderColorRange(t *testing.T) {
+	h := Header{Params: []string{"YSCSS=420JPEG", "COLORRANGE=LIMITED"}}
+	if r := h.ColorRange(); r != video.LimitedRange {
+		t.Errorf("expected limited range, got %v", r)
+	}
+	h.SetColorRange(video.FullRange)
+	if r := h.ColorRange(); r != video.FullRange {
+		t.Errorf("expected full range, got %v", r)
+	}
+	if !reflect.DeepEqual(h.Params, []string{"YSCSS=420JPEG", "COLORRANGE=FULL"}) {
+		t.Errorf("unexpected params %q", h.Params)
+	}
+	if r := (Header{}).ColorRange(); r != video.LimitedRange {
+		t.Errorf("expected limited range by default, got %v", r)
+	}
+}
+
+func newTestImage(ratio image.YCbCrSubsampleRatio, width, height int) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, width, height), ratio)
+	for i := range img.Y {
+		img.Y[i] = byte(i)
+	}
+	for i := range img.Cb {
+		img.Cb[i] = byte(0x80 + i)
+		img.Cr[i] = byte(0x40 + i)
+	}
+	return img
+}
+
+func TestRoundTrip(t *testing.T) {
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio444,
+	}
+	for _, ratio := range ratios {
+		var buf bytes.Buffer
+		src := newTestImage(ratio, 6, 5)
+		w, err := NewWriterFor(&buf, src, Ratio{25, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err = w.WriteFrame(src); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.WriteFrame(newTestImage(ratio, 4, 4)); err != ErrFrameMismatch {
+			t.Errorf("expected ErrFrameMismatch, got %v", err)
+		}
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := r.Header(); h.Width != 6 || h.Height != 5 || h.FrameRate != (Ratio{25, 1}) {
+			t.Errorf("unexpected header: %+v", h)
+		}
+		for i := 0; i < 2; i++ {
+			img, err := r.ReadFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(src, img) {
+				t.Errorf("%v: expected\n%+v\ngot\n%+v", ratio, src, img)
+			}
+		}
+		if _, err = r.ReadFrame(); err != io.EOF {
+			t.Errorf("expected EOF, got %v", err)
+		}
+	}
+}
+
+func TestHeaderFor(t *testing.T) {
+	img420 := newTestImage(image.YCbCrSubsampleRatio420, 4, 4)
+	img422 := newTestImage(image.YCbCrSubsampleRatio422, 4, 4)
+	tests := []struct {
+		name string
+		img  image.Image
+		cs   ColorSpace
+		err  error
+	}{
+		{"image", img420, C420jpeg, nil},
+		{"I420", video.Frame{Image: img420, Format: format.I420}, C420mpeg2, nil},
+		{"NV12 pointer", &video.Frame{Image: img420, Format: format.NV12}, C420mpeg2, nil},
+		{"MJPG", video.Frame{Image: img420, Format: format.MJPG}, C420jpeg, nil},
+		{"RGBA", video.Frame{Image: img420, Format: format.RGBA}, C420jpeg, nil},
+		{"YUY2", video.Frame{Image: img422, Format: format.YUY2}, C422, nil},
+		{"RGB image", image.NewRGBA(image.Rect(0, 0, 4, 4)), "", ErrFrameMismatch},
+	}
+	for _, tt := range tests {
+		h, err := HeaderFor(tt.img, Ratio{})
+		if err != tt.err || h.ColorSpace != tt.cs {
+			t.Errorf("%s: expected %q, %v, got %q, %v", tt.name, tt.cs, tt.err, h.ColorSpace, err)
+		}
+	}
+}
+
+func TestWriteSubImage(t *testing.T) {
+	var buf bytes.Buffer
+	src := newTestImage(image.YCbCrSubsampleRatio420, 8, 8)
+	sub := src.SubImage(image.Rect(2, 2, 6, 6)).(*image.YCbCr)
+	w, err := NewWriterFor(&buf, sub, Ratio{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteFrame(sub); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if img.YCbCrAt(x, y) != sub.YCbCrAt(x+2, y+2) {
+				t.Fatalf("pixel mismatch at (%d, %d)", x, y)
+			}
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.y4m")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := newTestImage(image.YCbCrSubsampleRatio420, 4, 4)
+	w, err := NewWriterFor(f, src, Ratio{1000, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = w.WriteFrame(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	s, err := capture.Open("y4m:"+name, capture.Property{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p := s.Property()
+	if p.PixelFormat != format.I420 || p.Width != 4 || p.Height != 4 || p.FrameRate != 1000 {
+		t.Errorf("unexpected property: %#v", p)
+	}
+	buf := make([]byte, s.BufferSize())
+	for i := 0; i < 3; i++ {
+		info, err := s.ReadVideoFrame(context.Background(), buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Sequence != uint64(i) || info.PTS != time.Duration(i)*time.Millisecond {
+			t.Errorf("unexpected timing: %+v", info.Timing)
+		}
+		frame, err := video.Decode(info.Raw(p, buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(src, frame.Image) {
+			t.Errorf("expected\n%+v\ngot\n%+v", src, frame.Image)
+		}
+	}
+	if _, err = s.ReadVideoFrame(context.Background(), buf); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}